@@ -0,0 +1,467 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+)
+
+// asyncInsertMetricsName is the name of the metrics record of AsyncInserter flushes.
+const asyncInsertMetricsName = "trpc.ClickHouseAsyncInsert"
+
+// ErrAsyncInserterClosed is returned when rows are inserted into a closed AsyncInserter.
+var ErrAsyncInserterClosed = errors.New("clickhouse async inserter is closed")
+
+// AsyncFlushError is the error of a failed flush of an AsyncInserter.
+type AsyncFlushError struct {
+	Table    string // Table the rows are inserted into
+	Rows     int    // Number of rows of the flush
+	Attempts int    // Number of failed attempts of the rows
+	Dropped  bool   // Whether the rows are dropped, since the retries are exhausted or the inserter is closed
+	Err      error
+}
+
+// Error implements error.
+func (e *AsyncFlushError) Error() string {
+	return fmt.Sprintf("clickhouse async insert %d rows into %s, attempts %d, dropped %t: %v",
+		e.Rows, e.Table, e.Attempts, e.Dropped, e.Err)
+}
+
+// Unwrap returns the error of the flush.
+func (e *AsyncFlushError) Unwrap() error {
+	return e.Err
+}
+
+// AsyncInserterOption is the option of an AsyncInserter.
+type AsyncInserterOption func(*asyncInserterOptions)
+
+// asyncInserterOptions is the options of an AsyncInserter.
+type asyncInserterOptions struct {
+	flushRows       int
+	flushBytes      int
+	flushInterval   time.Duration
+	maxPendingBytes int
+	retryMax        int
+	retryBackoff    time.Duration
+	errorHandler    func(*AsyncFlushError)
+}
+
+// WithAsyncFlushRows sets the number of buffered rows of a table which triggers a flush, 10000 by default.
+// A non-positive value disables flushing by rows.
+func WithAsyncFlushRows(n int) AsyncInserterOption {
+	return func(o *asyncInserterOptions) {
+		o.flushRows = n
+	}
+}
+
+// WithAsyncFlushBytes sets the estimated size of buffered rows of a table which triggers a flush, 8MB by default.
+// A non-positive value disables flushing by bytes.
+func WithAsyncFlushBytes(n int) AsyncInserterOption {
+	return func(o *asyncInserterOptions) {
+		o.flushBytes = n
+	}
+}
+
+// WithAsyncFlushInterval sets the interval of flushing all buffered rows, 1s by default.
+// Retries are also checked by the interval. A non-positive value is ignored.
+func WithAsyncFlushInterval(d time.Duration) AsyncInserterOption {
+	return func(o *asyncInserterOptions) {
+		o.flushInterval = d
+	}
+}
+
+// WithAsyncMaxPendingBytes sets the max estimated size of the rows buffered, being flushed
+// and waiting for retries, 256MB by default. Inserts are blocked when it is exceeded.
+// A non-positive value leaves the pending rows unbounded.
+func WithAsyncMaxPendingBytes(n int) AsyncInserterOption {
+	return func(o *asyncInserterOptions) {
+		o.maxPendingBytes = n
+	}
+}
+
+// WithAsyncRetry sets the max retries of a failed flush and the backoff of retries, 3 and 1s by default.
+// The delay before the n-th retry is backoff*2^(n-1), which stops growing at 1 minute or the backoff if longer.
+func WithAsyncRetry(max int, backoff time.Duration) AsyncInserterOption {
+	return func(o *asyncInserterOptions) {
+		o.retryMax = max
+		o.retryBackoff = backoff
+	}
+}
+
+// WithAsyncErrorHandler sets the handler called on each failed flush, which logs the error by default.
+func WithAsyncErrorHandler(handler func(*AsyncFlushError)) AsyncInserterOption {
+	return func(o *asyncInserterOptions) {
+		o.errorHandler = handler
+	}
+}
+
+// AsyncInserterStats is the statistics of an AsyncInserter.
+type AsyncInserterStats struct {
+	PendingRows      int           // Rows buffered, being flushed and waiting for retries
+	PendingBytes     int           // Estimated size of the pending rows
+	RetryRows        int           // Rows waiting for retries
+	FlushedRows      int64         // Rows inserted successfully
+	DroppedRows      int64         // Rows dropped after the retries
+	LastFlushLatency time.Duration // Latency of the last flush
+}
+
+// asyncBatch is the rows of a table to be flushed.
+type asyncBatch struct {
+	table    string
+	rows     []batchRow
+	bytes    int
+	attempts int
+	retryAt  time.Time
+}
+
+// AsyncInserter buffers rows of tables in memory, and inserts them by batches of Client in the background.
+// The rows of a table are flushed when they reach the rows or bytes limit, or by the flush interval.
+// Failed flushes are retried with backoff, and the pending rows are bounded in size,
+// so inserts are blocked when clickhouse can not keep up.
+type AsyncInserter struct {
+	cli  Client
+	opts asyncInserterOptions
+
+	mu       sync.Mutex
+	buffers  map[string]*asyncBatch
+	ready    []*asyncBatch
+	retries  []*asyncBatch
+	released chan struct{} // closed when pending rows are released
+	closed   bool
+	stats    AsyncInserterStats
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	cancel  context.CancelFunc // cancels the flush in the background
+}
+
+// NewAsyncInserter creates an AsyncInserter of the clickhouse client, and starts flushing in the background.
+// Close must be called to flush the remaining rows.
+func NewAsyncInserter(cli Client, opts ...AsyncInserterOption) *AsyncInserter {
+	i := &AsyncInserter{
+		cli: cli,
+		opts: asyncInserterOptions{
+			flushRows:       10000,
+			flushBytes:      8 << 20,
+			flushInterval:   time.Second,
+			maxPendingBytes: 256 << 20,
+			retryMax:        3,
+			retryBackoff:    time.Second,
+			errorHandler: func(err *AsyncFlushError) {
+				log.Errorf("%v", err)
+			},
+		},
+		buffers:  make(map[string]*asyncBatch),
+		released: make(chan struct{}),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&i.opts)
+	}
+	if i.opts.flushInterval <= 0 {
+		i.opts.flushInterval = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	i.cancel = cancel
+	go i.run(ctx)
+	return i
+}
+
+// Insert buffers a row of the table by the values of its columns. The table may be followed by
+// the columns, such as "events (name, time)". It blocks until the pending rows have room for the row,
+// or ctx is done.
+func (i *AsyncInserter) Insert(ctx context.Context, table string, v ...interface{}) error {
+	return i.insert(ctx, table, batchRow{values: v})
+}
+
+// InsertStruct buffers a row of the table by a struct, whose fields are mapped to the columns by the ch tags.
+// It blocks until the pending rows have room for the row, or ctx is done.
+func (i *AsyncInserter) InsertStruct(ctx context.Context, table string, v interface{}) error {
+	if err := checkStruct(v); err != nil {
+		return err
+	}
+	return i.insert(ctx, table, batchRow{structure: v})
+}
+
+func (i *AsyncInserter) insert(ctx context.Context, table string, row batchRow) error {
+	size := row.size()
+	i.mu.Lock()
+	for !i.closed && i.opts.maxPendingBytes > 0 &&
+		i.stats.PendingBytes > 0 && i.stats.PendingBytes+size > i.opts.maxPendingBytes {
+		released := i.released
+		i.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+		i.mu.Lock()
+	}
+	defer i.mu.Unlock()
+	if i.closed {
+		return ErrAsyncInserterClosed
+	}
+
+	b, ok := i.buffers[table]
+	if !ok {
+		b = &asyncBatch{table: table}
+		i.buffers[table] = b
+	}
+	b.rows = append(b.rows, row)
+	b.bytes += size
+	i.stats.PendingRows++
+	i.stats.PendingBytes += size
+	if (i.opts.flushRows > 0 && len(b.rows) >= i.opts.flushRows) ||
+		(i.opts.flushBytes > 0 && b.bytes >= i.opts.flushBytes) {
+		delete(i.buffers, table)
+		i.ready = append(i.ready, b)
+		select {
+		case i.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Stats returns the statistics of the inserter.
+func (i *AsyncInserter) Stats() AsyncInserterStats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stats
+}
+
+// Close stops accepting rows, and flushes the remaining rows with retries until they are inserted
+// or dropped, or ctx is done, in which case the rows not inserted are dropped and ctx.Err() is returned.
+// If ctx is done while a flush is in progress in the background, the flush is canceled and Close returns
+// without waiting for it, whose rows are inserted or dropped once the client returns.
+func (i *AsyncInserter) Close(ctx context.Context) error {
+	i.mu.Lock()
+	if i.closed {
+		i.mu.Unlock()
+		return ErrAsyncInserterClosed
+	}
+	i.closed = true
+	i.release(nil)
+	i.mu.Unlock()
+	close(i.done)
+	defer i.cancel()
+	select {
+	case <-ctx.Done():
+		i.cancel()
+		i.drop(ctx.Err())
+		return ctx.Err()
+	case <-i.stopped:
+	}
+
+	for {
+		i.mu.Lock()
+		i.collect(time.Now())
+		i.mu.Unlock()
+		i.flushReady(ctx)
+
+		i.mu.Lock()
+		if len(i.retries) == 0 {
+			i.mu.Unlock()
+			return nil
+		}
+		retryAt := i.retries[0].retryAt
+		for _, b := range i.retries[1:] {
+			if b.retryAt.Before(retryAt) {
+				retryAt = b.retryAt
+			}
+		}
+		i.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(retryAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			i.drop(ctx.Err())
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// drop drops all the rows not being flushed, which are passed to the error handler with err.
+func (i *AsyncInserter) drop(err error) {
+	i.mu.Lock()
+	i.collect(time.Now())
+	for _, b := range i.retries {
+		i.stats.RetryRows -= len(b.rows)
+	}
+	dropped := append(i.ready, i.retries...)
+	i.ready, i.retries = nil, nil
+	for _, b := range dropped {
+		i.release(b)
+		i.stats.DroppedRows += int64(len(b.rows))
+	}
+	i.mu.Unlock()
+	for _, b := range dropped {
+		i.opts.errorHandler(&AsyncFlushError{
+			Table: b.table, Rows: len(b.rows), Attempts: b.attempts, Dropped: true, Err: err,
+		})
+	}
+}
+
+// run flushes the rows in the background until the inserter is closed or ctx is canceled.
+func (i *AsyncInserter) run(ctx context.Context) {
+	defer close(i.stopped)
+	ticker := time.NewTicker(i.opts.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.wake:
+		case <-ticker.C:
+			i.mu.Lock()
+			i.collect(time.Now())
+			i.mu.Unlock()
+		case <-i.done:
+			return
+		}
+		i.flushReady(ctx)
+	}
+}
+
+// collect moves all buffered rows and the retries due to the ready queue. It must be called with mu held.
+func (i *AsyncInserter) collect(now time.Time) {
+	for table, b := range i.buffers {
+		i.ready = append(i.ready, b)
+		delete(i.buffers, table)
+	}
+	retries := i.retries[:0]
+	for _, b := range i.retries {
+		if b.retryAt.After(now) {
+			retries = append(retries, b)
+			continue
+		}
+		i.ready = append(i.ready, b)
+		i.stats.RetryRows -= len(b.rows)
+	}
+	i.retries = retries
+}
+
+// flushReady flushes the ready queue until it is empty.
+func (i *AsyncInserter) flushReady(ctx context.Context) {
+	for {
+		i.mu.Lock()
+		if len(i.ready) == 0 {
+			i.mu.Unlock()
+			return
+		}
+		b := i.ready[0]
+		i.ready = i.ready[1:]
+		i.mu.Unlock()
+		i.flush(ctx, b)
+	}
+}
+
+// flush inserts the rows of b, and puts it into the retries if it fails, unless ctx is done.
+func (i *AsyncInserter) flush(ctx context.Context, b *asyncBatch) {
+	start := time.Now()
+	err := i.send(ctx, b)
+	latency := time.Since(start)
+
+	i.mu.Lock()
+	i.stats.LastFlushLatency = latency
+	dropped := false
+	if err == nil {
+		i.release(b)
+		i.stats.FlushedRows += int64(len(b.rows))
+	} else if b.attempts++; b.attempts > i.opts.retryMax || ctx.Err() != nil {
+		i.release(b)
+		i.stats.DroppedRows += int64(len(b.rows))
+		dropped = true
+	} else {
+		b.retryAt = time.Now().Add(i.retryDelay(b.attempts))
+		i.retries = append(i.retries, b)
+		i.stats.RetryRows += len(b.rows)
+	}
+	stats := i.stats
+	i.mu.Unlock()
+
+	i.report(b, err, latency, stats)
+	if err != nil {
+		i.opts.errorHandler(&AsyncFlushError{
+			Table: b.table, Rows: len(b.rows), Attempts: b.attempts, Dropped: dropped, Err: err,
+		})
+	}
+}
+
+// maxAsyncRetryDelay is the delay beyond which retries stop backing off, since the rows waiting for
+// retries are pending and block the inserts once they exceed the max pending bytes.
+const maxAsyncRetryDelay = time.Minute
+
+// retryDelay returns the delay before retrying the rows failed the given attempts.
+func (i *AsyncInserter) retryDelay(attempts int) time.Duration {
+	limit := maxAsyncRetryDelay
+	if i.opts.retryBackoff > limit {
+		limit = i.opts.retryBackoff
+	}
+	d := i.opts.retryBackoff
+	for n := 1; n < attempts && d < limit; n++ {
+		d *= 2
+	}
+	if d > limit {
+		return limit
+	}
+	return d
+}
+
+// send inserts the rows of b by a batch.
+func (i *AsyncInserter) send(ctx context.Context, b *asyncBatch) error {
+	batch, err := i.cli.PrepareBatch(ctx, "INSERT INTO "+b.table, WithBatchMaxRows(0), WithBatchMaxBytes(0))
+	if err != nil {
+		return err
+	}
+	for _, row := range b.rows {
+		if row.structure != nil {
+			err = batch.AppendStruct(row.structure)
+		} else {
+			err = batch.Append(row.values...)
+		}
+		if err != nil {
+			_ = batch.Abort()
+			return err
+		}
+	}
+	return batch.Send()
+}
+
+// release releases the pending rows of b, and wakes up the blocked inserts. It must be called with mu held.
+func (i *AsyncInserter) release(b *asyncBatch) {
+	if b != nil {
+		i.stats.PendingRows -= len(b.rows)
+		i.stats.PendingBytes -= b.bytes
+	}
+	close(i.released)
+	i.released = make(chan struct{})
+}
+
+// report reports the flush through trpc-go metrics, labelled by the table.
+func (i *AsyncInserter) report(b *asyncBatch, err error, latency time.Duration, stats AsyncInserterStats) {
+	var failures float64
+	if err != nil {
+		failures = 1
+	}
+	dimensions := []*metrics.Dimension{{Name: "table", Value: b.table}}
+	ms := []*metrics.Metrics{
+		metrics.NewMetrics("flush_rows", float64(len(b.rows)), metrics.PolicySUM),
+		metrics.NewMetrics("flush_failures", failures, metrics.PolicySUM),
+		metrics.NewMetrics("flush_latency_ms", float64(latency.Milliseconds()), metrics.PolicyAVG),
+		metrics.NewMetrics("pending_rows", float64(stats.PendingRows), metrics.PolicySET),
+		metrics.NewMetrics("pending_bytes", float64(stats.PendingBytes), metrics.PolicySET),
+		metrics.NewMetrics("retry_rows", float64(stats.RetryRows), metrics.PolicySET),
+	}
+	if err := metrics.ReportMultiDimensionMetricsX(asyncInsertMetricsName, dimensions, ms); err != nil {
+		log.Warnf("report clickhouse async insert metrics of %s err: %v", b.table, err)
+	}
+}
@@ -0,0 +1,334 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBatchClient is a Client whose batches record the rows sent.
+type fakeBatchClient struct {
+	Client
+	mu    sync.Mutex
+	sent  map[string][]interface{}
+	errs  []error // errors returned by the next sends
+	block chan struct{}
+}
+
+func newFakeBatchClient() *fakeBatchClient {
+	return &fakeBatchClient{sent: make(map[string][]interface{})}
+}
+
+func (c *fakeBatchClient) PrepareBatch(ctx context.Context, query string, opts ...BatchOption) (Batch, error) {
+	return &fakeClientBatch{ctx: ctx, cli: c, query: query}, nil
+}
+
+func (c *fakeBatchClient) send(ctx context.Context, query string, rows []interface{}) error {
+	if c.block != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.block:
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return err
+	}
+	c.sent[query] = append(c.sent[query], rows...)
+	return nil
+}
+
+func (c *fakeBatchClient) rows(query string) []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent[query]
+}
+
+type fakeClientBatch struct {
+	ctx   context.Context
+	cli   *fakeBatchClient
+	query string
+	rows  []interface{}
+}
+
+func (b *fakeClientBatch) Append(v ...interface{}) error {
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *fakeClientBatch) AppendStruct(v interface{}) error {
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *fakeClientBatch) Rows() int {
+	return len(b.rows)
+}
+
+func (b *fakeClientBatch) Flush() error {
+	return nil
+}
+
+func (b *fakeClientBatch) Send() error {
+	return b.cli.send(b.ctx, b.query, b.rows)
+}
+
+func (b *fakeClientBatch) Abort() error {
+	return nil
+}
+
+// waitFor waits for cond to be true for at most one second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition is not met in one second")
+		}
+	}
+}
+
+func TestAsyncInserter(t *testing.T) {
+	cli := newFakeBatchClient()
+	i := NewAsyncInserter(cli, WithAsyncFlushRows(2), WithAsyncFlushInterval(time.Hour))
+	ctx := context.Background()
+
+	// Flushed by rows of each table.
+	if err := i.Insert(ctx, "events", "a", 1); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	if err := i.InsertStruct(ctx, "users", &batchEvent{Name: "u"}); err != nil {
+		t.Fatalf("InsertStruct() err = %v", err)
+	}
+	if err := i.InsertStruct(ctx, "users", 1); err == nil {
+		t.Fatalf("InsertStruct() of non-struct succeeded")
+	}
+	if err := i.Insert(ctx, "events", "b", 2); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	waitFor(t, func() bool {
+		return len(cli.rows("INSERT INTO events")) == 2
+	})
+	want := []interface{}{[]interface{}{"a", 1}, []interface{}{"b", 2}}
+	if rows := cli.rows("INSERT INTO events"); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("events = %v, want %v", rows, want)
+	}
+	if rows := cli.rows("INSERT INTO users"); len(rows) != 0 {
+		t.Fatalf("users = %v, want none", rows)
+	}
+	if stats := i.Stats(); stats.PendingRows != 1 || stats.FlushedRows != 2 {
+		t.Fatalf("stats = %+v, want 1 pending and 2 flushed rows", stats)
+	}
+
+	// Remaining rows are flushed by Close.
+	if err := i.Close(ctx); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+	if rows := cli.rows("INSERT INTO users"); !reflect.DeepEqual(rows, []interface{}{&batchEvent{Name: "u"}}) {
+		t.Fatalf("users = %v, want u", rows)
+	}
+	stats := i.Stats()
+	if want := (AsyncInserterStats{FlushedRows: 3, LastFlushLatency: stats.LastFlushLatency}); stats != want {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+	if err := i.Insert(ctx, "events", "c", 3); !errors.Is(err, ErrAsyncInserterClosed) {
+		t.Fatalf("Insert() err = %v, want ErrAsyncInserterClosed", err)
+	}
+	if err := i.Close(ctx); !errors.Is(err, ErrAsyncInserterClosed) {
+		t.Fatalf("Close() err = %v, want ErrAsyncInserterClosed", err)
+	}
+}
+
+func TestAsyncInserter_FlushInterval(t *testing.T) {
+	cli := newFakeBatchClient()
+	i := NewAsyncInserter(cli, WithAsyncFlushRows(0), WithAsyncFlushBytes(0),
+		WithAsyncFlushInterval(10*time.Millisecond))
+	defer i.Close(context.Background())
+	if err := i.Insert(context.Background(), "events (name)", "a"); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	waitFor(t, func() bool {
+		return len(cli.rows("INSERT INTO events (name)")) == 1
+	})
+}
+
+func TestAsyncInserter_Backpressure(t *testing.T) {
+	cli := newFakeBatchClient()
+	cli.block = make(chan struct{})
+	i := NewAsyncInserter(cli, WithAsyncFlushRows(1), WithAsyncMaxPendingBytes(10))
+
+	// The first row is being flushed, so the second one has no room.
+	if err := i.Insert(context.Background(), "events", "12345678"); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := i.Insert(ctx, "events", "12345678"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Insert() err = %v, want context.DeadlineExceeded", err)
+	}
+
+	inserted := make(chan error)
+	go func() {
+		inserted <- i.Insert(context.Background(), "events", "12345678")
+	}()
+	close(cli.block)
+	if err := <-inserted; err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	if err := i.Close(context.Background()); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+	if rows := cli.rows("INSERT INTO events"); len(rows) != 2 {
+		t.Fatalf("events = %v, want 2 rows", rows)
+	}
+}
+
+func TestAsyncInserter_Retry(t *testing.T) {
+	cli := newFakeBatchClient()
+	sendErr := errors.New("send error")
+	cli.errs = []error{sendErr, sendErr, sendErr, sendErr}
+	var (
+		mu     sync.Mutex
+		errs   []*AsyncFlushError
+		report = func(err *AsyncFlushError) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		}
+	)
+	i := NewAsyncInserter(cli, WithAsyncFlushRows(1), WithAsyncFlushInterval(5*time.Millisecond),
+		WithAsyncRetry(2, time.Millisecond), WithAsyncErrorHandler(report))
+
+	// The first row is dropped after 2 retries, and the second one is inserted by the retry.
+	if err := i.Insert(context.Background(), "events", "a"); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	waitFor(t, func() bool {
+		return i.Stats().DroppedRows == 1
+	})
+	if err := i.Insert(context.Background(), "events", "b"); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	waitFor(t, func() bool {
+		return i.Stats().FlushedRows == 1
+	})
+	if rows := cli.rows("INSERT INTO events"); !reflect.DeepEqual(rows, []interface{}{[]interface{}{"b"}}) {
+		t.Fatalf("events = %v, want b", rows)
+	}
+	mu.Lock()
+	if len(errs) != 4 || errs[2].Attempts != 3 || !errs[2].Dropped ||
+		errs[3].Dropped || !errors.Is(errs[3], sendErr) {
+		t.Fatalf("errs = %+v, want the third one dropped after 3 attempts and the fourth one retried", errs)
+	}
+	mu.Unlock()
+	if err := i.Close(context.Background()); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+	stats := i.Stats()
+	want := AsyncInserterStats{FlushedRows: 1, DroppedRows: 1, LastFlushLatency: stats.LastFlushLatency}
+	if stats != want {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestAsyncInserter_CloseTimeout(t *testing.T) {
+	cli := newFakeBatchClient()
+	cli.errs = []error{errors.New("send error")}
+	var dropped []*AsyncFlushError
+	i := NewAsyncInserter(cli, WithAsyncFlushInterval(time.Hour), WithAsyncRetry(3, time.Hour),
+		WithAsyncErrorHandler(func(err *AsyncFlushError) {
+			if err.Dropped {
+				dropped = append(dropped, err)
+			}
+		}))
+	if err := i.Insert(context.Background(), "events", "a"); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := i.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close() err = %v, want context.DeadlineExceeded", err)
+	}
+	if len(dropped) != 1 || dropped[0].Rows != 1 || !errors.Is(dropped[0], context.DeadlineExceeded) {
+		t.Fatalf("dropped = %+v, want 1 row dropped by context.DeadlineExceeded", dropped)
+	}
+	if stats := i.Stats(); stats.DroppedRows != 1 || stats.PendingRows != 0 {
+		t.Fatalf("stats = %+v, want 1 dropped row and no pending rows", stats)
+	}
+}
+
+func TestAsyncInserter_CloseBlockedFlush(t *testing.T) {
+	cli := newFakeBatchClient()
+	cli.block = make(chan struct{})
+	var (
+		mu      sync.Mutex
+		dropped int
+	)
+	i := NewAsyncInserter(cli, WithAsyncFlushRows(1), WithAsyncFlushInterval(time.Hour),
+		WithAsyncErrorHandler(func(err *AsyncFlushError) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err.Dropped {
+				dropped += err.Rows
+			}
+		}))
+	// The first row is being flushed in the background, and the second one is buffered.
+	if err := i.Insert(context.Background(), "events", "a"); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+	waitFor(t, func() bool {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		return len(i.ready) == 0
+	})
+	if err := i.Insert(context.Background(), "events (name)", "b"); err != nil {
+		t.Fatalf("Insert() err = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := i.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close() err = %v, want context.DeadlineExceeded", err)
+	}
+	// The flush in the background is canceled, and its rows are dropped instead of retried.
+	waitFor(t, func() bool {
+		return i.Stats().DroppedRows == 2
+	})
+	mu.Lock()
+	if dropped != 2 {
+		t.Fatalf("dropped %d rows, want 2", dropped)
+	}
+	mu.Unlock()
+	if stats := i.Stats(); stats.PendingRows != 0 || stats.RetryRows != 0 {
+		t.Fatalf("stats = %+v, want no pending or retry rows", stats)
+	}
+	if rows := cli.rows("INSERT INTO events"); len(rows) != 0 {
+		t.Fatalf("events = %v, want none", rows)
+	}
+}
+
+func TestAsyncInserter_retryDelay(t *testing.T) {
+	i := &AsyncInserter{opts: asyncInserterOptions{retryBackoff: time.Second}}
+	for _, tt := range []struct {
+		backoff time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{time.Second, 1, time.Second},
+		{time.Second, 3, 4 * time.Second},
+		{time.Second, 100, maxAsyncRetryDelay},
+		{time.Hour, 3, time.Hour},
+		{0, 3, 0},
+	} {
+		i.opts.retryBackoff = tt.backoff
+		if got := i.retryDelay(tt.attempt); got != tt.want {
+			t.Errorf("retryDelay(%d) with backoff %v = %v, want %v", tt.attempt, tt.backoff, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/naming/bannednodes"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/naming/selector"
+)
+
+// Strategies of balancing the calls over the hosts of a DSN.
+const (
+	BalanceRoundRobin    = "round_robin"
+	BalanceLeastInflight = "least_inflight"
+	BalanceInOrder       = "in_order"
+)
+
+// defaultEjectCooldown is the default duration a host is ejected for after a connection error.
+const defaultEjectCooldown = 30 * time.Second
+
+// defaultIdleTimeout is the default duration after which the connection pool of a replica not selected is closed.
+const defaultIdleTimeout = 10 * time.Minute
+
+// maxSelectAttempts is the max attempts of a call to the replicas selected by the resolver,
+// which fails over to the replicas not tried on connection errors.
+const maxSelectAttempts = 3
+
+// balanceOptions is the options of balancing parsed from the DSN.
+type balanceOptions struct {
+	strategy    string
+	cooldown    time.Duration
+	resolver    string
+	idleTimeout time.Duration
+}
+
+// parseBalanceFromDSN parses the balancing parameters from the v2 dsn,
+// and returns the dsn without them. ok is false if the dsn is not balanced.
+//
+//	balance: round_robin, least_inflight or in_order, which enables balancing.
+//	eject_cooldown: the duration a host is ejected for after a connection error, 30s by default.
+//	resolver: the name of the tRPC selector, which selects a replica of each host as a service name per call.
+//	idle_timeout: the duration after which the pool of a replica not selected by the resolver is closed, 10m by default.
+func parseBalanceFromDSN(dsn string) (opts balanceOptions, remain string, ok bool, err error) {
+	idx := strings.IndexByte(dsn, '?')
+	if idx == -1 {
+		return opts, dsn, false, nil
+	}
+	q, err := url.ParseQuery(dsn[idx+1:])
+	if err != nil || q.Get("balance") == "" {
+		return opts, dsn, false, nil
+	}
+	opts.strategy = q.Get("balance")
+	switch opts.strategy {
+	case BalanceRoundRobin, BalanceLeastInflight, BalanceInOrder:
+	default:
+		return opts, dsn, false, fmt.Errorf("clickhouse dsn: unknown balance strategy %s", opts.strategy)
+	}
+	opts.cooldown = defaultEjectCooldown
+	if v := q.Get("eject_cooldown"); v != "" {
+		if opts.cooldown, err = time.ParseDuration(v); err != nil {
+			return opts, dsn, false, fmt.Errorf("clickhouse dsn: invalid eject_cooldown %s: %w", v, err)
+		}
+	}
+	opts.resolver = q.Get("resolver")
+	opts.idleTimeout = defaultIdleTimeout
+	if v := q.Get("idle_timeout"); v != "" {
+		if opts.idleTimeout, err = time.ParseDuration(v); err != nil {
+			return opts, dsn, false, fmt.Errorf("clickhouse dsn: invalid idle_timeout %s: %w", v, err)
+		}
+	}
+	for _, k := range []string{"balance", "eject_cooldown", "resolver", "idle_timeout"} {
+		q.Del(k)
+	}
+	remain = dsn[:idx]
+	if query := q.Encode(); query != "" {
+		remain += "?" + query
+	}
+	return opts, remain, true, nil
+}
+
+// hostState is the state of a host of a balancer.
+type hostState struct {
+	inflight     int64
+	ejectedUntil time.Time
+}
+
+// balancer spreads the calls of a multi-host DSN over its hosts, one connection pool per host.
+// Hosts failed by connection errors are ejected for a cooldown, and the calls fail over to the other hosts.
+// With the resolver, each host is a service name whose replica is selected by the tRPC selector per call,
+// so that the service router, load balancer and circuit breaker of the selector apply, and the results of
+// the calls are reported to the selector instead of ejecting the hosts.
+type balancer struct {
+	balanceOptions
+	dsn        string // dsn without the balancing parameters
+	begin, end int    // position of the hosts in dsn
+	next       uint64
+	mu         sync.Mutex
+	hosts      map[string]*hostState
+	selected   map[string]time.Time // last selected time of the dsns of the replicas selected by the resolver
+	sweptAt    time.Time            // time of the last sweep of the idle replicas
+	timeNow    func() time.Time
+	selectorFn func(name string) selector.Selector
+	// evict is called with the dsns of the idle replicas, whose connection pools are closed.
+	evict func(dsns []string)
+}
+
+// newBalancer creates a balancer of the v2 dsn, which returns nil if the dsn is not balanced.
+func newBalancer(dsn string) (*balancer, error) {
+	opts, remain, ok, err := parseBalanceFromDSN(dsn)
+	if err != nil || !ok {
+		return nil, err
+	}
+	begin, length, _ := (&URIHostExtractor{}).Extract(remain)
+	if length == 0 {
+		return nil, fmt.Errorf("clickhouse dsn: no hosts to balance in %s", remain)
+	}
+	return &balancer{
+		balanceOptions: opts,
+		dsn:            remain,
+		begin:          begin,
+		end:            begin + length,
+		hosts:          make(map[string]*hostState),
+		selected:       make(map[string]time.Time),
+		timeNow:        time.Now,
+		selectorFn:     selector.Get,
+	}, nil
+}
+
+// hostDSN returns the dsn of the host.
+func (b *balancer) hostDSN(host string) string {
+	return b.dsn[:b.begin] + host + b.dsn[b.end:]
+}
+
+// call calls fn with the dsn of the hosts picked by the strategy, failing over to the next host
+// on connection errors which ensure the request is not sent. It returns the dsn of the last host called.
+func (b *balancer) call(ctx context.Context, fn func(dsn string) error) (string, error) {
+	if b.resolver != "" {
+		return b.callSelected(ctx, fn)
+	}
+	var (
+		dsn string
+		err error
+	)
+	hosts := strings.Split(b.dsn[b.begin:b.end], ",")
+	tried := make(map[string]bool, len(hosts))
+	for len(tried) < len(hosts) {
+		host := b.pick(hosts, tried)
+		tried[host] = true
+		dsn = b.hostDSN(host)
+		state := b.start(host)
+		err = fn(dsn)
+		b.finish(state, err, true)
+		if err == nil || !isDialError(err) || ctx.Err() != nil {
+			return dsn, err
+		}
+	}
+	return dsn, err
+}
+
+// callSelected calls fn with the dsn of the replica selected by the resolver, of the service name picked
+// by the strategy. Connection errors are reported to the selector, and the calls failed to connect
+// fail over to the replicas not tried, which are excluded by the banned nodes of the context.
+func (b *balancer) callSelected(ctx context.Context, fn func(dsn string) error) (string, error) {
+	s := b.selectorFn(b.resolver)
+	if s == nil {
+		return "", fmt.Errorf("clickhouse balancer: unknown selector %s", b.resolver)
+	}
+	b.sweep()
+	var (
+		dsn string
+		err error
+	)
+	names := strings.Split(b.dsn[b.begin:b.end], ",")
+	tried := make(map[string]bool, len(names))
+	ctx = bannednodes.NewCtx(ctx, false)
+	for attempt := 0; attempt < maxSelectAttempts; attempt++ {
+		if len(tried) == len(names) {
+			tried = make(map[string]bool, len(names))
+		}
+		name := b.pick(names, tried)
+		tried[name] = true
+		node, selectErr := s.Select(name, selector.WithContext(ctx))
+		if selectErr != nil {
+			if err != nil {
+				return dsn, err
+			}
+			return "", fmt.Errorf("clickhouse balancer: select %s err: %w", name, selectErr)
+		}
+		dsn = b.hostDSN(node.Address)
+		state := b.start(name)
+		b.touch(dsn)
+		begin := time.Now()
+		err = fn(dsn)
+		b.finish(state, err, false)
+		b.report(s, node, time.Since(begin), err)
+		if err == nil || !isDialError(err) || ctx.Err() != nil {
+			return dsn, err
+		}
+	}
+	return dsn, err
+}
+
+// report reports the result of the call to the replica to the selector. Only connection errors are reported
+// as failures, which feed the circuit breaker of the selector, while other errors are of the queries.
+func (b *balancer) report(s selector.Selector, node *registry.Node, cost time.Duration, err error) {
+	if !isConnError(err) {
+		err = nil
+	}
+	_ = s.Report(node, cost, err)
+}
+
+// touch records the dsn of the replica selected.
+func (b *balancer) touch(dsn string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.selected[dsn] = b.timeNow()
+}
+
+// sweep evicts the replicas not selected for the idle timeout, checked once in the idle timeout.
+func (b *balancer) sweep() {
+	b.mu.Lock()
+	now := b.timeNow()
+	if now.Sub(b.sweptAt) < b.idleTimeout {
+		b.mu.Unlock()
+		return
+	}
+	b.sweptAt = now
+	var idle []string
+	for dsn, at := range b.selected {
+		if now.Sub(at) >= b.idleTimeout {
+			idle = append(idle, dsn)
+			delete(b.selected, dsn)
+		}
+	}
+	b.mu.Unlock()
+	if len(idle) > 0 && b.evict != nil {
+		b.evict(idle)
+	}
+}
+
+// pick picks a host not tried by the strategy. Ejected hosts are picked only if all the others are ejected.
+func (b *balancer) pick(hosts []string, tried map[string]bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.timeNow()
+	candidates := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if !tried[host] && !now.Before(b.state(host).ejectedUntil) {
+			candidates = append(candidates, host)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, host := range hosts {
+			if !tried[host] {
+				candidates = append(candidates, host)
+			}
+		}
+	}
+	switch b.strategy {
+	case BalanceInOrder:
+		return candidates[0]
+	case BalanceLeastInflight:
+		start := int(atomic.AddUint64(&b.next, 1) % uint64(len(candidates)))
+		picked := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			host := candidates[(start+i)%len(candidates)]
+			if b.state(host).inflight < b.state(picked).inflight {
+				picked = host
+			}
+		}
+		return picked
+	default:
+		return candidates[atomic.AddUint64(&b.next, 1)%uint64(len(candidates))]
+	}
+}
+
+// state returns the state of the host. It must be called with mu held.
+func (b *balancer) state(host string) *hostState {
+	s, ok := b.hosts[host]
+	if !ok {
+		s = &hostState{}
+		b.hosts[host] = s
+	}
+	return s
+}
+
+// start records the call on the host.
+func (b *balancer) start(host string) *hostState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.state(host)
+	s.inflight++
+	return s
+}
+
+// finish records the end of the call on the host, and ejects the host on connection errors if eject is true.
+func (b *balancer) finish(s *hostState, err error, eject bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.inflight--
+	if !eject {
+		return
+	}
+	if isConnError(err) {
+		s.ejectedUntil = b.timeNow().Add(b.cooldown)
+	} else if err == nil {
+		s.ejectedUntil = time.Time{}
+	}
+}
+
+// isDialError reports whether err fails the connection before the request is sent, so it is safe to fail over.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, driver.ErrBadConn) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+// isConnError reports whether err is a connection error, which makes the host unhealthy.
+func isConnError(err error) bool {
+	var opErr *net.OpError
+	return isDialError(err) || errors.As(err, &opErr) || errors.Is(err, io.EOF)
+}
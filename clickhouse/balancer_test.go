@@ -0,0 +1,341 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/agiledragon/gomonkey/v2"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/naming/bannednodes"
+	"trpc.group/trpc-go/trpc-go/naming/registry"
+	"trpc.group/trpc-go/trpc-go/naming/selector"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+func TestParseBalanceFromDSN(t *testing.T) {
+	opts, remain, ok, err := parseBalanceFromDSN("u:p@h1:9000,h2:9000/db?debug=true")
+	if err != nil || ok || opts != (balanceOptions{}) || remain != "u:p@h1:9000,h2:9000/db?debug=true" {
+		t.Fatalf("parseBalanceFromDSN() = %+v, %s, %v, %v, want not balanced", opts, remain, ok, err)
+	}
+
+	opts, remain, ok, err = parseBalanceFromDSN(
+		"u:p@h1:9000,h2:9000/db?balance=least_inflight&debug=true&eject_cooldown=1m&resolver=polaris&idle_timeout=5s")
+	want := balanceOptions{strategy: BalanceLeastInflight, cooldown: time.Minute, resolver: "polaris",
+		idleTimeout: 5 * time.Second}
+	if err != nil || !ok || opts != want || remain != "u:p@h1:9000,h2:9000/db?debug=true" {
+		t.Fatalf("parseBalanceFromDSN() = %+v, %s, %v, %v, want %+v", opts, remain, ok, err, want)
+	}
+
+	opts, remain, ok, err = parseBalanceFromDSN("u:p@h1:9000/db?balance=in_order")
+	if err != nil || !ok || remain != "u:p@h1:9000/db" ||
+		opts.cooldown != defaultEjectCooldown || opts.idleTimeout != defaultIdleTimeout {
+		t.Fatalf("parseBalanceFromDSN() = %+v, %s, %v, %v, want the defaults", opts, remain, ok, err)
+	}
+
+	for _, dsn := range []string{
+		"u:p@h1:9000/db?balance=random",
+		"u:p@h1:9000/db?balance=in_order&eject_cooldown=1",
+		"u:p@h1:9000/db?balance=in_order&idle_timeout=1",
+	} {
+		if _, _, _, err := parseBalanceFromDSN(dsn); err == nil {
+			t.Errorf("parseBalanceFromDSN(%s) succeeded", dsn)
+		}
+	}
+}
+
+func newTestBalancer(t *testing.T, dsn string) (*balancer, *time.Time) {
+	b, err := newBalancer(dsn)
+	if err != nil {
+		t.Fatalf("newBalancer(%s) err = %v", dsn, err)
+	}
+	now := time.Now()
+	b.timeNow = func() time.Time { return now }
+	return b, &now
+}
+
+// callHosts calls the balancer n times, and returns the hosts called.
+func callHosts(t *testing.T, b *balancer, n int, fn func(dsn string) error) []string {
+	var dsns []string
+	for i := 0; i < n; i++ {
+		dsn, _ := b.call(context.Background(), func(dsn string) error {
+			dsns = append(dsns, dsn)
+			return fn(dsn)
+		})
+		if dsn != dsns[len(dsns)-1] {
+			t.Fatalf("call() = %s, want the last host called %s", dsn, dsns[len(dsns)-1])
+		}
+	}
+	return dsns
+}
+
+// expectHosts calls the balancer n times, and checks the hosts called.
+func expectHosts(t *testing.T, b *balancer, n int, fn func(dsn string) error, want ...string) {
+	t.Helper()
+	if got := callHosts(t, b, n, fn); !reflect.DeepEqual(got, want) {
+		t.Fatalf("called %v, want %v", got, want)
+	}
+}
+
+func TestBalancer_Strategies(t *testing.T) {
+	ok := func(string) error { return nil }
+	b, _ := newTestBalancer(t, "u:p@h1,h2,h3/db?balance=round_robin")
+	expectHosts(t, b, 4, ok, "u:p@h2/db", "u:p@h3/db", "u:p@h1/db", "u:p@h2/db")
+
+	b, _ = newTestBalancer(t, "h1,h2/db?balance=in_order&debug=true")
+	expectHosts(t, b, 2, ok, "h1/db?debug=true", "h1/db?debug=true")
+
+	// The host with the least inflight calls is picked.
+	b, _ = newTestBalancer(t, "u:p@h1,h2,h3/db?balance=least_inflight")
+	b.start("h1")
+	b.start("h2")
+	b.start("h2")
+	expectHosts(t, b, 2, ok, "u:p@h3/db", "u:p@h3/db")
+	b.start("h3")
+	b.start("h3")
+	expectHosts(t, b, 1, ok, "u:p@h1/db")
+}
+
+func TestBalancer_Failover(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	b, now := newTestBalancer(t, "u:p@h1,h2,h3/db?balance=in_order&eject_cooldown=10s")
+
+	// Dial errors fail over to the next host, and eject the host.
+	down := map[string]bool{"u:p@h1/db": true}
+	call := func(dsn string) error {
+		if down[dsn] {
+			return dialErr
+		}
+		return nil
+	}
+	expectHosts(t, b, 2, call, "u:p@h1/db", "u:p@h2/db", "u:p@h2/db")
+
+	// Other connection errors eject the host without failing over.
+	readErr := &net.OpError{Op: "read", Err: io.ErrUnexpectedEOF}
+	if dsn, err := b.call(context.Background(), func(string) error { return readErr }); dsn != "u:p@h2/db" ||
+		!errors.Is(err, readErr) {
+		t.Fatalf("call() = %s, %v, want u:p@h2/db and the read error", dsn, err)
+	}
+	if _, err := b.call(context.Background(), func(string) error { return io.EOF }); !errors.Is(err, io.EOF) {
+		t.Fatalf("call() err = %v, want io.EOF", err)
+	}
+	expectHosts(t, b, 1, func(dsn string) error { return driver.ErrBadConn },
+		"u:p@h1/db", "u:p@h2/db", "u:p@h3/db")
+
+	// Ejected hosts are picked if all hosts are ejected, and recover after a success.
+	expectHosts(t, b, 1, call, "u:p@h1/db", "u:p@h2/db")
+	expectHosts(t, b, 1, call, "u:p@h2/db")
+
+	// Ejected hosts are picked again after the cooldown.
+	*now = now.Add(10 * time.Second)
+	delete(down, "u:p@h1/db")
+	expectHosts(t, b, 1, call, "u:p@h1/db")
+
+	// Errors not of connections are returned directly.
+	queryErr := errors.New("query error")
+	if _, err := b.call(context.Background(), func(string) error { return queryErr }); !errors.Is(err, queryErr) {
+		t.Fatalf("call() err = %v, want the query error", err)
+	}
+	expectHosts(t, b, 1, call, "u:p@h1/db")
+
+	// Failover stops when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls int
+	_, err := b.call(ctx, func(string) error {
+		calls++
+		return dialErr
+	})
+	if !errors.Is(err, dialErr) || calls != 1 {
+		t.Fatalf("call() err = %v after %d calls, want the dial error after 1 call", err, calls)
+	}
+}
+
+// fakeSelector selects the first replica of the service name not banned by the context, like the load balancers
+// of tRPC, and records the reports.
+type fakeSelector struct {
+	nodes   map[string][]*registry.Node
+	reports []string
+}
+
+func (s *fakeSelector) Select(serviceName string, opts ...selector.Option) (*registry.Node, error) {
+	o := &selector.Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	nodes := s.nodes[serviceName]
+	if len(nodes) == 0 {
+		return nil, errors.New("no replicas")
+	}
+	node := nodes[0]
+	if bans, _, ok := bannednodes.FromCtx(o.Ctx); ok {
+		for _, n := range nodes {
+			if bans.Range(func(banned *registry.Node) bool { return banned != n }) {
+				node = n
+				break
+			}
+		}
+	}
+	bannednodes.Add(o.Ctx, node)
+	return node, nil
+}
+
+func (s *fakeSelector) Report(node *registry.Node, _ time.Duration, err error) error {
+	s.reports = append(s.reports, fmt.Sprintf("%s:%v", node.Address, err))
+	return nil
+}
+
+func newSelectedBalancer(t *testing.T, dsn string, s selector.Selector) (*balancer, *time.Time) {
+	b, now := newTestBalancer(t, dsn)
+	b.selectorFn = func(name string) selector.Selector {
+		if name == "fake" {
+			return s
+		}
+		return nil
+	}
+	return b, now
+}
+
+func TestBalancer_Resolver(t *testing.T) {
+	s := &fakeSelector{nodes: map[string][]*registry.Node{
+		"cluster": {{Address: "10.0.0.1:9000"}, {Address: "10.0.0.2:9000"}, {Address: "10.0.0.3:9000"}},
+	}}
+	b, _ := newSelectedBalancer(t, "u:p@cluster/db?balance=round_robin&resolver=fake", s)
+	ok := func(string) error { return nil }
+	expectHosts(t, b, 2, ok, "u:p@10.0.0.1:9000/db", "u:p@10.0.0.1:9000/db")
+
+	// Connection errors are reported to the selector, and fail over to the replicas not tried.
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	s.reports = nil
+	expectHosts(t, b, 1, func(dsn string) error {
+		if dsn == "u:p@10.0.0.1:9000/db" {
+			return dialErr
+		}
+		return nil
+	}, "u:p@10.0.0.1:9000/db", "u:p@10.0.0.2:9000/db")
+	if want := []string{"10.0.0.1:9000:" + dialErr.Error(), "10.0.0.2:9000:<nil>"}; !reflect.DeepEqual(s.reports, want) {
+		t.Fatalf("reports = %v, want %v", s.reports, want)
+	}
+	// Errors of the queries are not failures of the replicas, and the attempts are limited.
+	s.reports = nil
+	if _, err := b.call(context.Background(), func(string) error { return errors.New("query error") }); err == nil {
+		t.Fatalf("call() succeeded with query error")
+	}
+	expectHosts(t, b, 1, func(string) error { return dialErr },
+		"u:p@10.0.0.1:9000/db", "u:p@10.0.0.2:9000/db", "u:p@10.0.0.3:9000/db")
+	if len(s.reports) != 4 || s.reports[0] != "10.0.0.1:9000:<nil>" {
+		t.Fatalf("reports = %v, want the query error reported as a success", s.reports)
+	}
+
+	for _, dsn := range []string{"u:p@other/db?balance=round_robin&resolver=fake",
+		"u:p@cluster/db?balance=round_robin&resolver=unknown"} {
+		b, _ := newSelectedBalancer(t, dsn, s)
+		if _, err := b.call(context.Background(), ok); err == nil {
+			t.Fatalf("call() of %s succeeded", dsn)
+		}
+	}
+}
+
+func TestBalancer_IdleTimeout(t *testing.T) {
+	s := &fakeSelector{nodes: map[string][]*registry.Node{"cluster": {{Address: "10.0.0.1:9000"}}}}
+	b, now := newSelectedBalancer(t, "u:p@cluster/db?balance=in_order&resolver=fake&idle_timeout=10s", s)
+	var evicted []string
+	b.evict = func(dsns []string) { evicted = append(evicted, dsns...) }
+	ok := func(string) error { return nil }
+
+	expectHosts(t, b, 1, ok, "u:p@10.0.0.1:9000/db")
+	// The replica not selected for the idle timeout is evicted.
+	*now = now.Add(10 * time.Second)
+	s.nodes["cluster"] = []*registry.Node{{Address: "10.0.0.2:9000"}}
+	expectHosts(t, b, 1, ok, "u:p@10.0.0.2:9000/db")
+	if !reflect.DeepEqual(evicted, []string{"u:p@10.0.0.1:9000/db"}) {
+		t.Fatalf("evicted %v, want u:p@10.0.0.1:9000/db", evicted)
+	}
+	*now = now.Add(5 * time.Second)
+	expectHosts(t, b, 1, ok, "u:p@10.0.0.2:9000/db")
+	*now = now.Add(5 * time.Second)
+	expectHosts(t, b, 1, ok, "u:p@10.0.0.2:9000/db")
+	if len(evicted) != 1 {
+		t.Fatalf("evicted %v, want the replica selected in the idle timeout kept", evicted)
+	}
+}
+
+func TestClientTransport_RoundTripBalanced(t *testing.T) {
+	down, downMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() err = %v", err)
+	}
+	up, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() err = %v", err)
+	}
+	var opened []string
+	patches := gomonkey.ApplyFunc(sql.Open, func(driverName, dsn string) (*sql.DB, error) {
+		opened = append(opened, dsn)
+		if dsn == "tcp://u:p@127.0.0.1:9001/db" {
+			return down, nil
+		}
+		return up, nil
+	})
+	defer patches.Reset()
+	// The query on the first host fails to dial.
+	downMock.ExpectExec("INSERT").WillReturnError(&net.OpError{Op: "dial", Err: errors.New("connection refused")})
+	mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ct := NewClientTransport().(*ClientTransport)
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithClientReqHead(&Request{op: opExec, Exec: "INSERT INTO events VALUES (1)"})
+	msg.WithClientRspHead(&Response{})
+	address := "u:p@127.0.0.1:9001,127.0.0.1:9002/db?balance=in_order"
+	if _, err = ct.RoundTrip(ctx, nil, transport.WithDialAddress(address)); err != nil {
+		t.Fatalf("RoundTrip() err = %v", err)
+	}
+	if want := []string{"tcp://u:p@127.0.0.1:9001/db", "tcp://u:p@127.0.0.1:9002/db"}; !reflect.DeepEqual(opened, want) {
+		t.Fatalf("opened %v, want %v", opened, want)
+	}
+	if addr := msg.RemoteAddr().String(); addr != "127.0.0.1:9002" {
+		t.Fatalf("remote address = %s, want 127.0.0.1:9002", addr)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ct.RoundTrip(ctx, nil, transport.WithDialAddress("u:p@127.0.0.1:9001/db?balance=random")); err == nil {
+		t.Fatalf("RoundTrip() of unknown strategy succeeded")
+	}
+}
+
+func TestClientTransport_closeDBs(t *testing.T) {
+	gone, goneMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() err = %v", err)
+	}
+	kept, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() err = %v", err)
+	}
+	goneMock.ExpectClose()
+	ct := NewClientTransport().(*ClientTransport)
+	ct.dbs["u:p@10.0.0.1:9000/db"] = gone
+	ct.dbs["u:p@10.0.0.2:9000/db"] = kept
+
+	b, err := ct.getBalancer("u:p@cluster/db?balance=round_robin&resolver=fake")
+	if err != nil {
+		t.Fatalf("getBalancer() err = %v", err)
+	}
+	b.evict([]string{"u:p@10.0.0.1:9000/db", "u:p@10.0.0.3:9000/db"})
+	if want := map[string]*sql.DB{"u:p@10.0.0.2:9000/db": kept}; !reflect.DeepEqual(ct.dbs, want) {
+		t.Fatalf("dbs = %v, want %v", ct.dbs, want)
+	}
+	if err := goneMock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,210 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Default limits of the buffered rows of a batch.
+const (
+	defaultBatchMaxRows  = 100000
+	defaultBatchMaxBytes = 64 << 20
+)
+
+// ErrBatchDone is returned when a batch is used after Send or Abort.
+var ErrBatchDone = errors.New("clickhouse batch has been sent or aborted")
+
+// BatchOption is the option of a batch.
+type BatchOption func(*batchOptions)
+
+// batchOptions is the options of a batch.
+type batchOptions struct {
+	maxRows  int
+	maxBytes int
+}
+
+// WithBatchMaxRows sets the number of buffered rows which triggers a flush, 100000 by default.
+// A non-positive value disables flushing by rows.
+func WithBatchMaxRows(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxRows = n
+	}
+}
+
+// WithBatchMaxBytes sets the estimated size of buffered rows which triggers a flush, 64MB by default.
+// A non-positive value disables flushing by bytes.
+func WithBatchMaxBytes(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxBytes = n
+	}
+}
+
+// batchRow is a buffered row of a batch, either values of columns or a struct.
+type batchRow struct {
+	values    []interface{}
+	structure interface{}
+}
+
+// checkStruct checks that v is a struct or a pointer to struct.
+func checkStruct(v interface{}) error {
+	if t := reflect.TypeOf(v); t == nil ||
+		(t.Kind() != reflect.Struct && (t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct)) {
+		return fmt.Errorf("clickhouse batch needs a struct or a pointer to struct, got %T", v)
+	}
+	return nil
+}
+
+// size estimates the size of the row encoded in columns.
+func (r batchRow) size() int {
+	if r.structure != nil {
+		return sizeOf(reflect.ValueOf(r.structure))
+	}
+	var n int
+	for _, v := range r.values {
+		n += sizeOf(reflect.ValueOf(v))
+	}
+	return n
+}
+
+// batch implements Batch.
+type batch struct {
+	ctx     context.Context
+	cli     *clickhouseCli
+	query   string
+	options batchOptions
+
+	mu    sync.Mutex
+	rows  []batchRow
+	bytes int
+	done  bool
+}
+
+// Append implements Batch.
+func (b *batch) Append(v ...interface{}) error {
+	return b.append(batchRow{values: v})
+}
+
+// AppendStruct implements Batch.
+func (b *batch) AppendStruct(v interface{}) error {
+	if err := checkStruct(v); err != nil {
+		return err
+	}
+	return b.append(batchRow{structure: v})
+}
+
+func (b *batch) append(row batchRow) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return ErrBatchDone
+	}
+	b.rows = append(b.rows, row)
+	if b.options.maxBytes > 0 {
+		b.bytes += row.size()
+	}
+	if (b.options.maxRows > 0 && len(b.rows) >= b.options.maxRows) ||
+		(b.options.maxBytes > 0 && b.bytes >= b.options.maxBytes) {
+		return b.flush()
+	}
+	return nil
+}
+
+// Rows implements Batch.
+func (b *batch) Rows() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.rows)
+}
+
+// Flush implements Batch.
+func (b *batch) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return ErrBatchDone
+	}
+	return b.flush()
+}
+
+func (b *batch) flush() error {
+	if len(b.rows) == 0 {
+		return nil
+	}
+	if err := b.cli.sendBatch(b.ctx, b.query, b.rows); err != nil {
+		return err
+	}
+	b.rows, b.bytes = nil, 0
+	return nil
+}
+
+// Send implements Batch.
+func (b *batch) Send() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return ErrBatchDone
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	b.done = true
+	return nil
+}
+
+// Abort implements Batch.
+func (b *batch) Abort() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return ErrBatchDone
+	}
+	b.rows, b.bytes, b.done = nil, 0, true
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// sizeOf estimates the size of the value encoded in columns.
+func sizeOf(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return 0
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 1
+		}
+		return sizeOf(v.Elem())
+	case reflect.String:
+		return v.Len() + 1
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Len() + 1
+		}
+		n := 8
+		for i := 0; i < v.Len(); i++ {
+			n += sizeOf(v.Index(i))
+		}
+		return n
+	case reflect.Map:
+		n := 8
+		for iter := v.MapRange(); iter.Next(); {
+			n += sizeOf(iter.Key()) + sizeOf(iter.Value())
+		}
+		return n
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return 8
+		}
+		var n int
+		for i := 0; i < v.NumField(); i++ {
+			n += sizeOf(v.Field(i))
+		}
+		return n
+	default:
+		return int(v.Type().Size())
+	}
+}
@@ -0,0 +1,244 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	chdriver "github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/golang/mock/gomock"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/client/mockclient"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+type batchEvent struct {
+	Name string    `ch:"name"`
+	Tags []string  `ch:"tags"`
+	Time time.Time `ch:"time"`
+}
+
+func TestBatch(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+	mockClient := mockclient.NewMockClient(ctl)
+	var (
+		sent    [][]batchRow
+		sendErr error
+	)
+	mockClient.EXPECT().Invoke(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, reqBody, rspBody interface{}, opt ...client.Option) error {
+			msg := codec.Message(ctx)
+			if name := msg.ClientRPCName(); name != "/trpc.clickhouse.server.service/Batch" {
+				t.Fatalf("rpc name = %s, want /trpc.clickhouse.server.service/Batch", name)
+			}
+			req := reqBody.(*Request)
+			if req.op != opBatch || req.Exec != "INSERT INTO events" {
+				t.Fatalf("op = %d, exec = %s, want batch of INSERT INTO events", req.op, req.Exec)
+			}
+			if sendErr != nil {
+				return sendErr
+			}
+			sent = append(sent, req.batchRows)
+			return nil
+		},
+	).AnyTimes()
+	cli := NewClientProxy("trpc.clickhouse.server.service").(*clickhouseCli)
+	cli.Client = mockClient
+
+	if _, err := cli.PrepareBatch(context.Background(), ""); err == nil {
+		t.Fatalf("PrepareBatch() of empty query succeeded")
+	}
+
+	// Flushed by rows.
+	b, err := cli.PrepareBatch(context.Background(), "INSERT INTO events", WithBatchMaxRows(2))
+	if err != nil {
+		t.Fatalf("PrepareBatch() err = %v", err)
+	}
+	if err := b.Append("a", []string{"x"}, time.Now()); err != nil || b.Rows() != 1 {
+		t.Fatalf("Append() err = %v, rows = %d, want 1 row", err, b.Rows())
+	}
+	if err := b.AppendStruct(&batchEvent{Name: "b"}); err != nil || b.Rows() != 0 {
+		t.Fatalf("AppendStruct() err = %v, rows = %d, want flushed", err, b.Rows())
+	}
+	if len(sent) != 1 || len(sent[0]) != 2 {
+		t.Fatalf("sent = %v, want 1 batch of 2 rows", sent)
+	}
+	if sent[0][0].values[0] != "a" || !reflect.DeepEqual(sent[0][1].structure, &batchEvent{Name: "b"}) {
+		t.Fatalf("sent rows = %+v", sent[0])
+	}
+	if b.AppendStruct("c") == nil || b.AppendStruct(nil) == nil {
+		t.Fatalf("AppendStruct() of non-struct succeeded")
+	}
+
+	// Failed rows are kept.
+	if err := b.AppendStruct(batchEvent{Name: "c"}); err != nil {
+		t.Fatalf("AppendStruct() err = %v", err)
+	}
+	sendErr = errors.New("send error")
+	if b.Flush() == nil || b.Send() == nil || b.Rows() != 1 {
+		t.Fatalf("Flush() and Send() succeeded with send error, rows = %d", b.Rows())
+	}
+	sendErr = nil
+	if err := b.Send(); err != nil || len(sent) != 2 {
+		t.Fatalf("Send() err = %v, sent %d batches, want 2", err, len(sent))
+	}
+	for _, err := range []error{b.Append("d"), b.Flush(), b.Send(), b.Abort()} {
+		if !errors.Is(err, ErrBatchDone) {
+			t.Fatalf("err = %v after Send(), want ErrBatchDone", err)
+		}
+	}
+
+	// Flushed by bytes.
+	b, err = cli.PrepareBatch(context.Background(), "INSERT INTO events",
+		WithBatchMaxRows(0), WithBatchMaxBytes(100))
+	if err != nil {
+		t.Fatalf("PrepareBatch() err = %v", err)
+	}
+	if err := b.AppendStruct(batchEvent{Name: "e", Tags: []string{"x", "y"}}); err != nil || b.Rows() != 1 {
+		t.Fatalf("AppendStruct() err = %v, rows = %d, want 1 row", err, b.Rows())
+	}
+	if err := b.Append(string(make([]byte, 100))); err != nil || len(sent) != 3 {
+		t.Fatalf("Append() err = %v, sent %d batches, want 3", err, len(sent))
+	}
+	if err := b.Flush(); err != nil || len(sent) != 3 {
+		t.Fatalf("Flush() err = %v, sent %d batches, want 3", err, len(sent))
+	}
+
+	// Aborted rows are dropped.
+	if err := b.Append("f"); err != nil {
+		t.Fatalf("Append() err = %v", err)
+	}
+	if err := b.Abort(); err != nil || b.Rows() != 0 || len(sent) != 3 {
+		t.Fatalf("Abort() err = %v, rows = %d, sent %d batches, want 0 rows and 3 batches", err, b.Rows(), len(sent))
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+	var nilPtr *int
+	for _, tt := range []struct {
+		v    interface{}
+		want int
+	}{
+		{nil, 0},
+		{nilPtr, 1},
+		{int32(1), 4},
+		{"abc", 4},
+		{[]byte("abc"), 4},
+		{[]int64{1, 2}, 24},
+		{map[string]uint8{"a": 1}, 11},
+		{time.Now(), 8},
+		{&batchEvent{Name: "a", Tags: []string{"b"}}, 2 + 10 + 8},
+	} {
+		if got := sizeOf(reflect.ValueOf(tt.v)); got != tt.want {
+			t.Errorf("sizeOf(%#v) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
+
+// fakeConn is a native connection whose batches record the rows.
+type fakeConn struct {
+	chdriver.Conn
+	batch     *fakeBatch
+	err       error
+	appendErr error
+}
+
+func (c *fakeConn) PrepareBatch(ctx context.Context, query string) (chdriver.Batch, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.batch = &fakeBatch{query: query, appendErr: c.appendErr}
+	return c.batch, nil
+}
+
+type fakeBatch struct {
+	chdriver.Batch
+	query           string
+	rows            []interface{}
+	appendErr       error
+	aborted, isSent bool
+}
+
+func (b *fakeBatch) Append(v ...interface{}) error {
+	b.rows = append(b.rows, v)
+	return b.appendErr
+}
+
+func (b *fakeBatch) AppendStruct(v interface{}) error {
+	b.rows = append(b.rows, v)
+	return b.appendErr
+}
+
+func (b *fakeBatch) Abort() error {
+	b.aborted = true
+	return nil
+}
+
+func (b *fakeBatch) Send() error {
+	b.isSent = true
+	return nil
+}
+
+func TestClientTransport_RoundTripBatch(t *testing.T) {
+	conn := &fakeConn{}
+	var opened []*clickhouse.Options
+	patches := gomonkey.ApplyFunc(clickhouse.Open, func(opt *clickhouse.Options) (chdriver.Conn, error) {
+		opened = append(opened, opt)
+		return conn, nil
+	})
+	defer patches.Reset()
+
+	ct := NewClientTransport().(*ClientTransport)
+	roundTrip := func(req *Request) (*Response, error) {
+		ctx, msg := codec.WithNewMessage(context.Background())
+		msg.WithClientReqHead(req)
+		rsp := &Response{}
+		msg.WithClientRspHead(rsp)
+		_, err := ct.RoundTrip(ctx, nil,
+			transport.WithDialAddress("127.0.0.1:9000?username=default&database=db&max_open=5"))
+		return rsp, err
+	}
+
+	rsp, err := roundTrip(&Request{
+		op:        opBatch,
+		Exec:      "INSERT INTO events",
+		batchRows: []batchRow{{values: []interface{}{"a"}}, {structure: &batchEvent{Name: "b"}}},
+	})
+	if err != nil {
+		t.Fatalf("RoundTrip() err = %v", err)
+	}
+	if n, err := rsp.Result.RowsAffected(); err != nil || n != 2 {
+		t.Fatalf("RowsAffected() = %d, %v, want 2", n, err)
+	}
+	if conn.batch.query != "INSERT INTO events" || !conn.batch.isSent {
+		t.Fatalf("batch query = %s, sent = %v, want INSERT INTO events sent", conn.batch.query, conn.batch.isSent)
+	}
+	if want := []interface{}{[]interface{}{"a"}, &batchEvent{Name: "b"}}; !reflect.DeepEqual(conn.batch.rows, want) {
+		t.Fatalf("batch rows = %v, want %v", conn.batch.rows, want)
+	}
+	if len(opened) != 1 || !reflect.DeepEqual(opened[0].Addr, []string{"127.0.0.1:9000"}) ||
+		opened[0].Auth.Database != "db" || opened[0].MaxOpenConns != 5 {
+		t.Fatalf("opened = %+v, want one connection of 127.0.0.1:9000/db with max open 5", opened)
+	}
+
+	// The connection is reused, and failed batches are aborted.
+	conn.err = errors.New("prepare error")
+	if _, err = roundTrip(&Request{op: opBatch, Exec: "INSERT INTO events"}); err == nil {
+		t.Fatalf("RoundTrip() succeeded with prepare error")
+	}
+	conn.err, conn.appendErr = nil, errors.New("append error")
+	_, err = roundTrip(&Request{op: opBatch, Exec: "INSERT INTO events", batchRows: []batchRow{{values: nil}}})
+	if err == nil {
+		t.Fatalf("RoundTrip() succeeded with append error")
+	}
+	if !conn.batch.aborted || conn.batch.isSent || len(opened) != 1 {
+		t.Fatalf("batch aborted = %v, sent = %v, opened %d, want aborted on the reused connection",
+			conn.batch.aborted, conn.batch.isSent, len(opened))
+	}
+}
@@ -18,6 +18,7 @@ const (
 	opQueryRow
 	opQueryToStructs
 	opTransaction
+	opBatch
 )
 
 // Client is client structure.
@@ -27,6 +28,27 @@ type Client interface {
 	QueryRow(ctx context.Context, dest []interface{}, query string, args ...interface{}) error
 	QueryToStructs(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 	Transaction(ctx context.Context, fn TxFunc) error
+	PrepareBatch(ctx context.Context, query string, opts ...BatchOption) (Batch, error)
+}
+
+// Batch is a batch of rows inserted by the INSERT query it is prepared with.
+// The rows are buffered in memory, and sent in native columnar blocks by Flush and Send,
+// each of which is a tRPC call. The batch is flushed automatically when the buffered rows
+// reach the row or byte limit of the BatchOption. The appended values are referenced until
+// they are sent, so they must not be modified before that.
+type Batch interface {
+	// Append buffers a row by the values of its columns.
+	Append(v ...interface{}) error
+	// AppendStruct buffers a row by a struct, whose fields are mapped to the columns by the ch tags.
+	AppendStruct(v interface{}) error
+	// Rows returns the number of buffered rows.
+	Rows() int
+	// Flush sends the buffered rows. The rows are kept if it fails, and can be flushed again.
+	Flush() error
+	// Send sends the buffered rows and ends the batch.
+	Send() error
+	// Abort drops the buffered rows and ends the batch.
+	Abort() error
 }
 
 // Client is backend request structure.
@@ -62,6 +84,7 @@ type Request struct {
 
 	queryToStructsDest interface{}
 	queryRowDest       []interface{}
+	batchRows          []batchRow
 }
 
 // Copy returns a new Request.
@@ -86,6 +109,8 @@ func (r *Request) Copy() (interface{}, error) {
 		op:    r.op,
 		next:  r.next,
 		tx:    r.tx,
+
+		batchRows: r.batchRows,
 	}
 
 	queryToStructDest, err := copyutils.DeepCopy(r.queryToStructsDest)
@@ -128,6 +153,7 @@ func (r *Request) CopyTo(dst interface{}) error {
 	dr.op = r.op
 	dr.next = r.next
 	dr.tx = r.tx
+	dr.batchRows = r.batchRows
 
 	// If queryToStructsDest or queryRowDest exists, they must be provided by the application layer.
 	// We must ensure that the memory addresses pointed to by these fields remain unchanged,
@@ -311,3 +337,45 @@ func (c *clickhouseCli) Transaction(ctx context.Context, fn TxFunc) error {
 
 	return nil
 }
+
+// PrepareBatch prepares a batch of the clickhouse INSERT query,
+// such as "INSERT INTO example", whose rows are inserted by native columnar blocks.
+func (c *clickhouseCli) PrepareBatch(ctx context.Context, query string, opts ...BatchOption) (Batch, error) {
+	if query == "" {
+		return nil, errors.New("clickhouse batch query is empty")
+	}
+	b := &batch{
+		ctx:   ctx,
+		cli:   c,
+		query: query,
+		options: batchOptions{
+			maxRows:  defaultBatchMaxRows,
+			maxBytes: defaultBatchMaxBytes,
+		},
+	}
+	for _, opt := range opts {
+		opt(&b.options)
+	}
+	return b, nil
+}
+
+// sendBatch sends the rows of the batch query.
+func (c *clickhouseCli) sendBatch(ctx context.Context, query string, rows []batchRow) error {
+	creq := &Request{
+		op:        opBatch,
+		Exec:      query,
+		batchRows: rows,
+	}
+	crsp := &Response{}
+
+	ctx, msg := codec.WithCloneMessage(ctx)
+	defer codec.PutBackMessage(msg)
+	msg.WithClientRPCName(fmt.Sprintf("/%s/Batch", c.ServiceName))
+	msg.WithCalleeServiceName(c.ServiceName)
+	msg.WithSerializationType(-1) // Not serialized.
+	msg.WithCompressType(0)       // Not compressed.
+	msg.WithClientReqHead(creq)
+	msg.WithClientRspHead(crsp)
+
+	return c.Client.Invoke(ctx, creq, crsp, c.opts...)
+}
@@ -56,6 +56,26 @@ func (mr *MockClientMockRecorder) Exec(ctx, query interface{}, args ...interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockClient)(nil).Exec), varargs...)
 }
 
+// PrepareBatch mocks base method.
+func (m *MockClient) PrepareBatch(ctx context.Context, query string, opts ...clickhouse.BatchOption) (clickhouse.Batch, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, query}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PrepareBatch", varargs...)
+	ret0, _ := ret[0].(clickhouse.Batch)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PrepareBatch indicates an expected call of PrepareBatch.
+func (mr *MockClientMockRecorder) PrepareBatch(ctx, query interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, query}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrepareBatch", reflect.TypeOf((*MockClient)(nil).PrepareBatch), varargs...)
+}
+
 // Query mocks base method.
 func (m *MockClient) Query(ctx context.Context, next clickhouse.NextFunc, query string, args ...interface{}) error {
 	m.ctrl.T.Helper()
@@ -126,3 +146,114 @@ func (mr *MockClientMockRecorder) Transaction(ctx, fn interface{}) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transaction", reflect.TypeOf((*MockClient)(nil).Transaction), ctx, fn)
 }
+
+// MockBatch is a mock of Batch interface.
+type MockBatch struct {
+	ctrl     *gomock.Controller
+	recorder *MockBatchMockRecorder
+}
+
+// MockBatchMockRecorder is the mock recorder for MockBatch.
+type MockBatchMockRecorder struct {
+	mock *MockBatch
+}
+
+// NewMockBatch creates a new mock instance.
+func NewMockBatch(ctrl *gomock.Controller) *MockBatch {
+	mock := &MockBatch{ctrl: ctrl}
+	mock.recorder = &MockBatchMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBatch) EXPECT() *MockBatchMockRecorder {
+	return m.recorder
+}
+
+// Abort mocks base method.
+func (m *MockBatch) Abort() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Abort")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Abort indicates an expected call of Abort.
+func (mr *MockBatchMockRecorder) Abort() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Abort", reflect.TypeOf((*MockBatch)(nil).Abort))
+}
+
+// Append mocks base method.
+func (m *MockBatch) Append(v ...interface{}) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range v {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Append", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Append indicates an expected call of Append.
+func (mr *MockBatchMockRecorder) Append(v ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Append", reflect.TypeOf((*MockBatch)(nil).Append), v...)
+}
+
+// AppendStruct mocks base method.
+func (m *MockBatch) AppendStruct(v interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AppendStruct", v)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AppendStruct indicates an expected call of AppendStruct.
+func (mr *MockBatchMockRecorder) AppendStruct(v interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendStruct", reflect.TypeOf((*MockBatch)(nil).AppendStruct), v)
+}
+
+// Flush mocks base method.
+func (m *MockBatch) Flush() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Flush")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Flush indicates an expected call of Flush.
+func (mr *MockBatchMockRecorder) Flush() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Flush", reflect.TypeOf((*MockBatch)(nil).Flush))
+}
+
+// Rows mocks base method.
+func (m *MockBatch) Rows() int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rows")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// Rows indicates an expected call of Rows.
+func (mr *MockBatchMockRecorder) Rows() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rows", reflect.TypeOf((*MockBatch)(nil).Rows))
+}
+
+// Send mocks base method.
+func (m *MockBatch) Send() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send.
+func (mr *MockBatchMockRecorder) Send() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockBatch)(nil).Send))
+}
@@ -0,0 +1,172 @@
+package clickhouse
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/google/uuid"
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+// QueryStatsMetaKey is the key of the QueryStats of a call in the CommonMeta of the tRPC message,
+// which is set if the call has query options.
+const QueryStatsMetaKey = "clickhouse_query_stats"
+
+// QueryStats is the statistics of a call, collected from the progress and profile info of the query.
+type QueryStats struct {
+	QueryID         string
+	ReadRows        uint64        // Rows read by the query
+	ReadBytes       uint64        // Bytes read by the query
+	TotalRowsToRead uint64        // Estimated total rows to read
+	WrittenRows     uint64        // Rows written by the query
+	WrittenBytes    uint64        // Bytes written by the query
+	ResultRows      uint64        // Rows of the result
+	ResultBytes     uint64        // Bytes of the result
+	ResultBlocks    uint64        // Blocks of the result
+	Elapsed         time.Duration // Elapsed time of the call measured by the client
+}
+
+// QueryOption is the option of the calls with the context returned by WithQueryOptions.
+type QueryOption func(*queryOptions)
+
+// queryOptions is the options of a call.
+type queryOptions struct {
+	settings map[string]interface{}
+	queryID  string
+	stats    *QueryStats
+	progress func(QueryStats)
+}
+
+type queryOptionsKey struct{}
+
+// WithQueryOptions returns a context with the query options, which apply to the calls of Client with it,
+// except the statements run by the *sql.Tx of Transaction. The options are added to those of ctx.
+//
+//	var stats clickhouse.QueryStats
+//	ctx := clickhouse.WithQueryOptions(ctx,
+//		clickhouse.WithSettings(map[string]interface{}{"max_execution_time": 60}),
+//		clickhouse.WithQueryID(queryID),
+//		clickhouse.WithQueryStats(&stats),
+//	)
+//	err := proxy.QueryToStructs(ctx, &results, query)
+func WithQueryOptions(ctx context.Context, opts ...QueryOption) context.Context {
+	o := &queryOptions{settings: make(map[string]interface{})}
+	if parent, ok := ctx.Value(queryOptionsKey{}).(*queryOptions); ok {
+		*o = *parent
+		o.settings = make(map[string]interface{}, len(parent.settings))
+		for k, v := range parent.settings {
+			o.settings[k] = v
+		}
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return context.WithValue(ctx, queryOptionsKey{}, o)
+}
+
+// WithSettings adds the clickhouse settings of the queries, such as max_execution_time,
+// max_memory_usage and readonly.
+func WithSettings(settings map[string]interface{}) QueryOption {
+	return func(o *queryOptions) {
+		for k, v := range settings {
+			o.settings[k] = v
+		}
+	}
+}
+
+// WithQueryID sets the query_id of the queries, which can be used to kill the queries.
+// A random query_id is used if it is not set and the stats are collected.
+func WithQueryID(queryID string) QueryOption {
+	return func(o *queryOptions) {
+		o.queryID = queryID
+	}
+}
+
+// WithQueryStats sets the stats which are filled after the call.
+func WithQueryStats(stats *QueryStats) QueryOption {
+	return func(o *queryOptions) {
+		o.stats = stats
+	}
+}
+
+// WithProgress sets the function called with the stats so far on each progress of the queries.
+func WithProgress(fn func(QueryStats)) QueryOption {
+	return func(o *queryOptions) {
+		o.progress = fn
+	}
+}
+
+// queryRecorder records the stats of a call.
+type queryRecorder struct {
+	opts  *queryOptions
+	start time.Time
+	mu    sync.Mutex
+	stats QueryStats
+}
+
+// withQueryRecorder applies the query options of ctx to the clickhouse context,
+// and returns a recorder of the stats. The recorder is nil if ctx has no query options.
+func withQueryRecorder(ctx context.Context) (context.Context, *queryRecorder) {
+	opts, ok := ctx.Value(queryOptionsKey{}).(*queryOptions)
+	if !ok {
+		return ctx, nil
+	}
+	r := &queryRecorder{opts: opts, start: time.Now()}
+	r.stats.QueryID = opts.queryID
+	if r.stats.QueryID == "" && (opts.stats != nil || opts.progress != nil) {
+		r.stats.QueryID = uuid.NewString()
+	}
+	chOpts := []clickhouse.QueryOption{
+		clickhouse.WithProgress(r.onProgress),
+		clickhouse.WithProfileInfo(r.onProfileInfo),
+	}
+	if len(opts.settings) > 0 {
+		chOpts = append(chOpts, clickhouse.WithSettings(opts.settings))
+	}
+	if r.stats.QueryID != "" {
+		chOpts = append(chOpts, clickhouse.WithQueryID(r.stats.QueryID))
+	}
+	return clickhouse.Context(ctx, chOpts...), r
+}
+
+func (r *queryRecorder) onProgress(p *clickhouse.Progress) {
+	r.mu.Lock()
+	r.stats.ReadRows += p.Rows
+	r.stats.ReadBytes += p.Bytes
+	r.stats.TotalRowsToRead += p.TotalRows
+	r.stats.WrittenRows += p.WroteRows
+	r.stats.WrittenBytes += p.WroteBytes
+	r.stats.Elapsed = time.Since(r.start)
+	stats := r.stats
+	r.mu.Unlock()
+	if r.opts.progress != nil {
+		r.opts.progress(stats)
+	}
+}
+
+func (r *queryRecorder) onProfileInfo(p *clickhouse.ProfileInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.ResultRows += p.Rows
+	r.stats.ResultBytes += p.Bytes
+	r.stats.ResultBlocks += p.Blocks
+}
+
+// finish fills the stats of the call, and records them in the CommonMeta of msg.
+func (r *queryRecorder) finish(msg codec.Msg) {
+	r.mu.Lock()
+	r.stats.Elapsed = time.Since(r.start)
+	stats := r.stats
+	r.mu.Unlock()
+	if r.opts.stats != nil {
+		*r.opts.stats = stats
+	}
+	meta := msg.CommonMeta()
+	if meta == nil {
+		meta = codec.CommonMeta{}
+	}
+	meta[QueryStatsMetaKey] = stats
+	msg.WithCommonMeta(meta)
+}
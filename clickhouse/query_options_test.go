@@ -0,0 +1,118 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/agiledragon/gomonkey/v2"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+func TestWithQueryOptions(t *testing.T) {
+	var stats QueryStats
+	ctx := WithQueryOptions(context.Background(),
+		WithSettings(map[string]interface{}{"max_execution_time": 60, "readonly": 1}),
+		WithQueryID("q1"),
+	)
+	child := WithQueryOptions(ctx, WithSettings(map[string]interface{}{"readonly": 2}), WithQueryStats(&stats))
+
+	opts := ctx.Value(queryOptionsKey{}).(*queryOptions)
+	want := map[string]interface{}{"max_execution_time": 60, "readonly": 1}
+	if !reflect.DeepEqual(opts.settings, want) || opts.stats != nil {
+		t.Fatalf("settings = %v, stats = %v, want %v and nil stats", opts.settings, opts.stats, want)
+	}
+	opts = child.Value(queryOptionsKey{}).(*queryOptions)
+	want = map[string]interface{}{"max_execution_time": 60, "readonly": 2}
+	if !reflect.DeepEqual(opts.settings, want) || opts.queryID != "q1" || opts.stats != &stats {
+		t.Fatalf("settings = %v, query_id = %s, stats = %p, want %v, q1 and %p",
+			opts.settings, opts.queryID, opts.stats, want, &stats)
+	}
+
+	// The query_id is generated if the stats are collected.
+	_, r := withQueryRecorder(WithQueryOptions(context.Background(), WithQueryStats(&stats)))
+	if r.stats.QueryID == "" {
+		t.Fatalf("query_id is not generated")
+	}
+	_, r = withQueryRecorder(WithQueryOptions(context.Background(), WithSettings(nil)))
+	if r.stats.QueryID != "" {
+		t.Fatalf("query_id = %s, want empty", r.stats.QueryID)
+	}
+	if _, r = withQueryRecorder(context.Background()); r != nil {
+		t.Fatalf("recorder = %v, want nil", r)
+	}
+}
+
+func TestQueryRecorder(t *testing.T) {
+	var (
+		stats    QueryStats
+		progress []QueryStats
+	)
+	ctx := WithQueryOptions(context.Background(), WithQueryID("q1"), WithQueryStats(&stats),
+		WithProgress(func(s QueryStats) {
+			progress = append(progress, s)
+		}))
+	_, r := withQueryRecorder(ctx)
+	r.onProgress(&clickhouse.Progress{Rows: 10, Bytes: 100, TotalRows: 30})
+	r.onProgress(&clickhouse.Progress{Rows: 20, Bytes: 200, WroteRows: 1, WroteBytes: 8})
+	r.onProfileInfo(&clickhouse.ProfileInfo{Rows: 5, Bytes: 50, Blocks: 1})
+	if len(progress) != 2 || progress[0].ReadRows != 10 || progress[1].ReadRows != 30 {
+		t.Fatalf("progress = %+v, want read rows 10 and 30", progress)
+	}
+
+	msg := codec.Message(context.Background())
+	msg.WithCommonMeta(codec.CommonMeta{"key": "value"})
+	r.finish(msg)
+	if stats.Elapsed <= 0 {
+		t.Fatalf("elapsed = %v, want positive", stats.Elapsed)
+	}
+	stats.Elapsed = 0
+	want := QueryStats{
+		QueryID:         "q1",
+		ReadRows:        30,
+		ReadBytes:       300,
+		TotalRowsToRead: 30,
+		WrittenRows:     1,
+		WrittenBytes:    8,
+		ResultRows:      5,
+		ResultBytes:     50,
+		ResultBlocks:    1,
+	}
+	if stats != want {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+	if msg.CommonMeta()["key"] != "value" || msg.CommonMeta()[QueryStatsMetaKey].(QueryStats).QueryID != "q1" {
+		t.Fatalf("common meta = %v", msg.CommonMeta())
+	}
+}
+
+func TestClientTransport_RoundTripQueryOptions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() err = %v", err)
+	}
+	patches := gomonkey.ApplyFunc(sql.Open, func(driverName, dsn string) (*sql.DB, error) {
+		return db, nil
+	})
+	defer patches.Reset()
+	mock.ExpectExec("ALTER").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	var stats QueryStats
+	ctx, msg := codec.WithNewMessage(WithQueryOptions(context.Background(),
+		WithSettings(map[string]interface{}{"mutations_sync": 1}), WithQueryID("q1"), WithQueryStats(&stats)))
+	msg.WithClientReqHead(&Request{op: opExec, Exec: "ALTER TABLE events DELETE WHERE 1"})
+	msg.WithClientRspHead(&Response{})
+	if _, err = NewClientTransport().RoundTrip(ctx, nil, transport.WithDialAddress("127.0.0.1:9000/db")); err != nil {
+		t.Fatalf("RoundTrip() err = %v", err)
+	}
+	if stats.QueryID != "q1" || stats.Elapsed <= 0 {
+		t.Fatalf("stats = %+v, want query_id q1 and positive elapsed", stats)
+	}
+	if msg.CommonMeta()[QueryStatsMetaKey] != stats || msg.CommonMeta()["overrideCalleeApp"] != calleeApp {
+		t.Fatalf("common meta = %v", msg.CommonMeta())
+	}
+}
@@ -0,0 +1,372 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Select executes the clickhouse select command, and scans the rows into a slice of T.
+// If T is a struct or a pointer to struct, the columns are mapped to its fields by the ch tags,
+// the db tags or the field names, and every column must have a field. Otherwise, the query must
+// return one column, which is scanned into T.
+//
+// The values of the columns are converted to the types of the fields:
+//
+//	Nullable(T): *T, or T whose NULL is an error.
+//	Array(T), Map(K, V): slices and maps of types converted from T, K and V.
+//	Tuple(T1, T2...): []interface{}, or structs whose fields are assigned in order, or by names for named tuples.
+//	LowCardinality(T): types of T.
+//	Decimal, UUID: decimal.Decimal, uuid.UUID, string or any sql.Scanner.
+//	DateTime64: time.Time in the timezone of the column.
+//	Numbers: any number types which hold the values.
+//
+//	type Event struct {
+//		ID   uuid.UUID         `ch:"id"`
+//		Tags []string          `ch:"tags"`
+//		Attr map[string]string `ch:"attr"`
+//		Cost *decimal.Decimal  `ch:"cost"`
+//		Time time.Time         `ch:"time"`
+//	}
+//
+//	events, err := clickhouse.Select[Event](ctx, proxy, "SELECT id, tags, attr, cost, time FROM events")
+func Select[T any](ctx context.Context, c Client, query string, args ...interface{}) ([]T, error) {
+	var (
+		results []T
+		s       *rowScanner
+	)
+	next := func(rows *sql.Rows) error {
+		if s == nil {
+			var err error
+			if s, err = newRowScanner(reflect.TypeOf((*T)(nil)).Elem(), rows); err != nil {
+				return err
+			}
+		}
+		var zero T
+		results = append(results, zero)
+		return s.scan(rows, reflect.ValueOf(&results[len(results)-1]).Elem())
+	}
+	if err := c.Query(ctx, next, query, args...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+var (
+	scannerType  = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// rowScanner scans the rows into values of a type.
+type rowScanner struct {
+	ptr      bool // whether the type is a pointer to struct
+	columns  []*columnScanner
+	dest     []interface{}
+	indexes  [][]int // indexes of the fields of the columns, nil for the value itself
+	elemType reflect.Type
+}
+
+// newRowScanner creates a rowScanner of t by the columns of rows.
+func newRowScanner(t reflect.Type, rows *sql.Rows) (*rowScanner, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	s := &rowScanner{elemType: t}
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+		s.ptr, s.elemType = true, t.Elem()
+	}
+	if !isStructType(s.elemType) {
+		if len(types) != 1 {
+			return nil, fmt.Errorf("clickhouse select: %d columns can not be scanned into %s", len(types), t)
+		}
+		s.ptr, s.elemType = false, t
+		s.indexes = [][]int{nil}
+	} else {
+		fields := structFields(s.elemType)
+		for _, ct := range types {
+			index, ok := fields.lookup(ct.Name())
+			if !ok {
+				return nil, fmt.Errorf("clickhouse select: column %s has no field in %s", ct.Name(), s.elemType)
+			}
+			s.indexes = append(s.indexes, index)
+		}
+	}
+	for _, ct := range types {
+		c := &columnScanner{name: ct.Name(), dbType: ct.DatabaseTypeName()}
+		s.columns = append(s.columns, c)
+		s.dest = append(s.dest, c)
+	}
+	return s, nil
+}
+
+// scan scans the current row of rows into v.
+func (s *rowScanner) scan(rows *sql.Rows, v reflect.Value) error {
+	if s.ptr {
+		v.Set(reflect.New(s.elemType))
+		v = v.Elem()
+	}
+	for i, c := range s.columns {
+		if s.indexes[i] == nil {
+			c.field = v
+		} else {
+			c.field = v.FieldByIndex(s.indexes[i])
+		}
+	}
+	return rows.Scan(s.dest...)
+}
+
+// columnScanner is a sql.Scanner which assigns the value of a column to a field.
+type columnScanner struct {
+	name   string
+	dbType string
+	field  reflect.Value
+}
+
+// Scan implements sql.Scanner.
+func (c *columnScanner) Scan(src interface{}) error {
+	if err := assign(c.field, src); err != nil {
+		column := c.name
+		if c.dbType != "" {
+			column += " of type " + c.dbType
+		}
+		return fmt.Errorf("clickhouse select: column %s into %s: %w", column, c.field.Type(), err)
+	}
+	return nil
+}
+
+// assign assigns src to dst, converting the types if necessary.
+func assign(dst reflect.Value, src interface{}) error {
+	if dst.CanAddr() && dst.Addr().Type().Implements(scannerType) {
+		return dst.Addr().Interface().(sql.Scanner).Scan(src)
+	}
+	sv := reflect.ValueOf(src)
+	for sv.Kind() == reflect.Ptr && !sv.IsNil() {
+		sv = sv.Elem()
+	}
+	if !sv.IsValid() || (sv.Kind() == reflect.Ptr && sv.IsNil()) {
+		switch dst.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		return fmt.Errorf("NULL can not be assigned to %s, use a pointer instead", dst.Type())
+	}
+	return assignValue(dst, sv)
+}
+
+// assignValue assigns the non-NULL sv to dst.
+func assignValue(dst reflect.Value, sv reflect.Value) error {
+	dt := dst.Type()
+	if sv.Type().AssignableTo(dt) {
+		dst.Set(sv)
+		return nil
+	}
+	switch dt.Kind() {
+	case reflect.Ptr:
+		v := reflect.New(dt.Elem())
+		if err := assign(v.Elem(), sv.Interface()); err != nil {
+			return err
+		}
+		dst.Set(v)
+		return nil
+	case reflect.Interface:
+		if sv.Type().Implements(dt) {
+			dst.Set(sv)
+			return nil
+		}
+	case reflect.String:
+		switch {
+		case sv.Kind() == reflect.String:
+			dst.SetString(sv.String())
+			return nil
+		case sv.Kind() == reflect.Slice && sv.Type().Elem().Kind() == reflect.Uint8:
+			dst.SetString(string(sv.Bytes()))
+			return nil
+		case sv.Type().Implements(stringerType):
+			dst.SetString(sv.Interface().(fmt.Stringer).String())
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if isNumber(sv.Kind()) {
+			return assignNumber(dst, sv)
+		}
+	case reflect.Slice:
+		if sv.Kind() == reflect.Slice || sv.Kind() == reflect.Array {
+			v := reflect.MakeSlice(dt, sv.Len(), sv.Len())
+			for i := 0; i < sv.Len(); i++ {
+				if err := assign(v.Index(i), sv.Index(i).Interface()); err != nil {
+					return fmt.Errorf("element %d: %w", i, err)
+				}
+			}
+			dst.Set(v)
+			return nil
+		}
+	case reflect.Map:
+		if sv.Kind() == reflect.Map {
+			v := reflect.MakeMapWithSize(dt, sv.Len())
+			for iter := sv.MapRange(); iter.Next(); {
+				key, value := reflect.New(dt.Key()).Elem(), reflect.New(dt.Elem()).Elem()
+				if err := assign(key, iter.Key().Interface()); err != nil {
+					return fmt.Errorf("key %v: %w", iter.Key(), err)
+				}
+				if err := assign(value, iter.Value().Interface()); err != nil {
+					return fmt.Errorf("value of %v: %w", iter.Key(), err)
+				}
+				v.SetMapIndex(key, value)
+			}
+			dst.Set(v)
+			return nil
+		}
+	case reflect.Struct:
+		if isStructType(dt) {
+			return assignTuple(dst, sv)
+		}
+	}
+	return fmt.Errorf("%s can not be assigned to %s", sv.Type(), dt)
+}
+
+// assignTuple assigns the elements of a tuple to the fields of the struct dst,
+// in order for unnamed tuples, or by names for named tuples.
+func assignTuple(dst reflect.Value, sv reflect.Value) error {
+	fields := structFields(dst.Type())
+	switch sv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if sv.Len() != len(fields.ordered) {
+			return fmt.Errorf("tuple of %d elements can not be assigned to %s of %d fields",
+				sv.Len(), dst.Type(), len(fields.ordered))
+		}
+		for i, index := range fields.ordered {
+			if err := assign(dst.FieldByIndex(index), sv.Index(i).Interface()); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		if sv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		for iter := sv.MapRange(); iter.Next(); {
+			name := iter.Key().String()
+			index, ok := fields.lookup(name)
+			if !ok {
+				return fmt.Errorf("element %s has no field in %s", name, dst.Type())
+			}
+			if err := assign(dst.FieldByIndex(index), iter.Value().Interface()); err != nil {
+				return fmt.Errorf("element %s: %w", name, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s can not be assigned to %s", sv.Type(), dst.Type())
+}
+
+// assignNumber assigns the number sv to the number dst, and fails if dst can not hold it.
+func assignNumber(dst reflect.Value, sv reflect.Value) error {
+	// Floats are rounded to the nearest value like the conversion of Go, such as 0.1 of Float64 to float32,
+	// since most of them can not make the round trip. Only those out of the range are rejected.
+	if isFloat(sv.Kind()) && isFloat(dst.Kind()) {
+		if dst.OverflowFloat(sv.Float()) {
+			return fmt.Errorf("%v of %s can not be held by %s", sv, sv.Type(), dst.Type())
+		}
+		dst.SetFloat(sv.Float())
+		return nil
+	}
+	v := sv.Convert(dst.Type())
+	back := v.Convert(sv.Type())
+	if back.Interface() != sv.Interface() ||
+		(isSigned(sv.Kind()) && isUnsigned(dst.Kind()) && sv.Int() < 0) ||
+		(isUnsigned(sv.Kind()) && isSigned(dst.Kind()) && v.Int() < 0) {
+		return fmt.Errorf("%v of %s can not be held by %s", sv, sv.Type(), dst.Type())
+	}
+	dst.Set(v)
+	return nil
+}
+
+func isSigned(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUnsigned(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNumber(k reflect.Kind) bool {
+	return isSigned(k) || isUnsigned(k) || isFloat(k)
+}
+
+// isStructType reports whether t is a struct whose fields are mapped, rather than a value like time.Time.
+func isStructType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PtrTo(t).Implements(scannerType)
+}
+
+// fieldMap is the mapped fields of a struct.
+type fieldMap struct {
+	names   map[string][]int
+	folded  map[string][]int // lower case names
+	ordered [][]int
+}
+
+// lookup returns the index of the field by the name, or else by the name in lower case.
+func (m *fieldMap) lookup(name string) ([]int, bool) {
+	if index, ok := m.names[name]; ok {
+		return index, true
+	}
+	index, ok := m.folded[strings.ToLower(name)]
+	return index, ok
+}
+
+// fieldMaps caches the fieldMap of struct types.
+var fieldMaps sync.Map
+
+// structFields returns the fieldMap of the struct type t.
+func structFields(t reflect.Type) *fieldMap {
+	if m, ok := fieldMaps.Load(t); ok {
+		return m.(*fieldMap)
+	}
+	m := &fieldMap{names: make(map[string][]int), folded: make(map[string][]int)}
+	addStructFields(m, t, nil)
+	fieldMaps.Store(t, m)
+	return m
+}
+
+func addStructFields(m *fieldMap, t reflect.Type, parent []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, tagged := f.Tag.Lookup("ch")
+		if !tagged {
+			name, tagged = f.Tag.Lookup("db")
+		}
+		if name == "-" {
+			continue
+		}
+		index := append(append(make([]int, 0, len(parent)+1), parent...), i)
+		if f.Anonymous && !tagged && f.Type.Kind() == reflect.Struct {
+			addStructFields(m, f.Type, index)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := m.names[name]; ok {
+			continue
+		}
+		m.names[name] = index
+		if _, ok := m.folded[strings.ToLower(name)]; !ok {
+			m.folded[strings.ToLower(name)] = index
+		}
+		m.ordered = append(m.ordered, index)
+	}
+}
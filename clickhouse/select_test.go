@@ -0,0 +1,334 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+)
+
+// fakeQueryClient is a Client whose queries return the rows of sqlmock.
+type fakeQueryClient struct {
+	Client
+	db *sql.DB
+}
+
+func newFakeQueryClient(t *testing.T, rows *sqlmock.Rows) *fakeQueryClient {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() err = %v", err)
+	}
+	mock.ExpectQuery("SELECT").WillReturnRows(rows)
+	return &fakeQueryClient{db: db}
+}
+
+func (c *fakeQueryClient) Query(ctx context.Context, next NextFunc, query string, args ...interface{}) error {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := next(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// rawValueConverter passes the values as is, like the clickhouse std driver does.
+type rawValueConverter struct{}
+
+func (rawValueConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	return v, nil
+}
+
+// newRawRows returns the sqlmock rows whose values are passed as is.
+func newRawRows(columns []string) *sqlmock.Rows {
+	db, mock, _ := sqlmock.New(sqlmock.ValueConverterOption(rawValueConverter{}))
+	defer db.Close()
+	return mock.NewRows(columns)
+}
+
+type selectPoint struct {
+	X int64 `ch:"x"`
+	Y int64 `ch:"y"`
+}
+
+type selectBase struct {
+	ID uuid.UUID `ch:"id"`
+}
+
+type selectEvent struct {
+	selectBase
+	Name     string                `db:"name"`
+	Level    int                   // mapped by the field name
+	Tags     []string              `ch:"tags"`
+	Scores   []*int32              `ch:"scores"`
+	Attr     map[string]int64      `ch:"attr"`
+	Point    selectPoint           `ch:"point"`
+	Named    selectPoint           `ch:"named"`
+	Tuple    []interface{}         `ch:"tuple"`
+	Cost     decimal.Decimal       `ch:"cost"`
+	CostText string                `ch:"cost_text"`
+	Time     time.Time             `ch:"time"`
+	Comment  *string               `ch:"comment"`
+	Ignored  string                `ch:"-"`
+	ignored  string                //nolint:unused
+	Nullable sql.NullInt64         `ch:"nullable"`
+	Any      interface{}           `ch:"any"`
+	Extra    map[string]*time.Time `ch:"extra"`
+}
+
+func TestSelect(t *testing.T) {
+	id := uuid.New()
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatalf("LoadLocation() err = %v", err)
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 123456000, loc)
+	comment := "hello"
+	score := int32(7)
+	columns := []string{"id", "name", "level", "tags", "scores", "attr", "point", "named", "tuple",
+		"cost", "cost_text", "time", "comment", "nullable", "any", "extra"}
+	rows := newRawRows(columns).
+		AddRow(id.String(), "a", uint8(1), []string{"t1", "t2"}, []*int32{&score, nil},
+			map[string]uint32{"k": 1}, &[]interface{}{int64(1), int32(2)},
+			&map[string]interface{}{"x": int64(3), "y": int64(4)}, &[]interface{}{"a", 1},
+			"1.25", decimal.RequireFromString("2.50"), now, &comment, int64(5), "v",
+			map[string]*time.Time{"t": &now}).
+		AddRow(id.String(), "b", int64(2), nil, nil, nil, &[]interface{}{int64(5), int64(6)},
+			&map[string]interface{}{}, nil, "0", decimal.Zero, now, (*string)(nil), nil, nil, nil)
+
+	events, err := Select[selectEvent](context.Background(), newFakeQueryClient(t, rows), "SELECT * FROM events")
+	if err != nil || len(events) != 2 {
+		t.Fatalf("Select() = %d events, %v, want 2 events", len(events), err)
+	}
+	if !decimal.RequireFromString("1.25").Equal(events[0].Cost) || !events[1].Cost.IsZero() {
+		t.Fatalf("costs = %v and %v, want 1.25 and 0", events[0].Cost, events[1].Cost)
+	}
+	if events[0].Time.Location() != loc {
+		t.Fatalf("location = %v, want %v", events[0].Time.Location(), loc)
+	}
+	events[0].Cost, events[1].Cost = decimal.Decimal{}, decimal.Decimal{}
+	want := []selectEvent{{
+		selectBase: selectBase{ID: id},
+		Name:       "a",
+		Level:      1,
+		Tags:       []string{"t1", "t2"},
+		Scores:     []*int32{&score, nil},
+		Attr:       map[string]int64{"k": 1},
+		Point:      selectPoint{X: 1, Y: 2},
+		Named:      selectPoint{X: 3, Y: 4},
+		Tuple:      []interface{}{"a", 1},
+		CostText:   "2.5",
+		Time:       now,
+		Comment:    &comment,
+		Nullable:   sql.NullInt64{Int64: 5, Valid: true},
+		Any:        "v",
+		Extra:      map[string]*time.Time{"t": &now},
+	}, {
+		selectBase: selectBase{ID: id},
+		Name:       "b",
+		Level:      2,
+		Point:      selectPoint{X: 5, Y: 6},
+		CostText:   "0",
+		Time:       now,
+	}}
+	for i := range want {
+		if !reflect.DeepEqual(events[i], want[i]) {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestSelect_Types(t *testing.T) {
+	ctx := context.Background()
+	// Pointers to structs.
+	rows := newRawRows([]string{"X", "Y"}).AddRow(int64(1), int64(2))
+	points, err := Select[*selectPoint](ctx, newFakeQueryClient(t, rows), "SELECT")
+	if err != nil || !reflect.DeepEqual(points, []*selectPoint{{X: 1, Y: 2}}) {
+		t.Fatalf("Select() = %v, %v, want [{1 2}]", points, err)
+	}
+
+	// Single columns.
+	rows = newRawRows([]string{"count()"}).AddRow(uint64(3)).AddRow(uint64(4))
+	counts, err := Select[int](ctx, newFakeQueryClient(t, rows), "SELECT")
+	if err != nil || !reflect.DeepEqual(counts, []int{3, 4}) {
+		t.Fatalf("Select() = %v, %v, want [3 4]", counts, err)
+	}
+
+	now := time.Now()
+	rows = newRawRows([]string{"time"}).AddRow(now)
+	times, err := Select[time.Time](ctx, newFakeQueryClient(t, rows), "SELECT")
+	if err != nil || !reflect.DeepEqual(times, []time.Time{now}) {
+		t.Fatalf("Select() = %v, %v, want [%v]", times, err, now)
+	}
+
+	rows = newRawRows([]string{"cost"}).AddRow("1.5")
+	costs, err := Select[*decimal.Decimal](ctx, newFakeQueryClient(t, rows), "SELECT")
+	if err != nil || len(costs) != 1 || costs[0].String() != "1.5" {
+		t.Fatalf("Select() = %v, %v, want [1.5]", costs, err)
+	}
+
+	// Floats are rounded.
+	rows = newRawRows([]string{"ratio"}).AddRow(0.1).AddRow(float32(0.5))
+	ratios, err := Select[float32](ctx, newFakeQueryClient(t, rows), "SELECT")
+	if err != nil || !reflect.DeepEqual(ratios, []float32{0.1, 0.5}) {
+		t.Fatalf("Select() = %v, %v, want [0.1 0.5]", ratios, err)
+	}
+
+	// No rows.
+	rows = newRawRows([]string{"x"})
+	empty, err := Select[int](ctx, newFakeQueryClient(t, rows), "SELECT")
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("Select() = %v, %v, want empty", empty, err)
+	}
+}
+func TestSelect_Errors(t *testing.T) {
+	for name, c := range map[string]struct {
+		rows *sqlmock.Rows
+		fn   func(Client) error
+		err  string
+	}{
+		"unknown column": {
+			rows: newRawRows([]string{"x", "z"}).AddRow(int64(1), int64(2)),
+			fn:   func(c Client) error { _, err := Select[selectPoint](context.Background(), c, "SELECT"); return err },
+			err:  "column z has no field in clickhouse.selectPoint",
+		},
+		"multiple columns": {
+			rows: newRawRows([]string{"x", "y"}).AddRow(int64(1), int64(2)),
+			fn:   func(c Client) error { _, err := Select[int](context.Background(), c, "SELECT"); return err },
+			err:  "2 columns can not be scanned into int",
+		},
+		"NULL": {
+			rows: newRawRows([]string{"x"}).AddRow(nil),
+			fn:   func(c Client) error { _, err := Select[selectPoint](context.Background(), c, "SELECT"); return err },
+			err:  "NULL can not be assigned to int64",
+		},
+		"overflow": {
+			rows: newRawRows([]string{"x"}).AddRow(int64(300)),
+			fn:   func(c Client) error { _, err := Select[uint8](context.Background(), c, "SELECT"); return err },
+			err:  "300 of int64 can not be held by uint8",
+		},
+		"float overflow": {
+			rows: newRawRows([]string{"x"}).AddRow(1e300),
+			fn:   func(c Client) error { _, err := Select[float32](context.Background(), c, "SELECT"); return err },
+			err:  "1e+300 of float64 can not be held by float32",
+		},
+		"negative": {
+			rows: newRawRows([]string{"x"}).AddRow(int64(-1)),
+			fn:   func(c Client) error { _, err := Select[uint64](context.Background(), c, "SELECT"); return err },
+			err:  "-1 of int64 can not be held by uint64",
+		},
+		"mismatch": {
+			rows: newRawRows([]string{"x"}).AddRow("a"),
+			fn:   func(c Client) error { _, err := Select[selectPoint](context.Background(), c, "SELECT"); return err },
+			err:  "column x into int64: string can not be assigned to int64",
+		},
+		"array element": {
+			rows: newRawRows([]string{"x"}).AddRow([]string{"a"}),
+			fn:   func(c Client) error { _, err := Select[[]int](context.Background(), c, "SELECT"); return err },
+			err:  "element 0: string can not be assigned to int",
+		},
+		"tuple": {
+			rows: newRawRows([]string{"point"}).AddRow(&[]interface{}{int64(1)}),
+			fn:   func(c Client) error { _, err := Select[selectPoint](context.Background(), c, "SELECT"); return err },
+			err:  "column point has no field",
+		},
+		"tuple length": {
+			rows: newRawRows([]string{"point"}).AddRow(&[]interface{}{int64(1)}),
+			fn: func(c Client) error {
+				_, err := Select[selectEvent](context.Background(), c, "SELECT")
+				return err
+			},
+			err: "tuple of 1 elements can not be assigned to clickhouse.selectPoint of 2 fields",
+		},
+		"named tuple": {
+			rows: newRawRows([]string{"named"}).AddRow(&map[string]interface{}{"z": 1}),
+			fn: func(c Client) error {
+				_, err := Select[selectEvent](context.Background(), c, "SELECT")
+				return err
+			},
+			err: "element z has no field in clickhouse.selectPoint",
+		},
+		"query": {
+			rows: newRawRows([]string{"x"}).AddRow(int64(1)).RowError(0, driver.ErrBadConn),
+			fn:   func(c Client) error { _, err := Select[int](context.Background(), c, "SELECT"); return err },
+			err:  driver.ErrBadConn.Error(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := c.fn(newFakeQueryClient(t, c.rows)); err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("err = %v, want containing %q", err, c.err)
+			}
+		})
+	}
+}
+
+// fakeQueryClient scans the rows into structs by sqlx like the transport does for QueryToStructs.
+func (c *fakeQueryClient) QueryToStructs(ctx context.Context, dest interface{}, query string,
+	args ...interface{}) error {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	return sqlx.StructScan(rows, dest)
+}
+
+type benchEvent struct {
+	ID    int64     `db:"id"`
+	Name  string    `db:"name"`
+	Score float64   `db:"score"`
+	Time  time.Time `db:"time"`
+}
+
+// newBenchQueryClient returns a client whose n queries return the same 100 rows.
+func newBenchQueryClient(b *testing.B, n int) *fakeQueryClient {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		b.Fatalf("sqlmock.New() err = %v", err)
+	}
+	b.Cleanup(func() { db.Close() })
+	rows := sqlmock.NewRows([]string{"id", "name", "score", "time"})
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		rows.AddRow(int64(i), "event", float64(i)/2, now)
+	}
+	for i := 0; i < n; i++ {
+		mock.ExpectQuery("SELECT").WillReturnRows(rows)
+	}
+	return &fakeQueryClient{db: db}
+}
+
+func BenchmarkSelect(b *testing.B) {
+	c := newBenchQueryClient(b, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Select[benchEvent](context.Background(), c, "SELECT"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQueryToStructs(b *testing.B) {
+	c := newBenchQueryClient(b, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var events []benchEvent
+		if err := c.QueryToStructs(context.Background(), &events, "SELECT"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -4,7 +4,10 @@ package clickhouse
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
 	"strings"
@@ -12,9 +15,11 @@ import (
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
+	chdriver "github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/jmoiron/sqlx"
 	"trpc.group/trpc-go/trpc-go/codec"
 	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/log"
 	"trpc.group/trpc-go/trpc-go/naming/selector"
 	"trpc.group/trpc-go/trpc-go/transport"
 	dsn "trpc.group/trpc-go/trpc-selector-dsn"
@@ -30,7 +35,11 @@ func init() {
 type ClientTransport struct {
 	opts   *transport.ClientTransportOptions
 	dbs    map[string]*sql.DB
+	conns  map[string]chdriver.Conn
 	dblock sync.RWMutex
+	// balancers is the balancers of the addresses, nil values for addresses not balanced.
+	balancers map[string]*balancer
+	balock    sync.RWMutex
 	options
 }
 
@@ -52,8 +61,11 @@ func NewClientTransport(opt ...transport.ClientTransportOption) transport.Client
 	}
 
 	return &ClientTransport{
-		opts: opts,
-		dbs:  make(map[string]*sql.DB),
+		opts:  opts,
+		dbs:   make(map[string]*sql.DB),
+		conns: make(map[string]chdriver.Conn),
+
+		balancers: make(map[string]*balancer),
 		options: options{
 			MaxIdle:     10,
 			MaxOpen:     10000,
@@ -97,18 +109,112 @@ func (ct *ClientTransport) RoundTrip(
 	for _, o := range callOpts {
 		o(opts)
 	}
+	ctx, recorder := withQueryRecorder(ctx)
+	if recorder != nil {
+		defer recorder.finish(msg)
+	}
 	dsn := opts.Address
-	db, err := ct.GetDB(dsn)
+	b, err := ct.getBalancer(dsn)
 	if err != nil {
 		return
 	}
-
-	err = runCommand(ctx, db, req, rsp)
+	if b != nil {
+		dsn, err = b.call(ctx, func(dsn string) error {
+			return ct.invoke(ctx, dsn, req, rsp)
+		})
+		if dsn == "" {
+			return
+		}
+	} else if err = ct.invoke(ctx, dsn, req, rsp); isOpenError(err) {
+		return
+	}
 	withRemoteAddr(msg, dsn)
 	withCommonMeta(msg, filledCommonMeta(msg, calleeApp, msg.RemoteAddr().String()))
 	return
 }
 
+// openError is the error of opening the database of a dsn.
+type openError struct {
+	error
+}
+
+// Unwrap returns the error of opening.
+func (e *openError) Unwrap() error {
+	return e.error
+}
+
+// isOpenError reports whether err fails opening the database.
+func isOpenError(err error) bool {
+	var e *openError
+	return errors.As(err, &e)
+}
+
+// invoke runs the command of req on the database of dsn.
+func (ct *ClientTransport) invoke(ctx context.Context, dsn string, req *Request, rsp *Response) error {
+	if req.op == opBatch {
+		conn, err := ct.GetConn(dsn)
+		if err != nil {
+			return &openError{err}
+		}
+		rsp.Result, err = handleBatch(ctx, conn, req)
+		return err
+	}
+	db, err := ct.GetDB(dsn)
+	if err != nil {
+		return &openError{err}
+	}
+	return runCommand(ctx, db, req, rsp)
+}
+
+// getBalancer returns the balancer of the address, or nil if the address is not balanced.
+func (ct *ClientTransport) getBalancer(address string) (*balancer, error) {
+	ct.balock.RLock()
+	b, ok := ct.balancers[address]
+	ct.balock.RUnlock()
+	if ok {
+		return b, nil
+	}
+
+	ct.balock.Lock()
+	defer ct.balock.Unlock()
+	if b, ok = ct.balancers[address]; ok {
+		return b, nil
+	}
+	b, err := newBalancer(toV2DSN(address))
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		b.evict = ct.closeDBs
+	}
+	ct.balancers[address] = b
+	return b, nil
+}
+
+// closeDBs removes and closes the databases and native connections of the dsns.
+// The databases close the connections in use once the calls in progress release them.
+func (ct *ClientTransport) closeDBs(dsns []string) {
+	var closers []io.Closer
+	ct.dblock.Lock()
+	for _, dsn := range dsns {
+		dsn = toV2DSN(dsn)
+		if db, ok := ct.dbs[dsn]; ok {
+			closers = append(closers, db)
+			delete(ct.dbs, dsn)
+		}
+		if conn, ok := ct.conns[dsn]; ok {
+			closers = append(closers, conn)
+			delete(ct.conns, dsn)
+		}
+	}
+	ct.dblock.Unlock()
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			log.Warnf("clickhouse: close the pool of a host gone err: %v", err)
+		}
+	}
+}
+
 func runCommand(ctx context.Context, db *sql.DB, req *Request, rsp *Response) error {
 	switch req.op {
 	case opExec:
@@ -179,6 +285,30 @@ func handleTransaction(ctx context.Context, db *sql.DB, req *Request) error {
 	return tx.Commit()
 }
 
+func handleBatch(ctx context.Context, conn chdriver.Conn, req *Request) (sql.Result, error) {
+	b, err := conn.PrepareBatch(ctx, req.Exec)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range req.batchRows {
+		if row.structure != nil {
+			err = b.AppendStruct(row.structure)
+		} else {
+			err = b.Append(row.values...)
+		}
+		if err != nil {
+			if e := b.Abort(); e != nil {
+				return nil, fmt.Errorf("append err: %v, abort err: %w", err, e)
+			}
+			return nil, err
+		}
+	}
+	if err := b.Send(); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(req.batchRows)), nil
+}
+
 // GetDB gets clickhouse link.
 func (ct *ClientTransport) GetDB(dsn string) (*sql.DB, error) {
 	dsn = toV2DSN(dsn)
@@ -217,6 +347,48 @@ func (ct *ClientTransport) GetDB(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
+// GetConn gets the native clickhouse connection, which inserts batches by columnar blocks.
+func (ct *ClientTransport) GetConn(dsn string) (chdriver.Conn, error) {
+	dsn = toV2DSN(dsn)
+	ct.dblock.RLock()
+	conn, ok := ct.conns[dsn]
+	ct.dblock.RUnlock()
+
+	if ok {
+		return conn, nil
+	}
+
+	ct.dblock.Lock()
+	defer ct.dblock.Unlock()
+
+	conn, ok = ct.conns[dsn]
+	if ok {
+		return conn, nil
+	}
+	opts, realDSN := parseOptionsFromDSN(dsn)
+	chOpts, err := clickhouse.ParseDSN("tcp://" + realDSN)
+	if err != nil {
+		return nil, err
+	}
+	ct.setOptions(opts)
+	if ct.MaxIdle > 0 {
+		chOpts.MaxIdleConns = ct.MaxIdle
+	}
+	if ct.MaxOpen > 0 {
+		chOpts.MaxOpenConns = ct.MaxOpen
+	}
+	if ct.MaxLifetime > 0 {
+		chOpts.ConnMaxLifetime = ct.MaxLifetime
+	}
+	conn, err = clickhouse.Open(chOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	ct.conns[dsn] = conn
+	return conn, nil
+}
+
 // setOptions sets configuration parameters.
 func (ct *ClientTransport) setOptions(opt *options) {
 	if opt == nil {
@@ -80,6 +80,32 @@ var (
 
 // New creates a redis client.
 var New = func(name string, opts ...client.Option) (
+	redis.UniversalClient, error) {
+	return newClient(name, nil, opts...)
+}
+
+// NewWithClientCache creates a redis client like New, whose GET, HGET and MGET are served by the
+// client-side cache, and the cache is invalidated by the CLIENT TRACKING of redis 6+.
+// Only the single node redis is supported. The keys hit and missed by each command are filled in the
+// Rsp of the client filters. The cache is disabled while the invalidation connection is reconnecting.
+// Without the prefixes, the commands missing the cache are sent by a second client of the redis node,
+// whose connections track the keys read. It has a connection pool of its own with the same pool options,
+// and its connections are dialed without the hooks of the client.
+//
+//	cli, err := goredis.NewWithClientCache("trpc.redis.xxx.xxx", goredis.ClientCacheOptions{
+//		Cache:    localcache.New(localcache.WithCapacity(10000)),
+//		Prefixes: []string{"user:"},
+//	})
+var NewWithClientCache = func(name string, cacheOpts ClientCacheOptions, opts ...client.Option) (
+	redis.UniversalClient, error) {
+	if cacheOpts.Cache == nil {
+		return nil, errs.Newf(RetParamInvalid, "client cache of %s has no local cache", name)
+	}
+	return newClient(name, &cacheOpts, opts...)
+}
+
+// newClient creates a redis client, with the client-side caching if cacheOpts is not nil.
+func newClient(name string, cacheOpts *ClientCacheOptions, opts ...client.Option) (
 	redis.UniversalClient, error) {
 	// parse configuration file.
 	filters, err := joinfilters.New(name, opts...)
@@ -94,16 +120,32 @@ var New = func(name string, opts ...client.Option) (
 	if err != nil {
 		return nil, err
 	}
-	redisClient := redis.NewUniversalClient(option.RedisOption)
 	redisHook, err := newHook(filters, option)
 	if err != nil {
 		return nil, err
 	}
+	if cacheOpts != nil {
+		r := option.RedisOption
+		if option.QueryOption.IsProxy || r.MasterName != "" || len(r.Addrs) != 1 {
+			return nil, errs.Newf(RetInitFail, "client cache only supports the single node redis")
+		}
+		redisHook.cache = newClientCache(name, *cacheOpts, r.Simple())
+		if err = redisHook.cache.start(); err != nil {
+			return nil, errs.Wrapf(err, RetInitFail, "client cache start fail %v", err)
+		}
+	}
+	redisClient := redis.NewUniversalClient(option.RedisOption)
 	redisClient.AddHook(redisHook)
 	// check the connection health
 	if _, err = redisClient.Ping(trpc.BackgroundContext()).Result(); err != nil {
+		if redisHook.cache != nil {
+			redisHook.cache.Close()
+		}
 		return nil, errs.Wrapf(err, RetInitFail, "New Ping fail %v", err)
 	}
+	if redisHook.cache != nil {
+		return &cachedClient{UniversalClient: redisClient, cache: redisHook.cache}, nil
+	}
 	return redisClient, nil
 }
 
@@ -114,7 +156,9 @@ type Req struct {
 
 // Rsp trpc filter response.
 type Rsp struct {
-	Cmd string
+	Cmd         string
+	CacheHits   int // keys served by the client-side cache of NewWithClientCache
+	CacheMisses int // keys the client-side cache read from redis
 }
 
 // Message redis context
@@ -142,6 +186,7 @@ type hook struct {
 	remoteAddr net.Addr
 	options    *options.Options
 	selector   selector.Selector
+	cache      *clientCache // nil if the client-side caching is disabled
 }
 
 func newHook(f *joinfilters.Filters, o *options.Options) (*hook, error) {
@@ -187,17 +232,24 @@ func (h *hook) DialHook(next redis.DialHook) redis.DialHook {
 
 // ProcessHook is triggered when sending single redis command.
 func (h *hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	process := func(ctx context.Context, cmd redis.Cmder, rsp *Rsp) (err error) {
+		if h.cache == nil {
+			return next(ctx, cmd)
+		}
+		rsp.CacheHits, rsp.CacheMisses, err = h.cache.process(ctx, next, cmd)
+		return err
+	}
 	return func(ctx context.Context, cmd redis.Cmder) error {
 		// redis parameter
 		ctx, redisMsg := WithMessage(ctx)
 		if !redisMsg.EnableFilter {
-			return next(ctx, cmd)
+			return process(ctx, cmd, &Rsp{})
 		}
 		reqBody := nameKey(cmd)
-		call := func(context.Context) (string, error) {
-			nextErr := next(ctx, cmd)
-			rspBody := fixedResponseLength(reqBody, cmd.String(), MaxRspLen)
-			return rspBody, nextErr
+		call := func(_ context.Context, rsp *Rsp) error {
+			nextErr := process(ctx, cmd, rsp)
+			rsp.Cmd = fixedResponseLength(reqBody, cmd.String(), MaxRspLen)
+			return nextErr
 		}
 		req := &invokeReq{
 			rpcName:      cmd.Name(),
@@ -216,10 +268,10 @@ func (h *hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.Process
 		if !redisMsg.EnableFilter {
 			return next(ctx, cmds)
 		}
-		call := func(context.Context) (string, error) {
+		call := func(_ context.Context, rsp *Rsp) error {
 			nextErr := next(ctx, cmds)
-			rspBody := pipelineRspBody(cmds)
-			return rspBody, nextErr
+			rsp.Cmd = pipelineRspBody(cmds)
+			return nextErr
 		}
 		req := &invokeReq{
 			rpcName: "pipeline",
@@ -234,7 +286,7 @@ type invokeReq struct {
 	rpcName      string
 	calleeMethod string
 	reqBody      string
-	call         func(context.Context) (string, error)
+	call         func(context.Context, *Rsp) error // fills the response of the filters
 }
 
 func (h *hook) invoke(ctx context.Context, req *invokeReq, opts ...client.Option) error {
@@ -245,7 +297,7 @@ func (h *hook) invoke(ctx context.Context, req *invokeReq, opts ...client.Option
 		rRsp    = &Rsp{}
 	)
 	trpcErr := h.filters.Invoke(ctx, rReq, rRsp, func(ctx context.Context, _, _ interface{}) error {
-		rRsp.Cmd, callErr = req.call(ctx)
+		callErr = req.call(ctx, rRsp)
 		return TRPCErr(callErr)
 	}, opts...)
 	if callErr != nil {
@@ -0,0 +1,465 @@
+package goredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+const (
+	defaultClientCacheTTL = time.Minute
+	invalidationSlots     = 1 << 12
+	maxReconnectBackoff   = 30 * time.Second
+)
+
+// LocalCache stores the values of the client-side caching, which is satisfied by localcache.Cache.
+// It should be created with a capacity, such as localcache.New(localcache.WithCapacity(10000)).
+type LocalCache interface {
+	Get(key string) (interface{}, bool)
+	// SetWithExpire sets the value of the key with the TTL in seconds.
+	SetWithExpire(key string, value interface{}, ttl int64) bool
+	Del(key string)
+}
+
+// ClientCacheOptions is the options of the client-side caching of NewWithClientCache.
+type ClientCacheOptions struct {
+	// Cache stores the values, which is required.
+	Cache LocalCache
+	// Prefixes enables the broadcast mode, in which the writes of the keys with the prefixes are
+	// invalidated, no matter whether the keys are cached, and only these keys are cached. Otherwise,
+	// only the keys read by the client are tracked by redis.
+	Prefixes []string
+	// TTL is the max duration of the values cached, which is rounded up to seconds, 1 minute by default.
+	TTL time.Duration
+}
+
+// errClientCacheClosed is returned by connecting the client cache closed.
+var errClientCacheClosed = errors.New("goredis: client cache closed")
+
+// cacheEntry is a value cached.
+type cacheEntry struct {
+	seq    uint64 // sequence of the read
+	value  string
+	exists bool // false if the reply is redis.Nil
+}
+
+// clientCache serves GET, HGET and MGET by the local cache, which is invalidated by the invalidation
+// messages of the RESP3 CLIENT TRACKING of redis 6+.
+//
+// Each read of redis takes a sequence number, and each invalidation records the sequence number in the
+// slot of the key. A value cached is only valid if it is read after the last invalidation of its slot
+// and the last flush, so the values read concurrently with the invalidations are never served.
+type clientCache struct {
+	name     string // service name for logs
+	opts     ClientCacheOptions
+	ttl      int64
+	redisOpt *redis.Options
+
+	seq     uint64                    // atomic, the last sequence number
+	flushed uint64                    // atomic, the sequence number of the last flush
+	slots   [invalidationSlots]uint64 // atomic, the sequence numbers of the last invalidations
+
+	mu       sync.RWMutex
+	ready    bool          // whether the invalidation messages are received
+	tracking *redis.Client // client whose reads are tracked, nil in the broadcast mode
+	conn     *respConn     // invalidation connection
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	// newTrackingClient creates the client whose reads are tracked by the options of trackingOptions.
+	newTrackingClient func(opt *redis.Options) *redis.Client
+}
+
+// newClientCache creates a client cache of the redis node of redisOpt, which is started by start.
+func newClientCache(name string, opts ClientCacheOptions, redisOpt *redis.Options) *clientCache {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultClientCacheTTL
+	}
+	c := &clientCache{
+		name:     name,
+		opts:     opts,
+		ttl:      int64((opts.TTL + time.Second - 1) / time.Second),
+		redisOpt: redisOpt,
+		done:     make(chan struct{}),
+	}
+	c.newTrackingClient = redis.NewClient
+	return c
+}
+
+// start connects the invalidation connection, and receives the invalidation messages in background.
+func (c *clientCache) start() error {
+	conn, err := c.connect(context.Background())
+	if err != nil {
+		return err
+	}
+	c.wg.Add(1)
+	go c.run(conn)
+	return nil
+}
+
+// trackingOptions returns the options of a second connection pool of the redis node, whose connections
+// track the keys read, and redirect the invalidation messages to the client id. It is not hooked, since
+// its commands are already processed by the hook of the client.
+//
+// Redis drops the invalidations of the keys read by a connection once it is closed, so the cache is
+// flushed whenever a connection of the pool is closed, and the pool only closes the connections on
+// failures rather than by their idle time or age. The values read by a connection closed by redis
+// may still be served until the pool finds it broken, or until they expire.
+func (c *clientCache) trackingOptions(id int64) *redis.Options {
+	opt := *c.redisOpt
+	opt.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, c.redisOpt, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &trackedConn{Conn: conn, onClose: c.flush}, nil
+	}
+	opt.MaxIdleConns, opt.ConnMaxIdleTime, opt.ConnMaxLifetime = 0, -1, 0
+	onConnect := opt.OnConnect
+	opt.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		if onConnect != nil {
+			if err := onConnect(ctx, cn); err != nil {
+				return err
+			}
+		}
+		return cn.Process(ctx, redis.NewStatusCmd(ctx, "CLIENT", "TRACKING", "ON", "REDIRECT",
+			strconv.FormatInt(id, 10)))
+	}
+	return &opt
+}
+
+// trackedConn is a connection of the tracking client, which calls onClose once closed.
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+// Close closes the connection, and then calls onClose, so the reads of the connection are all before it.
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.onClose)
+	return err
+}
+
+// connect connects the invalidation connection, and makes the cache ready.
+func (c *clientCache) connect(ctx context.Context) (*respConn, error) {
+	conn, id, err := c.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var tracking *redis.Client
+	if len(c.opts.Prefixes) == 0 {
+		tracking = c.newTrackingClient(c.trackingOptions(id))
+		// Check that the tracking is supported.
+		if err := tracking.Ping(ctx).Err(); err != nil {
+			tracking.Close()
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		if tracking != nil {
+			tracking.Close()
+		}
+		conn.Close()
+		return nil, errClientCacheClosed
+	}
+	c.ready, c.tracking, c.conn = true, tracking, conn
+	return conn, nil
+}
+
+// disconnect makes the cache not ready and flushes it, since the invalidation messages may be lost.
+func (c *clientCache) disconnect() {
+	c.mu.Lock()
+	tracking, conn := c.tracking, c.conn
+	c.ready, c.tracking, c.conn = false, nil, nil
+	c.mu.Unlock()
+	c.flush()
+	if tracking != nil {
+		tracking.Close()
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// run receives the invalidation messages, and reconnects the invalidation connection on failures.
+func (c *clientCache) run(conn *respConn) {
+	defer c.wg.Done()
+	for {
+		err := c.receive(conn)
+		c.disconnect()
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		log.Warnf("goredis client cache of %s disconnected, the cache is disabled until reconnected: %v",
+			c.name, err)
+		for backoff := time.Second; ; backoff *= 2 {
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			select {
+			case <-c.done:
+				return
+			case <-time.After(backoff):
+			}
+			if conn, err = c.connect(context.Background()); err == nil {
+				break
+			}
+			log.Warnf("goredis client cache of %s reconnect err: %v", c.name, err)
+		}
+	}
+}
+
+// Close stops the invalidation connection.
+func (c *clientCache) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.mu.Unlock()
+	c.disconnect()
+	c.wg.Wait()
+}
+
+// flush invalidates all the values cached.
+func (c *clientCache) flush() {
+	atomic.StoreUint64(&c.flushed, atomic.AddUint64(&c.seq, 1))
+}
+
+// invalidate invalidates the values of the key.
+func (c *clientCache) invalidate(key string) {
+	atomic.StoreUint64(&c.slots[slotOf(key)], atomic.AddUint64(&c.seq, 1))
+	c.opts.Cache.Del(getCacheKey(key))
+}
+
+// valid reports whether the value of the key read with the sequence number is not invalidated.
+func (c *clientCache) valid(key string, seq uint64) bool {
+	return seq > atomic.LoadUint64(&c.flushed) && seq > atomic.LoadUint64(&c.slots[slotOf(key)])
+}
+
+// get returns the valid value cached by the local key of the key.
+func (c *clientCache) get(key, localKey string) (*cacheEntry, bool) {
+	c.mu.RLock()
+	ready := c.ready
+	c.mu.RUnlock()
+	if !ready {
+		return nil, false
+	}
+	v, ok := c.opts.Cache.Get(localKey)
+	if !ok {
+		return nil, false
+	}
+	e, ok := v.(*cacheEntry)
+	if !ok || !c.valid(key, e.seq) {
+		return nil, false
+	}
+	return e, true
+}
+
+// set caches the value of the key if it is not invalidated since read.
+func (c *clientCache) set(key, localKey string, e *cacheEntry) {
+	if c.valid(key, e.seq) {
+		c.opts.Cache.SetWithExpire(localKey, e, c.ttl)
+	}
+}
+
+// load reads redis by cmd, and returns the sequence number of the read, which is 0 if the reply can not be cached.
+// The reads are tracked by the tracking client, or by the prefixes in the broadcast mode,
+// in which the reads are sent by next as usual.
+func (c *clientCache) load(ctx context.Context, next redis.ProcessHook, cmd redis.Cmder) (uint64, error) {
+	c.mu.RLock()
+	ready, tracking := c.ready, c.tracking
+	c.mu.RUnlock()
+	if !ready {
+		return 0, next(ctx, cmd)
+	}
+	seq := atomic.AddUint64(&c.seq, 1)
+	if tracking != nil {
+		return seq, tracking.Process(ctx, cmd)
+	}
+	return seq, next(ctx, cmd)
+}
+
+// process serves GET, HGET and MGET by the cache, and returns the keys hit and missed.
+// The other commands are processed by next.
+func (c *clientCache) process(ctx context.Context, next redis.ProcessHook, cmd redis.Cmder) (
+	hits, misses int, err error) {
+	args := cmd.Args()
+	switch cmd.Name() {
+	case "get":
+		key, ok := stringArg(args, 1)
+		if cmd, isString := cmd.(*redis.StringCmd); isString && ok && len(args) == 2 && c.cacheable(key) {
+			return c.processGet(ctx, next, cmd, key, getCacheKey(key))
+		}
+	case "hget":
+		key, ok := stringArg(args, 1)
+		field, fok := stringArg(args, 2)
+		if cmd, isString := cmd.(*redis.StringCmd); isString && ok && fok && len(args) == 3 && c.cacheable(key) {
+			return c.processGet(ctx, next, cmd, key, hgetCacheKey(key, field))
+		}
+	case "mget":
+		if cmd, ok := cmd.(*redis.SliceCmd); ok && len(args) > 1 {
+			keys := make([]string, 0, len(args)-1)
+			for i := 1; i < len(args); i++ {
+				key, ok := stringArg(args, i)
+				if !ok {
+					return 0, 0, next(ctx, cmd)
+				}
+				keys = append(keys, key)
+			}
+			return c.processMGet(ctx, next, cmd, keys)
+		}
+	}
+	return 0, 0, next(ctx, cmd)
+}
+
+// cacheable reports whether the key can be cached, which must have one of the prefixes in the broadcast mode,
+// since the writes of the other keys are not invalidated.
+func (c *clientCache) cacheable(key string) bool {
+	if len(c.opts.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.opts.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// processGet serves GET and HGET.
+func (c *clientCache) processGet(ctx context.Context, next redis.ProcessHook, cmd *redis.StringCmd,
+	key, localKey string) (hits, misses int, err error) {
+	if e, ok := c.get(key, localKey); ok {
+		if !e.exists {
+			cmd.SetErr(redis.Nil)
+			return 1, 0, redis.Nil
+		}
+		cmd.SetVal(e.value)
+		return 1, 0, nil
+	}
+	seq, err := c.load(ctx, next, cmd)
+	if seq != 0 && (err == nil || err == redis.Nil) {
+		c.set(key, localKey, &cacheEntry{seq: seq, value: cmd.Val(), exists: err == nil})
+	}
+	return 0, 1, err
+}
+
+// processMGet serves MGET, which only reads the keys not cached from redis, as well as the keys not cacheable.
+func (c *clientCache) processMGet(ctx context.Context, next redis.ProcessHook, cmd *redis.SliceCmd,
+	keys []string) (hits, misses int, err error) {
+	vals := make([]interface{}, len(keys))
+	var missed []int
+	for i, key := range keys {
+		if !c.cacheable(key) {
+			missed = append(missed, i)
+			continue
+		}
+		e, ok := c.get(key, getCacheKey(key))
+		switch {
+		case !ok:
+			missed = append(missed, i)
+			misses++
+			continue
+		case e.exists:
+			vals[i] = e.value
+		}
+		hits++
+	}
+	if len(missed) == 0 {
+		cmd.SetVal(vals)
+		return hits, misses, nil
+	}
+	load := cmd
+	if len(missed) < len(keys) {
+		args := make([]interface{}, 0, len(missed)+1)
+		args = append(args, "mget")
+		for _, i := range missed {
+			args = append(args, keys[i])
+		}
+		load = redis.NewSliceCmd(ctx, args...)
+	}
+	seq, err := c.load(ctx, next, load)
+	if err != nil {
+		cmd.SetErr(err)
+		return hits, misses, err
+	}
+	loaded := load.Val()
+	if len(loaded) != len(missed) {
+		err = fmt.Errorf("goredis: MGET of %d keys replied %d values", len(missed), len(loaded))
+		cmd.SetErr(err)
+		return hits, misses, err
+	}
+	for j, i := range missed {
+		vals[i] = loaded[j]
+		if seq == 0 || !c.cacheable(keys[i]) {
+			continue
+		}
+		value, ok := loaded[j].(string)
+		if ok || loaded[j] == nil {
+			c.set(keys[i], getCacheKey(keys[i]), &cacheEntry{seq: seq, value: value, exists: ok})
+		}
+	}
+	cmd.SetVal(vals)
+	return hits, misses, nil
+}
+
+// getCacheKey returns the local key of GET.
+func getCacheKey(key string) string {
+	return "get\x00" + key
+}
+
+// hgetCacheKey returns the local key of HGET.
+// The fields are not deleted on invalidations, but are invalidated by the slot of the key.
+func hgetCacheKey(key, field string) string {
+	return "hget\x00" + key + "\x00" + field
+}
+
+// slotOf returns the invalidation slot of the key by FNV-1a.
+func slotOf(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % invalidationSlots)
+}
+
+func stringArg(args []interface{}, i int) (string, bool) {
+	if i >= len(args) {
+		return "", false
+	}
+	s, ok := args[i].(string)
+	return s, ok
+}
+
+// cachedClient is the client of NewWithClientCache, which closes the client cache on Close.
+type cachedClient struct {
+	redis.UniversalClient
+	cache *clientCache
+}
+
+// Close closes the client cache and the client.
+func (c *cachedClient) Close() error {
+	c.cache.Close()
+	return c.UniversalClient.Close()
+}
@@ -0,0 +1,439 @@
+package goredis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trpc.group/trpc-go/trpc-database/goredis/internal/joinfilters"
+	"trpc.group/trpc-go/trpc-database/goredis/internal/options"
+	pb "trpc.group/trpc-go/trpc-database/goredis/internal/proto"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/errs"
+	"trpc.group/trpc-go/trpc-go/filter"
+)
+
+// mapCache is a LocalCache of a map.
+type mapCache struct {
+	mu sync.Mutex
+	m  map[string]interface{}
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{m: make(map[string]interface{})}
+}
+
+func (c *mapCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[key]
+	return v, ok
+}
+
+func (c *mapCache) SetWithExpire(key string, value interface{}, _ int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[key] = value
+	return true
+}
+
+func (c *mapCache) Del(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, key)
+}
+
+// fakeTrackingServer is a RESP2 server of the invalidation connections, which sends the invalidation messages.
+type fakeTrackingServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+	cmds  []string
+}
+
+func newFakeTrackingServer(t *testing.T) *fakeTrackingServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeTrackingServer{ln: ln}
+	t.Cleanup(func() {
+		ln.Close()
+		s.disconnect()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeTrackingServer) serve(conn net.Conn) {
+	rc := newRESPConn(conn)
+	for {
+		reply, err := rc.read()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+		cmd := strings.Join(args, " ")
+		s.mu.Lock()
+		s.cmds = append(s.cmds, cmd)
+		var rsp string
+		switch {
+		case cmd == "CLIENT ID":
+			rsp = ":7\r\n"
+		case strings.HasPrefix(cmd, "CLIENT TRACKING"), strings.HasPrefix(cmd, "AUTH"):
+			rsp = "+OK\r\n"
+		case cmd == "SUBSCRIBE "+invalidationChannel:
+			rsp = fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n",
+				len(invalidationChannel), invalidationChannel)
+			s.conns = append(s.conns, conn)
+		case cmd == "PING":
+			rsp = "*2\r\n$4\r\npong\r\n$0\r\n\r\n"
+		default:
+			rsp = "-ERR unknown command\r\n"
+		}
+		fmt.Fprint(conn, rsp)
+		s.mu.Unlock()
+	}
+}
+
+// invalidate sends the invalidation message of the keys, or of flushing if keys is nil.
+func (s *fakeTrackingServer) invalidate(keys ...string) {
+	msg := fmt.Sprintf("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n", len(invalidationChannel), invalidationChannel)
+	if keys == nil {
+		msg += "*-1\r\n"
+	} else {
+		msg += fmt.Sprintf("*%d\r\n", len(keys))
+		for _, key := range keys {
+			msg += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		fmt.Fprint(conn, msg)
+	}
+}
+
+func (s *fakeTrackingServer) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeTrackingServer) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+// cacheHook is a redis.Hook of the client cache.
+type cacheHook struct {
+	cache *clientCache
+}
+
+func (h cacheHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h cacheHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		_, _, err := h.cache.process(ctx, next, cmd)
+		return err
+	}
+}
+
+func (h cacheHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// trackingHook replies CLIENT TRACKING of the tracking connections, and records its redirect.
+type trackingHook struct {
+	redirect *int64
+}
+
+func (h trackingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h trackingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		args := cmd.Args()
+		if cmd.Name() != "client" || len(args) != 5 || args[1] != "TRACKING" || args[3] != "REDIRECT" {
+			return next(ctx, cmd)
+		}
+		id, err := strconv.ParseInt(args[4].(string), 10, 64)
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt64(h.redirect, id)
+		return nil
+	}
+}
+
+func (h trackingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestClientCache_Broadcast(t *testing.T) {
+	s := miniredis.RunT(t)
+	srv := newFakeTrackingServer(t)
+	c := newClientCache("trpc.redis.test", ClientCacheOptions{Cache: newMapCache(), Prefixes: []string{"k", "h"}},
+		&redis.Options{Addr: srv.ln.Addr().String(), Username: "user", Password: "pass"})
+	require.NoError(t, c.start())
+	defer c.Close()
+	require.Equal(t, []string{
+		"AUTH user pass",
+		"CLIENT ID",
+		"CLIENT TRACKING ON REDIRECT 7 BCAST PREFIX k PREFIX h",
+		"SUBSCRIBE " + invalidationChannel,
+	}, srv.commands())
+
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rdb.Close()
+	rdb.AddHook(cacheHook{cache: c})
+	ctx := context.Background()
+
+	// GET is served by the cache until invalidated.
+	require.NoError(t, s.Set("k1", "v1"))
+	require.Equal(t, "v1", rdb.Get(ctx, "k1").Val())
+	require.NoError(t, s.Set("k1", "v2"))
+	require.Equal(t, "v1", rdb.Get(ctx, "k1").Val())
+	srv.invalidate("k1")
+	require.Eventually(t, func() bool { return rdb.Get(ctx, "k1").Val() == "v2" }, time.Second, 10*time.Millisecond)
+
+	// Keys not found are cached too, until flushed.
+	require.ErrorIs(t, rdb.Get(ctx, "k2").Err(), redis.Nil)
+	require.NoError(t, s.Set("k2", "v2"))
+	require.ErrorIs(t, rdb.Get(ctx, "k2").Err(), redis.Nil)
+	srv.invalidate()
+	require.Eventually(t, func() bool { return rdb.Get(ctx, "k2").Val() == "v2" }, time.Second, 10*time.Millisecond)
+
+	// HGET fields are invalidated by their keys.
+	s.HSet("h1", "f1", "1")
+	require.Equal(t, "1", rdb.HGet(ctx, "h1", "f1").Val())
+	s.HSet("h1", "f1", "2")
+	require.Equal(t, "1", rdb.HGet(ctx, "h1", "f1").Val())
+	srv.invalidate("h1")
+	require.Eventually(t, func() bool {
+		return rdb.HGet(ctx, "h1", "f1").Val() == "2"
+	}, time.Second, 10*time.Millisecond)
+
+	// MGET reads the keys not cached.
+	require.NoError(t, s.Set("k3", "v3"))
+	require.Equal(t, []interface{}{"v2", nil, "v3"}, rdb.MGet(ctx, "k1", "k4", "k3").Val())
+	require.NoError(t, s.Set("k3", "v4"))
+	require.NoError(t, s.Set("k4", "v4"))
+	require.Equal(t, []interface{}{"v2", nil, "v3"}, rdb.MGet(ctx, "k1", "k4", "k3").Val())
+	srv.invalidate("k4")
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]interface{}{"v2", "v4", "v3"}, rdb.MGet(ctx, "k1", "k4", "k3").Val())
+	}, time.Second, 10*time.Millisecond)
+
+	// The keys without the prefixes are not cached, since their writes are not invalidated.
+	require.NoError(t, rdb.Set(ctx, "x1", "v1", 0).Err())
+	require.Equal(t, "v1", rdb.Get(ctx, "x1").Val())
+	require.NoError(t, rdb.Set(ctx, "x1", "v2", 0).Err())
+	require.Equal(t, "v2", rdb.Get(ctx, "x1").Val())
+	require.Equal(t, []interface{}{"v2", "v2"}, rdb.MGet(ctx, "k1", "x1").Val())
+	require.NoError(t, rdb.Set(ctx, "x1", "v3", 0).Err())
+	require.Equal(t, []interface{}{"v2", "v3"}, rdb.MGet(ctx, "k1", "x1").Val())
+	require.NoError(t, rdb.HSet(ctx, "x2", "f1", "1").Err())
+	require.Equal(t, "1", rdb.HGet(ctx, "x2", "f1").Val())
+	require.NoError(t, rdb.HSet(ctx, "x2", "f1", "2").Err())
+	require.Equal(t, "2", rdb.HGet(ctx, "x2", "f1").Val())
+	_, ok := c.opts.Cache.Get(getCacheKey("x1"))
+	require.False(t, ok)
+
+	// Other commands are not cached.
+	require.Equal(t, int64(1), rdb.Exists(ctx, "k1").Val())
+
+	// The cache is disabled until reconnected.
+	srv.disconnect()
+	require.NoError(t, s.Set("k1", "v3"))
+	require.Eventually(t, func() bool { return rdb.Get(ctx, "k1").Val() == "v3" }, time.Second, 10*time.Millisecond)
+	require.NoError(t, s.Set("k1", "v4"))
+	require.Equal(t, "v4", rdb.Get(ctx, "k1").Val())
+	require.Eventually(t, func() bool {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return c.ready
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, "v4", rdb.Get(ctx, "k1").Val())
+	require.NoError(t, s.Set("k1", "v5"))
+	require.Equal(t, "v4", rdb.Get(ctx, "k1").Val())
+}
+
+func TestClientCache_Tracking(t *testing.T) {
+	tracked := miniredis.RunT(t)
+	other := miniredis.RunT(t)
+	srv := newFakeTrackingServer(t)
+	c := newClientCache("trpc.redis.test", ClientCacheOptions{Cache: newMapCache()},
+		&redis.Options{Addr: srv.ln.Addr().String()})
+	var (
+		redirect int64
+		connMu   sync.Mutex
+		conns    []net.Conn
+	)
+	c.newTrackingClient = func(opt *redis.Options) *redis.Client {
+		// miniredis does not support CLIENT TRACKING, whose redirect is recorded instead.
+		opt.Addr = tracked.Addr()
+		onConnect := opt.OnConnect
+		opt.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+			cn.AddHook(trackingHook{redirect: &redirect})
+			return onConnect(ctx, cn)
+		}
+		dialer := opt.Dialer
+		opt.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer(ctx, network, addr)
+			if err == nil {
+				connMu.Lock()
+				conns = append(conns, conn)
+				connMu.Unlock()
+			}
+			return conn, err
+		}
+		return redis.NewClient(opt)
+	}
+	require.NoError(t, c.start())
+	defer c.Close()
+	require.Equal(t, []string{"CLIENT ID", "SUBSCRIBE " + invalidationChannel}, srv.commands())
+	require.Equal(t, int64(7), atomic.LoadInt64(&redirect))
+
+	// The reads are sent by the tracking client.
+	rdb := redis.NewClient(&redis.Options{Addr: other.Addr()})
+	defer rdb.Close()
+	rdb.AddHook(cacheHook{cache: c})
+	ctx := context.Background()
+	require.NoError(t, tracked.Set("k1", "v1"))
+	require.Equal(t, "v1", rdb.Get(ctx, "k1").Val())
+	require.NoError(t, tracked.Set("k1", "v2"))
+	require.Equal(t, "v1", rdb.Get(ctx, "k1").Val())
+	srv.invalidate("k1")
+	require.Eventually(t, func() bool { return rdb.Get(ctx, "k1").Val() == "v2" }, time.Second, 10*time.Millisecond)
+
+	// The values read by a tracking connection are missed once it is closed, since redis drops their invalidations.
+	require.NoError(t, tracked.Set("k1", "v3"))
+	require.Equal(t, "v2", rdb.Get(ctx, "k1").Val())
+	connMu.Lock()
+	require.Len(t, conns, 1)
+	require.NoError(t, conns[0].Close())
+	connMu.Unlock()
+	require.Equal(t, "v3", rdb.Get(ctx, "k1").Val())
+	require.NoError(t, tracked.Set("k1", "v4"))
+	require.Equal(t, "v3", rdb.Get(ctx, "k1").Val())
+	connMu.Lock()
+	require.Len(t, conns, 2)
+	connMu.Unlock()
+
+	// Closing the client closes the cache.
+	require.NoError(t, (&cachedClient{UniversalClient: rdb, cache: c}).Close())
+	require.True(t, c.closed)
+	require.Nil(t, c.tracking)
+}
+
+func TestClientCache_InvalidatedWhileReading(t *testing.T) {
+	cache := newMapCache()
+	c := newClientCache("trpc.redis.test", ClientCacheOptions{Cache: cache}, &redis.Options{})
+	c.ready = true
+	seq := atomic.AddUint64(&c.seq, 1)
+	c.invalidate("k1")
+	c.set("k1", getCacheKey("k1"), &cacheEntry{seq: seq, value: "v1", exists: true})
+	_, ok := cache.Get(getCacheKey("k1"))
+	require.False(t, ok)
+
+	seq = atomic.AddUint64(&c.seq, 1)
+	c.set("k1", getCacheKey("k1"), &cacheEntry{seq: seq, value: "v1", exists: true})
+	e, ok := c.get("k1", getCacheKey("k1"))
+	require.True(t, ok)
+	require.Equal(t, "v1", e.value)
+	c.flush()
+	_, ok = c.get("k1", getCacheKey("k1"))
+	require.False(t, ok)
+}
+
+func TestRESPConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		fmt.Fprint(server, "+OK\r\n-ERR bad\r\n:42\r\n$-1\r\n*-1\r\n*2\r\n$3\r\nfoo\r\n*1\r\n:1\r\n!bad\r\n")
+		server.Close()
+	}()
+	c := newRESPConn(client)
+	for _, want := range []interface{}{"OK", respError("ERR bad"), int64(42), nil, nil,
+		[]interface{}{"foo", []interface{}{int64(1)}}} {
+		reply, err := c.read()
+		require.NoError(t, err)
+		require.Equal(t, want, reply)
+	}
+	_, err := c.read()
+	require.Error(t, err)
+}
+
+func TestNewWithClientCache(t *testing.T) {
+	_, err := NewWithClientCache("trpc.redis.test", ClientCacheOptions{})
+	require.Equal(t, RetParamInvalid, int(errs.Code(err)))
+
+	cacheOpts := ClientCacheOptions{Cache: newMapCache()}
+	_, err = NewWithClientCache("trpc.redis.test", cacheOpts,
+		client.WithTarget("redis://127.0.0.1:6379,127.0.0.1:6380"))
+	require.Equal(t, RetInitFail, int(errs.Code(err)))
+
+	// miniredis does not support CLIENT TRACKING.
+	s := miniredis.RunT(t)
+	_, err = NewWithClientCache("trpc.redis.test", cacheOpts, client.WithTarget("redis://"+s.Addr()))
+	require.Equal(t, RetInitFail, int(errs.Code(err)))
+}
+
+func TestHook_ClientCacheRsp(t *testing.T) {
+	s := miniredis.RunT(t)
+	srv := newFakeTrackingServer(t)
+	c := newClientCache("trpc.redis.test", ClientCacheOptions{Cache: newMapCache(), Prefixes: []string{"k"}},
+		&redis.Options{Addr: srv.ln.Addr().String()})
+	require.NoError(t, c.start())
+	defer c.Close()
+
+	// The hits and misses are filled in the response of the filters.
+	var hitsAndMisses [][2]int
+	filters, err := joinfilters.New("trpc.redis.test", client.WithFilter(
+		func(ctx context.Context, req, rsp interface{}, next filter.ClientHandleFunc) error {
+			err := next(ctx, req, rsp)
+			hitsAndMisses = append(hitsAndMisses, [2]int{rsp.(*Rsp).CacheHits, rsp.(*Rsp).CacheMisses})
+			return err
+		}))
+	require.NoError(t, err)
+	h := &hook{filters: filters, remoteAddr: &net.TCPAddr{}, cache: c,
+		options: &options.Options{QueryOption: &pb.QueryOptions{}}}
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rdb.Close()
+	rdb.AddHook(h)
+	ctx := context.Background()
+	require.NoError(t, s.Set("k1", "v1"))
+	require.Equal(t, "v1", rdb.Get(ctx, "k1").Val())
+	require.Equal(t, "v1", rdb.Get(ctx, "k1").Val())
+	require.Equal(t, []interface{}{"v1", nil, nil}, rdb.MGet(ctx, "k1", "k2", "x1").Val())
+	require.Equal(t, int64(1), rdb.Exists(ctx, "k1").Val())
+	// The key x1 without the prefix is neither hit nor missed.
+	require.Equal(t, [][2]int{{0, 1}, {1, 0}, {1, 1}, {0, 0}}, hitsAndMisses)
+}
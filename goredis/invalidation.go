@@ -0,0 +1,253 @@
+package goredis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// invalidationChannel is the channel of the invalidation messages of CLIENT TRACKING in RESP2.
+const invalidationChannel = "__redis__:invalidate"
+
+const (
+	defaultDialTimeout  = 5 * time.Second
+	healthCheckInterval = 5 * time.Second
+)
+
+// respError is the error reply of redis.
+type respError string
+
+// Error implements error.
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respConn is a RESP2 connection, which receives the invalidation messages of CLIENT TRACKING.
+// go-redis always switches the connections to RESP3, whose invalidation push messages are not
+// supported by go-redis v9.0, so the subscribed connection speaks RESP2 by itself.
+type respConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+	mu   sync.Mutex // guards writes
+	wr   *bufio.Writer
+}
+
+func newRESPConn(conn net.Conn) *respConn {
+	return &respConn{conn: conn, rd: bufio.NewReader(conn), wr: bufio.NewWriter(conn)}
+}
+
+// dialRESP dials the redis of opt, and authenticates the connection.
+// The deadline of the connection is set by the dial timeout for the handshake.
+func dialRESP(ctx context.Context, opt *redis.Options) (*respConn, error) {
+	timeout := opt.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	network := opt.Network
+	if network == "" {
+		network = "tcp"
+	}
+	conn, err := dial(ctx, opt, network, opt.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := newRESPConn(conn)
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	if opt.Password != "" {
+		args := []string{"AUTH", opt.Password}
+		if opt.Username != "" {
+			args = []string{"AUTH", opt.Username, opt.Password}
+		}
+		if _, err := c.do(args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// dial dials the addr by the dialer of opt, or like the default dialer of go-redis.
+func dial(ctx context.Context, opt *redis.Options, network, addr string) (net.Conn, error) {
+	if opt.Dialer != nil {
+		return opt.Dialer(ctx, network, addr)
+	}
+	conn, err := (&net.Dialer{Timeout: opt.DialTimeout, KeepAlive: 5 * time.Minute}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if opt.TLSConfig != nil {
+		conn = tls.Client(conn, opt.TLSConfig)
+	}
+	return conn, nil
+}
+
+// do writes the command and reads its reply.
+func (c *respConn) do(args ...string) (interface{}, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := reply.(respError); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// write writes the command as an array of bulk strings.
+func (c *respConn) write(args ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wr.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		c.wr.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		c.wr.WriteString(arg)
+		c.wr.WriteString("\r\n")
+	}
+	return c.wr.Flush()
+}
+
+// read reads a reply, which is a string, an int64, a respError, nil or a []interface{} of them.
+func (c *respConn) read() (interface{}, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("goredis: invalid reply %q", line)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("goredis: invalid reply %q", line)
+}
+
+// Close closes the connection.
+func (c *respConn) Close() error {
+	return c.conn.Close()
+}
+
+// subscribe connects the invalidation connection, which is subscribed to the invalidation messages
+// and tracks the prefixes in the broadcast mode, and returns its client id.
+func (c *clientCache) subscribe(ctx context.Context) (*respConn, int64, error) {
+	conn, err := dialRESP(ctx, c.redisOpt)
+	if err != nil {
+		return nil, 0, err
+	}
+	id, err := c.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	_ = conn.conn.SetDeadline(time.Time{})
+	return conn, id, nil
+}
+
+// handshake gets the client id of conn, enables the broadcast tracking and subscribes the invalidation channel.
+func (c *clientCache) handshake(conn *respConn) (int64, error) {
+	reply, err := conn.do("CLIENT", "ID")
+	if err != nil {
+		return 0, fmt.Errorf("CLIENT ID: %w", err)
+	}
+	id, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("CLIENT ID: invalid reply %v", reply)
+	}
+	if len(c.opts.Prefixes) > 0 {
+		args := []string{"CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(id, 10), "BCAST"}
+		for _, prefix := range c.opts.Prefixes {
+			args = append(args, "PREFIX", prefix)
+		}
+		if _, err := conn.do(args...); err != nil {
+			return 0, fmt.Errorf("CLIENT TRACKING: %w", err)
+		}
+	}
+	if _, err := conn.do("SUBSCRIBE", invalidationChannel); err != nil {
+		return 0, fmt.Errorf("SUBSCRIBE %s: %w", invalidationChannel, err)
+	}
+	return id, nil
+}
+
+// receive handles the invalidation messages of conn until it fails.
+// The connection is pinged periodically to detect the failures.
+func (c *clientCache) receive(conn *respConn) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := conn.write("PING"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	for {
+		_ = conn.conn.SetReadDeadline(time.Now().Add(3 * healthCheckInterval))
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 || msg[0] != "message" || msg[1] != invalidationChannel {
+			continue // subscription and pong replies
+		}
+		switch keys := msg[2].(type) {
+		case nil:
+			// FLUSHALL or FLUSHDB.
+			c.flush()
+		case []interface{}:
+			for _, key := range keys {
+				if key, ok := key.(string); ok {
+					c.invalidate(key)
+				}
+			}
+		}
+	}
+}
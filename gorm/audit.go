@@ -0,0 +1,565 @@
+package gorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// auditName is the name of the Audit plugin as well as its callbacks and clause.
+const auditName = "trpc:audit"
+
+const (
+	auditBeforeKey  = auditName + ":before"
+	auditRecordsKey = auditName + ":records"
+)
+
+// AuditOperation is the operation of an AuditRecord.
+type AuditOperation string
+
+// The operations of AuditRecord.
+const (
+	AuditCreate AuditOperation = "create"
+	AuditUpdate AuditOperation = "update"
+	AuditDelete AuditOperation = "delete"
+)
+
+// AuditRecord is the audit record of a row changed by Create, Update or Delete.
+type AuditRecord struct {
+	Table      string                 `json:"table"`
+	Operation  AuditOperation         `json:"operation"`
+	PrimaryKey map[string]interface{} `json:"primary_key"`
+	// Changes are the changed columns. Old is nil for Create, and New is nil for Delete.
+	Changes map[string]AuditChange `json:"changes"`
+	// Caller is the caller service in the tRPC message of the context, such as the upstream of a tRPC server.
+	Caller       string            `json:"caller"`
+	CallerMethod string            `json:"caller_method"`
+	Metadata     map[string]string `json:"metadata,omitempty"` // See WithAuditMetadata.
+	Time         time.Time         `json:"time"`
+}
+
+// AuditChange is the old and new values of a column.
+type AuditChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// AuditSink writes the audit records.
+type AuditSink interface {
+	Write(ctx context.Context, records []*AuditRecord) error
+}
+
+// NewLogAuditSink creates an AuditSink writing the records in JSON to the logger,
+// such as log.Get("audit"), or to the default logger if it is nil.
+func NewLogAuditSink(logger log.Logger) AuditSink {
+	return &logAuditSink{logger: logger}
+}
+
+type logAuditSink struct {
+	logger log.Logger
+}
+
+// Write implements AuditSink.
+func (s *logAuditSink) Write(ctx context.Context, records []*AuditRecord) error {
+	for _, record := range records {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if s.logger == nil {
+			log.InfoContextf(ctx, "gorm audit: %s", b)
+		} else {
+			s.logger.Infof("gorm audit: %s", b)
+		}
+	}
+	return nil
+}
+
+// AuditProducer produces messages to Kafka. A kafka.Client can be adapted by AuditProducerFunc,
+// so that this package does not depend on the Kafka client.
+type AuditProducer interface {
+	Produce(ctx context.Context, key, value []byte) error
+}
+
+// AuditProducerFunc adapts a func to AuditProducer, such as the Produce method of a kafka.Client:
+//
+//	producer := kafka.NewClientProxy("trpc.kafka.app.audit")
+//	sink := gormplugin.NewKafkaAuditSink(gormplugin.AuditProducerFunc(
+//		func(ctx context.Context, key, value []byte) error { return producer.Produce(ctx, key, value) }))
+type AuditProducerFunc func(ctx context.Context, key, value []byte) error
+
+// Produce implements AuditProducer.
+func (f AuditProducerFunc) Produce(ctx context.Context, key, value []byte) error {
+	return f(ctx, key, value)
+}
+
+// NewKafkaAuditSink creates an AuditSink producing the records in JSON to Kafka,
+// the key of a message is the table and the primary key, so that the changes of a row are ordered.
+func NewKafkaAuditSink(producer AuditProducer) AuditSink {
+	return &kafkaAuditSink{producer: producer}
+}
+
+type kafkaAuditSink struct {
+	producer AuditProducer
+}
+
+// Write implements AuditSink.
+func (s *kafkaAuditSink) Write(ctx context.Context, records []*AuditRecord) error {
+	for _, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		primaryKey, err := json.Marshal(record.PrimaryKey)
+		if err != nil {
+			return err
+		}
+		key := append([]byte(record.Table+":"), primaryKey...)
+		if err := s.producer.Produce(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuditLog is a row of the table written by the AuditSink created by NewTableAuditSink.
+type AuditLog struct {
+	ID           int64     `gorm:"primaryKey"`
+	TableName    string    `gorm:"size:128;index"`
+	Operation    string    `gorm:"size:16"`
+	PrimaryKey   string    `gorm:"size:256"`
+	Changes      string    `gorm:"type:text"`
+	Caller       string    `gorm:"size:256"`
+	CallerMethod string    `gorm:"size:256"`
+	Metadata     string    `gorm:"type:text"`
+	CreatedAt    time.Time `gorm:"index"`
+}
+
+// NewTableAuditSink creates an AuditSink inserting the records as AuditLog into the table by db,
+// which can be created by db.Table(table).AutoMigrate(&AuditLog{}).
+// The inserts are not audited even if db uses the Audit plugin.
+func NewTableAuditSink(db *gorm.DB, table string) AuditSink {
+	return &tableAuditSink{db: db, table: table}
+}
+
+type tableAuditSink struct {
+	db    *gorm.DB
+	table string
+}
+
+// Write implements AuditSink.
+func (s *tableAuditSink) Write(ctx context.Context, records []*AuditRecord) error {
+	logs := make([]AuditLog, 0, len(records))
+	for _, record := range records {
+		primaryKey, err := json.Marshal(record.PrimaryKey)
+		if err != nil {
+			return err
+		}
+		changes, err := json.Marshal(record.Changes)
+		if err != nil {
+			return err
+		}
+		var metadata []byte
+		if len(record.Metadata) > 0 {
+			if metadata, err = json.Marshal(record.Metadata); err != nil {
+				return err
+			}
+		}
+		logs = append(logs, AuditLog{
+			TableName:    record.Table,
+			Operation:    string(record.Operation),
+			PrimaryKey:   string(primaryKey),
+			Changes:      string(changes),
+			Caller:       record.Caller,
+			CallerMethod: record.CallerMethod,
+			Metadata:     string(metadata),
+			CreatedAt:    record.Time,
+		})
+	}
+	return s.db.WithContext(ctx).Clauses(skipAudit{}).Table(s.table).Create(&logs).Error
+}
+
+// Audit is a GORM plugin which writes an AuditRecord to the AuditSink for each row changed
+// by Create, Update and Delete, with the changed columns and their old and new values.
+// The old values are read by the conditions of Update and Delete before the rows are changed,
+// and the new values of Update are read by the primary keys after the rows are changed,
+// so the statements are executed in the default transaction of GORM unless it is skipped.
+// Note that changing many rows in a statement reads all of them.
+//
+// The records are written after the default transaction of GORM commits, or after the statement
+// if it is in a transaction begun by users, even if the transaction is rolled back later.
+// The failures of writing records are logged, and do not fail the statements.
+// Raw statements by Exec are not audited.
+//
+//	db, err := gormplugin.NewClientProxy("trpc.mysql.app.db")
+//	err = db.Use(gormplugin.NewAudit(gormplugin.NewLogAuditSink(log.Get("audit")),
+//		gormplugin.WithAuditTables("users", "orders")))
+type Audit struct {
+	sink         AuditSink
+	tables       map[string]bool
+	metadataKeys []string
+}
+
+// AuditOption is the option of Audit.
+type AuditOption func(*Audit)
+
+// WithAuditTables sets the tables to audit, all tables are audited by default.
+func WithAuditTables(tables ...string) AuditOption {
+	return func(a *Audit) {
+		a.tables = make(map[string]bool, len(tables))
+		for _, table := range tables {
+			a.tables[table] = true
+		}
+	}
+}
+
+// WithAuditMetadata sets the keys of the metadata in the tRPC message of the context,
+// whose values are recorded in AuditRecord.Metadata, such as the operator.
+func WithAuditMetadata(keys ...string) AuditOption {
+	return func(a *Audit) {
+		a.metadataKeys = keys
+	}
+}
+
+// NewAudit creates an Audit writing the records to the sink.
+func NewAudit(sink AuditSink, opts ...AuditOption) *Audit {
+	a := &Audit{sink: sink}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Name implements gorm.Plugin.
+func (a *Audit) Name() string {
+	return auditName
+}
+
+// Initialize implements gorm.Plugin, it registers the callbacks.
+func (a *Audit) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().After("gorm:create").Register(auditName+":after", a.afterCreate),
+		callbacks.Create().After("*").Register(auditName, a.write),
+		callbacks.Update().Before("gorm:update").Register(auditName+":before", a.before),
+		callbacks.Update().After("gorm:update").Register(auditName+":after", a.afterUpdate),
+		callbacks.Update().After("*").Register(auditName, a.write),
+		callbacks.Delete().Before("gorm:delete").Register(auditName+":before", a.before),
+		callbacks.Delete().After("gorm:delete").Register(auditName+":after", a.afterDelete),
+		callbacks.Delete().After("*").Register(auditName, a.write),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// audited returns whether the statement is audited.
+func (a *Audit) audited(db *gorm.DB) bool {
+	stmt := db.Statement
+	if db.Error != nil || db.DryRun || stmt.Table == "" {
+		return false
+	}
+	if _, ok := stmt.Clauses[auditName]; ok {
+		return false
+	}
+	return a.tables == nil || a.tables[stmt.Table]
+}
+
+// before reads the rows to be changed by Update and Delete.
+func (a *Audit) before(db *gorm.DB) {
+	if !a.audited(db) {
+		return
+	}
+	stmt := db.Statement
+	tx := a.session(db)
+	if where, ok := stmt.Clauses["WHERE"].Expression.(clause.Where); ok {
+		tx.Statement.AddClause(where)
+	}
+	// Update and Delete take the conditions from the primary keys of the model as well.
+	if stmt.Schema != nil && len(stmt.Schema.PrimaryFields) > 0 && stmt.ReflectValue.IsValid() {
+		switch stmt.ReflectValue.Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Array:
+			_, pks := schema.GetIdentityFieldValuesMap(stmt.Context, stmt.ReflectValue, stmt.Schema.PrimaryFields)
+			column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, pks)
+			if len(values) > 0 {
+				tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+			}
+		}
+	}
+	var rows []map[string]interface{}
+	if err := tx.Find(&rows).Error; err != nil {
+		db.AddError(fmt.Errorf("gorm audit: read rows of %s error: %w", stmt.Table, err))
+		return
+	}
+	db.InstanceSet(auditBeforeKey, rows)
+}
+
+// session returns a new gorm.DB of the table on the connection pool of the statement,
+// whose queries are neither cached nor audited.
+func (a *Audit) session(db *gorm.DB) *gorm.DB {
+	tx := db.Session(&gorm.Session{NewDB: true}).Clauses(NoCache, skipAudit{})
+	if db.Statement.Unscoped {
+		tx = tx.Unscoped()
+	}
+	if db.Statement.Model != nil {
+		tx = tx.Model(db.Statement.Model)
+	}
+	return tx.Table(db.Statement.Table)
+}
+
+// afterCreate builds the records of the created rows.
+func (a *Audit) afterCreate(db *gorm.DB) {
+	if !a.audited(db) {
+		return
+	}
+	stmt := db.Statement
+	var records []*AuditRecord
+	add := func(values map[string]interface{}) {
+		record := a.newRecord(stmt, AuditCreate, values)
+		for column, value := range values {
+			record.Changes[column] = AuditChange{New: value}
+		}
+		records = append(records, record)
+	}
+	rv := reflect.Indirect(stmt.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Struct:
+		add(a.structValues(stmt, rv))
+	case reflect.Map:
+		add(mapValues(rv))
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			elem := reflect.Indirect(rv.Index(i))
+			if elem.Kind() == reflect.Map {
+				add(mapValues(elem))
+			} else if elem.Kind() == reflect.Struct {
+				add(a.structValues(stmt, elem))
+			}
+		}
+	}
+	db.InstanceSet(auditRecordsKey, records)
+}
+
+// structValues returns the values of the columns of a model.
+func (a *Audit) structValues(stmt *gorm.Statement, rv reflect.Value) map[string]interface{} {
+	values := make(map[string]interface{})
+	if stmt.Schema == nil {
+		return values
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		value, _ := field.ValueOf(stmt.Context, rv)
+		values[field.DBName] = auditValue(value)
+	}
+	return values
+}
+
+func mapValues(rv reflect.Value) map[string]interface{} {
+	values := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		values[fmt.Sprint(iter.Key().Interface())] = auditValue(iter.Value().Interface())
+	}
+	return values
+}
+
+// afterUpdate builds the records of the updated rows by comparing the rows before and after the update.
+func (a *Audit) afterUpdate(db *gorm.DB) {
+	olds, ok := a.beforeRows(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+	news := make(map[string]map[string]interface{}, len(olds))
+	if pks := a.primaryKeys(stmt); len(pks) > 0 && len(olds) > 0 {
+		values := make([][]interface{}, 0, len(olds))
+		for _, row := range olds {
+			value := make([]interface{}, 0, len(pks))
+			for _, pk := range pks {
+				value = append(value, row[pk])
+			}
+			values = append(values, value)
+		}
+		column, queryValues := schema.ToQueryValues(stmt.Table, pks, values)
+		var rows []map[string]interface{}
+		if err := a.session(db).Unscoped().Where(clause.IN{Column: column, Values: queryValues}).
+			Find(&rows).Error; err != nil {
+			db.AddError(fmt.Errorf("gorm audit: read rows of %s error: %w", stmt.Table, err))
+			return
+		}
+		for _, row := range rows {
+			news[rowKey(row, pks)] = row
+		}
+	}
+	var records []*AuditRecord
+	for _, old := range olds {
+		row, ok := news[rowKey(old, a.primaryKeys(stmt))]
+		if !ok {
+			row = assignedValues(stmt)
+		}
+		record := a.newRecord(stmt, AuditUpdate, old)
+		for column, value := range row {
+			if oldValue := auditValue(old[column]); !reflect.DeepEqual(oldValue, auditValue(value)) {
+				record.Changes[column] = AuditChange{Old: oldValue, New: auditValue(value)}
+			}
+		}
+		if len(record.Changes) > 0 {
+			records = append(records, record)
+		}
+	}
+	db.InstanceSet(auditRecordsKey, records)
+}
+
+// assignedValues returns the values assigned by the SET clause, which is used for the tables without primary keys.
+// The values of expressions are the SQL of them.
+func assignedValues(stmt *gorm.Statement) map[string]interface{} {
+	values := make(map[string]interface{})
+	c, ok := stmt.Clauses["SET"]
+	if !ok {
+		return values
+	}
+	set, ok := c.Expression.(clause.Set)
+	if !ok {
+		return values
+	}
+	for _, assignment := range set {
+		if expr, ok := assignment.Value.(clause.Expr); ok {
+			values[assignment.Column.Name] = expr.SQL
+		} else {
+			values[assignment.Column.Name] = assignment.Value
+		}
+	}
+	return values
+}
+
+// afterDelete builds the records of the deleted rows.
+func (a *Audit) afterDelete(db *gorm.DB) {
+	olds, ok := a.beforeRows(db)
+	if !ok {
+		return
+	}
+	records := make([]*AuditRecord, 0, len(olds))
+	for _, old := range olds {
+		record := a.newRecord(db.Statement, AuditDelete, old)
+		for column, value := range old {
+			record.Changes[column] = AuditChange{Old: auditValue(value)}
+		}
+		records = append(records, record)
+	}
+	db.InstanceSet(auditRecordsKey, records)
+}
+
+func (a *Audit) beforeRows(db *gorm.DB) ([]map[string]interface{}, bool) {
+	if !a.audited(db) {
+		return nil, false
+	}
+	v, ok := db.InstanceGet(auditBeforeKey)
+	if !ok {
+		return nil, false
+	}
+	rows, ok := v.([]map[string]interface{})
+	return rows, ok
+}
+
+func (a *Audit) primaryKeys(stmt *gorm.Statement) []string {
+	if stmt.Schema == nil {
+		return nil
+	}
+	return stmt.Schema.PrimaryFieldDBNames
+}
+
+// rowKey returns the key of the row by its primary keys.
+func rowKey(row map[string]interface{}, pks []string) string {
+	values := make([]interface{}, 0, len(pks))
+	for _, pk := range pks {
+		values = append(values, auditValue(row[pk]))
+	}
+	return fmt.Sprint(values...)
+}
+
+// newRecord creates a record of the row with the caller in the context.
+func (a *Audit) newRecord(stmt *gorm.Statement, op AuditOperation, row map[string]interface{}) *AuditRecord {
+	record := &AuditRecord{
+		Table:      stmt.Table,
+		Operation:  op,
+		PrimaryKey: make(map[string]interface{}),
+		Changes:    make(map[string]AuditChange),
+		Time:       time.Now(),
+	}
+	for _, pk := range a.primaryKeys(stmt) {
+		record.PrimaryKey[pk] = auditValue(row[pk])
+	}
+	msg := codec.Message(stmt.Context)
+	record.Caller = msg.CallerServiceName()
+	record.CallerMethod = msg.CallerMethod()
+	if len(a.metadataKeys) > 0 {
+		record.Metadata = make(map[string]string, len(a.metadataKeys))
+		md := msg.ServerMetaData()
+		for _, key := range a.metadataKeys {
+			if value, ok := md[key]; ok {
+				record.Metadata[key] = string(value)
+			}
+		}
+	}
+	return record
+}
+
+// auditValue converts the value to the one which is comparable and readable in JSON.
+func auditValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case driver.Valuer:
+		// Such as sql.NullString and gorm.DeletedAt.
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		if value, err := v.Value(); err == nil {
+			return auditValue(value)
+		}
+	}
+	return value
+}
+
+// write writes the records after the statement, and the default transaction of GORM if it is not skipped.
+func (a *Audit) write(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	v, ok := db.InstanceGet(auditRecordsKey)
+	if !ok {
+		return
+	}
+	records, ok := v.([]*AuditRecord)
+	if !ok || len(records) == 0 {
+		return
+	}
+	if err := a.sink.Write(db.Statement.Context, records); err != nil {
+		log.ErrorContextf(db.Statement.Context, "gorm audit: write %d records of %s error: %v",
+			len(records), db.Statement.Table, err)
+	}
+}
+
+// skipAudit is a clause that skips the Audit plugin.
+type skipAudit struct{}
+
+// ModifyStatement implements gorm.StatementModifier.
+func (skipAudit) ModifyStatement(stmt *gorm.Statement) {
+	stmt.Clauses[auditName] = clause.Clause{Name: "", Expression: skipAudit{}}
+}
+
+// Build implements clause.Expression, it builds nothing.
+func (skipAudit) Build(clause.Builder) {}
@@ -0,0 +1,210 @@
+package gorm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+type auditedUser struct {
+	ID        int
+	Name      string
+	Age       int
+	DeletedAt gorm.DeletedAt
+}
+
+type auditedTag struct {
+	ID   int
+	Name string
+}
+
+// memoryAuditSink keeps the records in memory.
+type memoryAuditSink struct {
+	sync.Mutex
+	records []*AuditRecord
+}
+
+func (s *memoryAuditSink) Write(_ context.Context, records []*AuditRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *memoryAuditSink) take() []*AuditRecord {
+	s.Lock()
+	defer s.Unlock()
+	records := s.records
+	s.records = nil
+	return records
+}
+
+func newAuditTestDB(t *testing.T, sink func(db *gorm.DB) AuditSink, opts ...AuditOption) *gorm.DB {
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	t.Cleanup(func() {
+		transport.RegisterClientTransport("gorm", defaultClientTransport)
+	})
+	db, err := NewClientProxy("trpc.sqlite.test.audit",
+		client.WithTarget("dsn://file:"+filepath.Join(t.TempDir(), "gorm.db")))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&auditedUser{}, &auditedTag{}))
+	require.NoError(t, db.Use(NewAudit(sink(db), opts...)))
+	return db
+}
+
+func TestAudit(t *testing.T) {
+	sink := &memoryAuditSink{}
+	db := newAuditTestDB(t, func(*gorm.DB) AuditSink { return sink },
+		WithAuditTables("audited_users"), WithAuditMetadata("operator"))
+	ctx, msg := codec.WithNewMessage(context.Background())
+	msg.WithCallerServiceName("trpc.app.caller.service")
+	msg.WithCallerMethod("/trpc.app.caller.service/Method")
+	msg.WithServerMetaData(codec.MetaData{"operator": []byte("alice")})
+	db = db.WithContext(ctx)
+
+	// Create.
+	require.NoError(t, db.Create(&auditedUser{Name: "a", Age: 1}).Error)
+	require.NoError(t, db.Create(&[]auditedUser{{Name: "b", Age: 2}, {Name: "c", Age: 2}}).Error)
+	records := sink.take()
+	require.Len(t, records, 3)
+	record := records[0]
+	require.Equal(t, "audited_users", record.Table)
+	require.Equal(t, AuditCreate, record.Operation)
+	require.Equal(t, map[string]interface{}{"id": 1}, record.PrimaryKey)
+	require.Equal(t, map[string]AuditChange{
+		"id":         {New: 1},
+		"name":       {New: "a"},
+		"age":        {New: 1},
+		"deleted_at": {},
+	}, record.Changes)
+	require.Equal(t, "trpc.app.caller.service", record.Caller)
+	require.Equal(t, "/trpc.app.caller.service/Method", record.CallerMethod)
+	require.Equal(t, map[string]string{"operator": "alice"}, record.Metadata)
+	require.False(t, record.Time.IsZero())
+	require.Equal(t, map[string]interface{}{"id": 3}, records[2].PrimaryKey)
+	// Failed statements are not audited.
+	require.Error(t, db.Create(&auditedUser{ID: 1}).Error)
+	require.Empty(t, sink.take())
+
+	// Update.
+	require.NoError(t, db.Model(&auditedUser{ID: 1}).Update("name", "aa").Error)
+	records = sink.take()
+	require.Len(t, records, 1)
+	require.Equal(t, AuditUpdate, records[0].Operation)
+	require.Equal(t, map[string]interface{}{"id": 1}, records[0].PrimaryKey)
+	require.Equal(t, map[string]AuditChange{"name": {Old: "a", New: "aa"}}, records[0].Changes)
+	require.NoError(t, db.Model(&auditedUser{}).Where("age = ?", 2).
+		Updates(map[string]interface{}{"age": gorm.Expr("age + 1"), "name": "b"}).Error)
+	records = sink.take()
+	require.Len(t, records, 2)
+	require.Equal(t, map[string]AuditChange{"age": {Old: 2, New: 3}}, records[0].Changes)
+	require.Equal(t, map[string]AuditChange{
+		"age":  {Old: 2, New: 3},
+		"name": {Old: "c", New: "b"},
+	}, records[1].Changes)
+	// Rows not changed are not audited.
+	require.NoError(t, db.Model(&auditedUser{ID: 1}).Update("name", "aa").Error)
+	require.NoError(t, db.Model(&auditedUser{}).Where("age = ?", 100).Update("name", "x").Error)
+	require.Empty(t, sink.take())
+	// Tables without primary keys use the assigned values.
+	require.NoError(t, db.Table("audited_users").Where("id = ?", 1).Update("age", 10).Error)
+	records = sink.take()
+	require.Len(t, records, 1)
+	require.Equal(t, map[string]AuditChange{"age": {Old: int64(1), New: 10}}, records[0].Changes)
+	require.Empty(t, records[0].PrimaryKey)
+
+	// Delete, including soft delete.
+	require.NoError(t, db.Delete(&auditedUser{}, 1).Error)
+	records = sink.take()
+	require.Len(t, records, 1)
+	require.Equal(t, AuditDelete, records[0].Operation)
+	require.Equal(t, map[string]interface{}{"id": 1}, records[0].PrimaryKey)
+	require.Equal(t, AuditChange{Old: "aa"}, records[0].Changes["name"])
+	require.NoError(t, db.Delete(&auditedUser{}, 1).Error)
+	require.Empty(t, sink.take())
+	require.NoError(t, db.Unscoped().Delete(&auditedUser{}, 1).Error)
+	require.Len(t, sink.take(), 1)
+	require.NoError(t, db.Where("age = ?", 3).Delete(&auditedUser{}).Error)
+	require.Len(t, sink.take(), 2)
+
+	// Tables not in WithAuditTables are not audited.
+	require.NoError(t, db.Create(&auditedTag{Name: "t"}).Error)
+	require.NoError(t, db.Delete(&auditedTag{ID: 1}).Error)
+	require.Empty(t, sink.take())
+
+	// Records of a transaction are written after each statement.
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&auditedUser{Name: "d"}).Error; err != nil {
+			return err
+		}
+		require.Len(t, sink.take(), 1)
+		return tx.Model(&auditedUser{}).Where("name = ?", "d").Update("age", 4).Error
+	}))
+	records = sink.take()
+	require.Len(t, records, 1)
+	require.Equal(t, map[string]AuditChange{"age": {Old: 0, New: 4}}, records[0].Changes)
+}
+
+func TestAudit_TableSink(t *testing.T) {
+	db := newAuditTestDB(t, func(db *gorm.DB) AuditSink {
+		require.NoError(t, db.Table("audit_logs").AutoMigrate(&AuditLog{}))
+		return NewTableAuditSink(db, "audit_logs")
+	})
+	require.NoError(t, db.Create(&auditedTag{Name: "t"}).Error)
+	require.NoError(t, db.Model(&auditedTag{ID: 1}).Update("name", "tt").Error)
+
+	var logs []AuditLog
+	require.NoError(t, db.Table("audit_logs").Order("id").Find(&logs).Error)
+	require.Len(t, logs, 2)
+	require.Equal(t, "audited_tags", logs[0].TableName)
+	require.Equal(t, "create", logs[0].Operation)
+	require.Equal(t, `{"id":1}`, logs[0].PrimaryKey)
+	require.Equal(t, "update", logs[1].Operation)
+	require.JSONEq(t, `{"name":{"old":"t","new":"tt"}}`, logs[1].Changes)
+}
+
+type fakeAuditProducer struct {
+	keys, values []string
+	err          error
+}
+
+func (p *fakeAuditProducer) Produce(_ context.Context, key, value []byte) error {
+	p.keys = append(p.keys, string(key))
+	p.values = append(p.values, string(value))
+	return p.err
+}
+
+func TestAudit_Sinks(t *testing.T) {
+	records := []*AuditRecord{{
+		Table:      "users",
+		Operation:  AuditDelete,
+		PrimaryKey: map[string]interface{}{"id": 1},
+		Changes:    map[string]AuditChange{"name": {Old: "a"}},
+	}}
+	producer := &fakeAuditProducer{}
+	require.NoError(t, NewKafkaAuditSink(producer).Write(context.Background(), records))
+	require.Equal(t, []string{`users:{"id":1}`}, producer.keys)
+	var record AuditRecord
+	require.NoError(t, json.Unmarshal([]byte(producer.values[0]), &record))
+	require.Equal(t, AuditDelete, record.Operation)
+	require.Equal(t, map[string]AuditChange{"name": {Old: "a"}}, record.Changes)
+	producer.err = errors.New("produce error")
+	require.Error(t, NewKafkaAuditSink(AuditProducerFunc(producer.Produce)).Write(context.Background(), records))
+
+	require.NoError(t, NewLogAuditSink(nil).Write(context.Background(), records))
+
+	// Failures of the sink do not fail the statements.
+	db := newAuditTestDB(t, func(*gorm.DB) AuditSink { return NewKafkaAuditSink(producer) })
+	require.NoError(t, db.Create(&auditedTag{Name: "t"}).Error)
+	require.Len(t, producer.keys, 3)
+}
@@ -0,0 +1,354 @@
+package gorm
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// cacheName is the name of the Cache plugin as well as its callbacks and clause.
+const cacheName = "trpc:cache"
+
+const (
+	defaultCachePrefix = "gorm:cache:"
+	// generationTTL is the TTL of the generations of the tables. A table whose generation is lost gets a new one,
+	// which invalidates its cached results as well.
+	generationTTL = 24 * time.Hour
+)
+
+// CacheStore is the backend of Cache.
+type CacheStore interface {
+	// Get returns the value of the key, ok is false if the key does not exist or has expired.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set sets the value of the key with the TTL.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// LocalCache is the in-process cache used by NewLocalCacheStore, which is satisfied by localcache.Cache.
+type LocalCache interface {
+	Get(key string) (interface{}, bool)
+	// SetWithExpire sets the value of the key with the TTL in seconds.
+	SetWithExpire(key string, value interface{}, ttl int64) bool
+}
+
+// NewLocalCacheStore creates a CacheStore backed by a LocalCache, such as localcache.New().
+// The TTL is rounded up to seconds. Note that the results cached in a process are not invalidated
+// by the writes of other processes, so the TTL should be short.
+func NewLocalCacheStore(c LocalCache) CacheStore {
+	return &localCacheStore{cache: c}
+}
+
+type localCacheStore struct {
+	cache LocalCache
+}
+
+// Get implements CacheStore.
+func (s *localCacheStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	v, ok := s.cache.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	value, ok := v.([]byte)
+	return value, ok, nil
+}
+
+// Set implements CacheStore.
+func (s *localCacheStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if !s.cache.SetWithExpire(key, value, seconds) {
+		return fmt.Errorf("gorm cache: set %s to local cache failed", key)
+	}
+	return nil
+}
+
+// Cache is a GORM plugin which caches the results of queries, such as First, Find and Count, in a CacheStore.
+// The results are keyed by the table, the SQL and its arguments, and are invalidated by the writes
+// to the same table through GORM, including Create, Update, Delete and Exec of INSERT, UPDATE, DELETE, etc.
+// Only the tables with a TTL are cached, and the queries in a transaction or with the NoCache clause
+// are not cached. The results are encoded by encoding/json, so the models must survive a JSON round trip.
+//
+// Invalidation bumps the generation of the table, which is a part of the keys, rather than deleting the keys.
+// A query joining other tables is not invalidated by the writes to them, and a write in a transaction
+// invalidates the table before the transaction commits, so the TTL bounds how stale a result can be.
+//
+//	db, err := gormplugin.NewClientProxy("trpc.mysql.app.db")
+//	err = db.Use(gormplugin.NewCache(gormplugin.NewLocalCacheStore(localcache.New()),
+//		gormplugin.WithModelCacheTTL(&Config{}, 5*time.Second)))
+//	db.Find(&configs)                           // Cached for 5 seconds.
+//	db.Clauses(gormplugin.NoCache).Find(&configs) // Always reads from the database.
+type Cache struct {
+	store      CacheStore
+	prefix     string
+	ttl        time.Duration
+	models     map[interface{}]time.Duration
+	tables     map[string]time.Duration
+	generation uint64
+}
+
+// CacheOption is the option of Cache.
+type CacheOption func(*Cache)
+
+// WithCacheTTL sets the TTL of the tables which are not set by WithModelCacheTTL.
+// It is zero by default, which means only the tables set by WithModelCacheTTL are cached.
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+// WithModelCacheTTL sets the TTL of the table of the model, which is a model like &User{} or a table name.
+// A TTL that is not positive disables caching of the table.
+func WithModelCacheTTL(model interface{}, ttl time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.models[model] = ttl
+	}
+}
+
+// WithCachePrefix sets the prefix of the keys in the CacheStore, which is "gorm:cache:" by default.
+func WithCachePrefix(prefix string) CacheOption {
+	return func(c *Cache) {
+		c.prefix = prefix
+	}
+}
+
+// NewCache creates a Cache with the store.
+func NewCache(store CacheStore, opts ...CacheOption) *Cache {
+	c := &Cache{
+		store:  store,
+		prefix: defaultCachePrefix,
+		models: make(map[interface{}]time.Duration),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Name implements gorm.Plugin.
+func (c *Cache) Name() string {
+	return cacheName
+}
+
+// Initialize implements gorm.Plugin, it resolves the tables of the models,
+// replaces the query callback and registers the callbacks invalidating the tables.
+func (c *Cache) Initialize(db *gorm.DB) error {
+	c.tables = make(map[string]time.Duration, len(c.models))
+	for model, ttl := range c.models {
+		table, ok := model.(string)
+		if !ok {
+			stmt := &gorm.Statement{DB: db}
+			if err := stmt.Parse(model); err != nil {
+				return fmt.Errorf("gorm cache: parse model %T error: %w", model, err)
+			}
+			table = stmt.Schema.Table
+		}
+		c.tables[table] = ttl
+	}
+
+	// The invalidating callbacks run last, so that the default transaction of GORM has been committed.
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Query().Replace("gorm:query", c.query),
+		callbacks.Create().After("*").Register(cacheName, c.invalidate),
+		callbacks.Update().After("*").Register(cacheName, c.invalidate),
+		callbacks.Delete().After("*").Register(cacheName, c.invalidate),
+		callbacks.Raw().After("*").Register(cacheName, c.invalidateRaw),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheEntry is the cached result of a query.
+type cacheEntry struct {
+	RowsAffected int64           `json:"rows_affected"`
+	Dest         json.RawMessage `json:"dest"`
+}
+
+// query replaces gorm:query, it reads the result from the store if it is cached,
+// otherwise queries the database and caches the result.
+func (c *Cache) query(db *gorm.DB) {
+	ttl, ok := c.cacheable(db)
+	if !ok {
+		callbacks.Query(db)
+		return
+	}
+	callbacks.BuildQuerySQL(db)
+	if db.Error != nil {
+		return
+	}
+	stmt := db.Statement
+	key, err := c.key(stmt)
+	if err != nil {
+		log.WarnContextf(stmt.Context, "gorm cache: get generation of %s error: %v", stmt.Table, err)
+		callbacks.Query(db)
+		return
+	}
+	if c.load(db, key) {
+		return
+	}
+	callbacks.Query(db)
+	if db.Error != nil {
+		return
+	}
+	dest, err := json.Marshal(stmt.Dest)
+	if err == nil {
+		var value []byte
+		if value, err = json.Marshal(cacheEntry{RowsAffected: db.RowsAffected, Dest: dest}); err == nil {
+			err = c.store.Set(stmt.Context, key, value, ttl)
+		}
+	}
+	if err != nil {
+		log.WarnContextf(stmt.Context, "gorm cache: set %s error: %v", key, err)
+	}
+}
+
+// cacheable returns the TTL of the query, and whether the query can be cached.
+func (c *Cache) cacheable(db *gorm.DB) (time.Duration, bool) {
+	stmt := db.Statement
+	if db.Error != nil || db.DryRun || stmt.Table == "" || stmt.Dest == nil {
+		return 0, false
+	}
+	if _, ok := stmt.Clauses[cacheName]; ok {
+		return 0, false
+	}
+	if _, locking := stmt.Clauses["FOR"]; locking {
+		return 0, false
+	}
+	if _, inTx := stmt.ConnPool.(gorm.TxCommitter); inTx {
+		return 0, false
+	}
+	ttl, ok := c.tables[stmt.Table]
+	if !ok {
+		ttl = c.ttl
+	}
+	return ttl, ttl > 0
+}
+
+// load reads the cached result into the destination, it returns false if the result is not cached.
+func (c *Cache) load(db *gorm.DB, key string) bool {
+	stmt := db.Statement
+	value, ok, err := c.store.Get(stmt.Context, key)
+	if err != nil {
+		log.WarnContextf(stmt.Context, "gorm cache: get %s error: %v", key, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		log.WarnContextf(stmt.Context, "gorm cache: decode %s error: %v", key, err)
+		return false
+	}
+	if err := json.Unmarshal(entry.Dest, stmt.Dest); err != nil {
+		log.WarnContextf(stmt.Context, "gorm cache: decode %s error: %v", key, err)
+		return false
+	}
+	db.RowsAffected = entry.RowsAffected
+	if db.RowsAffected == 0 && stmt.RaiseErrorOnNotFound {
+		db.AddError(gorm.ErrRecordNotFound)
+	}
+	return true
+}
+
+// key returns the key of the query, which consists of the table, its generation and the hash of the SQL and vars.
+func (c *Cache) key(stmt *gorm.Statement) (string, error) {
+	generation, err := c.getGeneration(stmt.Context, stmt.Table)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	h.Write([]byte(stmt.SQL.String()))
+	for _, v := range stmt.Vars {
+		if valuer, ok := v.(driver.Valuer); ok {
+			if value, err := valuer.Value(); err == nil {
+				v = value
+			}
+		}
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			v = rv.Elem().Interface()
+		}
+		fmt.Fprintf(h, "\x00%T:%v", v, v)
+	}
+	return c.prefix + stmt.Table + ":" + generation + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// getGeneration returns the generation of the table, a new one is created if it does not exist.
+func (c *Cache) getGeneration(ctx context.Context, table string) (string, error) {
+	value, ok, err := c.store.Get(ctx, c.generationKey(table))
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return string(value), nil
+	}
+	return c.newGeneration(ctx, table)
+}
+
+// newGeneration sets a new generation of the table, which has never been used, so the cached results are invalidated.
+func (c *Cache) newGeneration(ctx context.Context, table string) (string, error) {
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36) + "." +
+		strconv.FormatUint(atomic.AddUint64(&c.generation, 1), 36)
+	return generation, c.store.Set(ctx, c.generationKey(table), []byte(generation), generationTTL)
+}
+
+func (c *Cache) generationKey(table string) string {
+	return c.prefix + "generation:" + table
+}
+
+// invalidate invalidates the table written by Create, Update and Delete.
+func (c *Cache) invalidate(db *gorm.DB) {
+	if db.Error == nil {
+		c.invalidateTable(db.Statement.Context, db.Statement.Table)
+	}
+}
+
+// invalidateRaw invalidates the table written by a raw statement.
+func (c *Cache) invalidateRaw(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	operation, table := parseSQL(db.Statement.SQL.String())
+	switch operation {
+	case "INSERT", "REPLACE", "UPDATE", "DELETE", "TRUNCATE", "ALTER", "DROP":
+		c.invalidateTable(db.Statement.Context, table)
+	}
+}
+
+func (c *Cache) invalidateTable(ctx context.Context, table string) {
+	if table == "" {
+		return
+	}
+	if _, err := c.newGeneration(ctx, table); err != nil {
+		log.ErrorContextf(ctx, "gorm cache: invalidate %s error: %v", table, err)
+	}
+}
+
+// CacheOperation is a clause that changes how a query is cached.
+type CacheOperation string
+
+// NoCache makes the query read from the database and not cache the result.
+const NoCache CacheOperation = "no_cache"
+
+// ModifyStatement implements gorm.StatementModifier.
+func (op CacheOperation) ModifyStatement(stmt *gorm.Statement) {
+	stmt.Clauses[cacheName] = clause.Clause{Name: "", Expression: op}
+}
+
+// Build implements clause.Expression, it builds nothing.
+func (op CacheOperation) Build(clause.Builder) {}
@@ -0,0 +1,40 @@
+// Package redisstore provides the redis backend of the gorm Cache plugin, which is shared by all processes.
+// It is a separate module, so that the gorm plugin does not depend on go-redis:
+//
+//	err = db.Use(tgorm.NewCache(redisstore.New(rdb), tgorm.WithCacheTTL(time.Minute)))
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	tgorm "trpc.group/trpc-go/trpc-database/gorm"
+)
+
+// New creates a CacheStore backed by redis, which is shared by all processes.
+func New(c redis.UniversalClient) tgorm.CacheStore {
+	return &store{client: c}
+}
+
+type store struct {
+	client redis.UniversalClient
+}
+
+// Get implements tgorm.CacheStore.
+func (s *store) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set implements tgorm.CacheStore.
+func (s *store) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
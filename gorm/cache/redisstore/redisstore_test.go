@@ -0,0 +1,34 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	s := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rdb.Close()
+	store := New(rdb)
+	ctx := context.Background()
+
+	_, ok, err := store.Get(ctx, "test:a")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, store.Set(ctx, "test:a", []byte("1"), time.Minute))
+	value, ok, err := store.Get(ctx, "test:a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("1"), value)
+	require.Equal(t, time.Minute, s.TTL("test:a"))
+
+	s.Close()
+	_, _, err = store.Get(ctx, "test:a")
+	require.Error(t, err)
+	require.Error(t, store.Set(ctx, "test:a", []byte("1"), time.Minute))
+}
@@ -0,0 +1,224 @@
+package gorm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+type cachedConfig struct {
+	ID    int
+	Key   string
+	Value string
+}
+
+type cachedLog struct {
+	ID      int
+	Message string
+}
+
+// fakeLocalCache is a LocalCache ignoring the TTL.
+type fakeLocalCache struct {
+	sync.Mutex
+	values map[string]interface{}
+	ttls   map[string]int64
+}
+
+func newFakeLocalCache() *fakeLocalCache {
+	return &fakeLocalCache{values: make(map[string]interface{}), ttls: make(map[string]int64)}
+}
+
+func (c *fakeLocalCache) Get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.values[key]
+	return v, ok
+}
+
+func (c *fakeLocalCache) SetWithExpire(key string, value interface{}, ttl int64) bool {
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] = value
+	c.ttls[key] = ttl
+	return true
+}
+
+// newCacheTestDB creates a gorm.DB using Cache, and returns a gorm.DB of the same database without Cache,
+// as well as the target of the database.
+func newCacheTestDB(t *testing.T, store CacheStore, opts ...CacheOption) (*gorm.DB, *gorm.DB, client.Option) {
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	t.Cleanup(func() {
+		transport.RegisterClientTransport("gorm", defaultClientTransport)
+	})
+	target := client.WithTarget("dsn://file:" + filepath.Join(t.TempDir(), "gorm.db"))
+	raw, err := NewClientProxy("trpc.sqlite.test.cache", target)
+	require.NoError(t, err)
+	require.NoError(t, raw.AutoMigrate(&cachedConfig{}, &cachedLog{}))
+	db, err := NewClientProxy("trpc.sqlite.test.cache", target)
+	require.NoError(t, err)
+	require.NoError(t, db.Use(NewCache(store, opts...)))
+	return db, raw, target
+}
+
+func TestCache(t *testing.T) {
+	local := newFakeLocalCache()
+	db, raw, _ := newCacheTestDB(t, NewLocalCacheStore(local),
+		WithModelCacheTTL(&cachedConfig{}, 1500*time.Millisecond))
+	require.NoError(t, db.Create(&cachedConfig{Key: "a", Value: "1"}).Error)
+
+	var configs []cachedConfig
+	require.NoError(t, db.Find(&configs).Error)
+	require.Equal(t, []cachedConfig{{ID: 1, Key: "a", Value: "1"}}, configs)
+	// Changes not through the gorm.DB are not seen until the result expires.
+	require.NoError(t, raw.Model(&cachedConfig{}).Where("id = ?", 1).Update("value", "2").Error)
+	configs = nil
+	require.NoError(t, db.Find(&configs).Error)
+	require.Equal(t, []cachedConfig{{ID: 1, Key: "a", Value: "1"}}, configs)
+	var n int64
+	require.NoError(t, db.Model(&cachedConfig{}).Count(&n).Error)
+	require.Equal(t, int64(1), n)
+	require.NoError(t, db.Model(&cachedConfig{}).Count(&n).Error)
+	require.Equal(t, int64(1), n)
+	var config cachedConfig
+	require.NoError(t, db.First(&config, "key = ?", "a").Error)
+	require.Equal(t, "2", config.Value)
+	require.NoError(t, raw.Model(&cachedConfig{}).Where("id = ?", 1).Update("value", "3").Error)
+	config = cachedConfig{}
+	require.NoError(t, db.First(&config, "key = ?", "a").Error)
+	require.Equal(t, "2", config.Value)
+	// Not found is cached too.
+	require.ErrorIs(t, db.First(&config, "key = ?", "b").Error, gorm.ErrRecordNotFound)
+	require.NoError(t, raw.Create(&cachedConfig{Key: "b"}).Error)
+	require.ErrorIs(t, db.First(&config, "key = ?", "b").Error, gorm.ErrRecordNotFound)
+	// NoCache reads from the database.
+	config = cachedConfig{}
+	require.NoError(t, db.Clauses(NoCache).First(&config, "key = ?", "b").Error)
+	require.Equal(t, "b", config.Key)
+	for key, ttl := range local.ttls {
+		if key != defaultCachePrefix+"generation:cached_configs" {
+			require.Equal(t, int64(2), ttl, key)
+		}
+	}
+
+	// Writes through the gorm.DB invalidate the table.
+	require.NoError(t, db.Model(&cachedConfig{}).Where("id = ?", 1).Update("value", "4").Error)
+	require.NoError(t, db.Find(&configs).Error)
+	require.Equal(t, []cachedConfig{{ID: 1, Key: "a", Value: "4"}, {ID: 2, Key: "b"}}, configs)
+	require.NoError(t, raw.Delete(&cachedConfig{ID: 2}).Error)
+	require.NoError(t, db.Exec("UPDATE cached_configs SET value = ? WHERE id = ?", "5", 1).Error)
+	require.NoError(t, db.Find(&configs).Error)
+	require.Equal(t, []cachedConfig{{ID: 1, Key: "a", Value: "5"}}, configs)
+	require.NoError(t, db.Delete(&cachedConfig{ID: 1}).Error)
+	require.NoError(t, db.Find(&configs).Error)
+	require.Empty(t, configs)
+
+	// Tables without TTL and queries in a transaction are not cached.
+	require.NoError(t, db.Create(&cachedLog{Message: "a"}).Error)
+	var logs []cachedLog
+	require.NoError(t, db.Find(&logs).Error)
+	require.NoError(t, raw.Create(&cachedLog{Message: "b"}).Error)
+	require.NoError(t, db.Find(&logs).Error)
+	require.Len(t, logs, 2)
+	require.NoError(t, db.Find(&configs).Error)
+	require.NoError(t, raw.Create(&cachedConfig{Key: "c"}).Error)
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return tx.Find(&configs).Error
+	}))
+	require.Len(t, configs, 1)
+	require.NoError(t, db.Find(&configs).Error)
+	require.Empty(t, configs)
+}
+
+// brokenCacheStore is a CacheStore which fails once it is broken, like a remote cache going down.
+type brokenCacheStore struct {
+	CacheStore
+	broken bool
+}
+
+func (s *brokenCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if s.broken {
+		return nil, false, errors.New("get error")
+	}
+	return s.CacheStore.Get(ctx, key)
+}
+
+func (s *brokenCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if s.broken {
+		return errors.New("set error")
+	}
+	return s.CacheStore.Set(ctx, key, value, ttl)
+}
+
+func TestCache_SharedStore(t *testing.T) {
+	cache := newFakeLocalCache()
+	store := &brokenCacheStore{CacheStore: NewLocalCacheStore(cache)}
+	db, raw, target := newCacheTestDB(t, store, WithCacheTTL(time.Minute),
+		WithModelCacheTTL("cached_logs", 0), WithCachePrefix("test:"))
+
+	require.NoError(t, db.Create(&cachedConfig{Key: "a", Value: "1"}).Error)
+	var configs []cachedConfig
+	require.NoError(t, db.Where("key IN ?", []string{"a", "b"}).Find(&configs).Error)
+	require.NoError(t, raw.Create(&cachedConfig{Key: "b"}).Error)
+	require.NoError(t, db.Where("key IN ?", []string{"a", "b"}).Find(&configs).Error)
+	require.Len(t, configs, 1)
+	require.NoError(t, db.Where("key IN ?", []string{"a", "c"}).Find(&configs).Error)
+	require.Len(t, configs, 1)
+	require.NotEmpty(t, cache.ttls)
+	for key, ttl := range cache.ttls {
+		require.Contains(t, key, "test:")
+		require.True(t, ttl > 0, key)
+	}
+
+	// Another process shares the results and the generations.
+	other, err := NewClientProxy("trpc.sqlite.test.cache", target)
+	require.NoError(t, err)
+	require.NoError(t, other.Use(NewCache(store, WithCacheTTL(time.Minute),
+		WithCachePrefix("test:"))))
+	require.NoError(t, other.Where("key IN ?", []string{"a", "b"}).Find(&configs).Error)
+	require.Len(t, configs, 1)
+	require.NoError(t, other.Create(&cachedConfig{Key: "c"}).Error)
+	require.NoError(t, db.Where("key IN ?", []string{"a", "b"}).Find(&configs).Error)
+	require.Len(t, configs, 2)
+
+	// Tables with zero TTL are not cached.
+	var logs []cachedLog
+	require.NoError(t, db.Find(&logs).Error)
+	require.NoError(t, raw.Create(&cachedLog{Message: "b"}).Error)
+	require.NoError(t, db.Find(&logs).Error)
+	require.Len(t, logs, 1)
+
+	// Queries go to the database if the store fails.
+	store.broken = true
+	require.NoError(t, db.Where("key IN ?", []string{"a", "b"}).Find(&configs).Error)
+	require.Len(t, configs, 2)
+	require.NoError(t, db.Create(&cachedConfig{Key: "d"}).Error)
+}
+
+type failingCacheStore struct{}
+
+func (failingCacheStore) Get(context.Context, string) ([]byte, bool, error) {
+	return []byte("{"), true, nil
+}
+
+func (failingCacheStore) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("set error")
+}
+
+func TestCache_InvalidValue(t *testing.T) {
+	db, raw, _ := newCacheTestDB(t, failingCacheStore{}, WithCacheTTL(time.Minute))
+	require.NoError(t, db.Create(&cachedConfig{Key: "a"}).Error)
+	var configs []cachedConfig
+	require.NoError(t, db.Find(&configs).Error)
+	require.Len(t, configs, 1)
+
+	require.Error(t, raw.Use(NewCache(failingCacheStore{}, WithModelCacheTTL(1, time.Second))))
+}
@@ -0,0 +1,278 @@
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+)
+
+// cancelKey is the context key of the cancel function of the statement timeout of a query.
+type cancelKey struct{}
+
+// withCancelOnClose returns a context carrying cancel, which is called once the rows queried with the
+// context are closed, if the database is opened by openDB.
+func withCancelOnClose(ctx context.Context, cancel context.CancelFunc) context.Context {
+	return context.WithValue(ctx, cancelKey{}, cancel)
+}
+
+// openDB opens the database like open, except that the connections call the cancel functions carried
+// by the contexts of the queries when their rows are closed, see withCancelOnClose.
+// The rows of a query are bound to its context, so the context of the statement timeout can not be
+// canceled when the query returns, but only when the rows are closed.
+// The connections are wrapped, so sql.Conn.Raw returns *cancelConn rather than the connection of the driver.
+func openDB(open func(driverName, dsn string) (*sql.DB, error), driverName, dsn string) (*sql.DB, error) {
+	db, err := open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	// No connection is opened by sql.Open.
+	_ = db.Close()
+	var connector driver.Connector = dsnConnector{dsn: dsn, driver: drv}
+	if dc, ok := drv.(driver.DriverContext); ok {
+		if connector, err = dc.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+	return sql.OpenDB(cancelConnector{Connector: connector}), nil
+}
+
+// dsnConnector is the driver.Connector of the drivers which do not implement driver.DriverContext.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+// Connect implements driver.Connector.
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+// Driver implements driver.Connector.
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// cancelConnector creates the connections whose rows call the cancel functions on close.
+type cancelConnector struct {
+	driver.Connector
+}
+
+// Connect implements driver.Connector.
+func (c cancelConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cancelConn{Conn: conn}, nil
+}
+
+// Close implements io.Closer, which is called by sql.DB.Close.
+func (c cancelConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// cancelConn is a connection whose rows call the cancel functions on close.
+// The optional interfaces of the connection are forwarded with the same results as not implemented.
+type cancelConn struct {
+	driver.Conn
+}
+
+// PrepareContext implements driver.ConnPrepareContext.
+func (c *cancelConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// BeginTx implements driver.ConnBeginTx.
+func (c *cancelConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	// Same as database/sql for the drivers not implementing driver.ConnBeginTx.
+	if sql.IsolationLevel(opts.Isolation) != sql.LevelDefault {
+		return nil, errors.New("sql: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("sql: driver does not support read-only transactions")
+	}
+	return c.Conn.Begin() //nolint:staticcheck
+}
+
+// ExecContext implements driver.ExecerContext.
+func (c *cancelConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result,
+	error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+// QueryContext implements driver.QueryerContext, the statement is prepared on the connection if the
+// driver does not query it directly, just like what database/sql does.
+func (c *cancelConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows,
+	error) {
+	cancel, _ := ctx.Value(cancelKey{}).(context.CancelFunc)
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		rows, err := q.QueryContext(ctx, query, args)
+		if err != driver.ErrSkip {
+			if err != nil || cancel == nil {
+				return rows, err
+			}
+			return &cancelRows{Rows: rows, cancel: cancel}, nil
+		}
+	}
+	if cancel == nil {
+		return nil, driver.ErrSkip
+	}
+	stmt, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queryStmt(ctx, stmt, args)
+	if err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	return &cancelRows{Rows: rows, stmt: stmt, cancel: cancel}, nil
+}
+
+// queryStmt queries the prepared statement.
+func queryStmt(ctx context.Context, stmt driver.Stmt, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = arg.Value
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return stmt.Query(values) //nolint:staticcheck
+}
+
+// Ping implements driver.Pinger.
+func (c *cancelConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession implements driver.SessionResetter.
+func (c *cancelConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid implements driver.Validator.
+func (c *cancelConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue implements driver.NamedValueChecker.
+func (c *cancelConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// cancelRows calls cancel once closed, as well as closes the statement prepared for it.
+// The optional interfaces of the rows are forwarded with the same results as not implemented.
+type cancelRows struct {
+	driver.Rows
+	stmt   driver.Stmt
+	cancel context.CancelFunc
+}
+
+// Close implements driver.Rows.
+func (r *cancelRows) Close() error {
+	err := r.Rows.Close()
+	if r.stmt != nil {
+		r.stmt.Close()
+	}
+	r.cancel()
+	return err
+}
+
+// HasNextResultSet implements driver.RowsNextResultSet.
+func (r *cancelRows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet implements driver.RowsNextResultSet.
+func (r *cancelRows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
+
+// ColumnTypeScanType implements driver.RowsColumnTypeScanType.
+func (r *cancelRows) ColumnTypeScanType(index int) reflect.Type {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rs.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeDatabaseTypeName implements driver.RowsColumnTypeDatabaseTypeName.
+func (r *cancelRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rs.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength implements driver.RowsColumnTypeLength.
+func (r *cancelRows) ColumnTypeLength(index int) (int64, bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return rs.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable implements driver.RowsColumnTypeNullable.
+func (r *cancelRows) ColumnTypeNullable(index int) (bool, bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rs.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale implements driver.RowsColumnTypePrecisionScale.
+func (r *cancelRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rs.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,113 @@
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// prepareOnlyDriver hides the optional interfaces of the connections, which are queried by prepared statements.
+type prepareOnlyDriver struct {
+	driver.Driver
+}
+
+func (d prepareOnlyDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ driver.Conn }{conn}, nil
+}
+
+func init() {
+	db, _ := sql.Open("sqlite3", "")
+	sql.Register("sqlite3_prepare_only", prepareOnlyDriver{Driver: db.Driver()})
+	db.Close()
+}
+
+func TestOpenDB(t *testing.T) {
+	for _, driverName := range []string{"sqlite3", "sqlite3_prepare_only"} {
+		t.Run(driverName, func(t *testing.T) {
+			db, err := openDB(sql.Open, driverName, "file:"+filepath.Join(t.TempDir(), "gorm.db"))
+			require.NoError(t, err)
+			defer db.Close()
+			_, err = db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+			require.NoError(t, err)
+			_, err = db.Exec("INSERT INTO users (name) VALUES (?), (?)", "Jobs", "Woz")
+			require.NoError(t, err)
+
+			// The context is canceled once the rows are closed, by reading all of them or by Close.
+			ctx, cancel := context.WithCancel(context.Background())
+			rows, err := db.QueryContext(withCancelOnClose(ctx, cancel), "SELECT name FROM users WHERE id > ?", 0)
+			require.NoError(t, err)
+			var names []string
+			for rows.Next() {
+				var name string
+				require.NoError(t, rows.Scan(&name))
+				names = append(names, name)
+			}
+			require.NoError(t, rows.Err())
+			require.NoError(t, rows.Close())
+			require.Equal(t, []string{"Jobs", "Woz"}, names)
+			require.ErrorIs(t, ctx.Err(), context.Canceled)
+
+			ctx, cancel = context.WithCancel(context.Background())
+			rows, err = db.QueryContext(withCancelOnClose(ctx, cancel), "SELECT name FROM users")
+			require.NoError(t, err)
+			require.True(t, rows.Next())
+			require.NoError(t, ctx.Err())
+			require.NoError(t, rows.Close())
+			require.ErrorIs(t, ctx.Err(), context.Canceled)
+
+			ctx, cancel = context.WithCancel(context.Background())
+			var name string
+			require.NoError(t, db.QueryRowContext(withCancelOnClose(ctx, cancel),
+				"SELECT name FROM users WHERE id = ?", 2).Scan(&name))
+			require.Equal(t, "Woz", name)
+			require.ErrorIs(t, ctx.Err(), context.Canceled)
+
+			// The queries without the cancel functions are not affected.
+			require.NoError(t, db.QueryRow("SELECT name FROM users WHERE id = ?", 1).Scan(&name))
+			require.Equal(t, "Jobs", name)
+			tx, err := db.BeginTx(context.Background(), nil)
+			require.NoError(t, err)
+			require.NoError(t, tx.QueryRow("SELECT COUNT(*) FROM users").Scan(new(int)))
+			require.NoError(t, tx.Rollback())
+			require.NoError(t, db.Ping())
+		})
+	}
+
+	_, err := openDB(sql.Open, "unknown", "")
+	require.Error(t, err)
+}
+
+func TestClientTransport_GetDB_Timeout(t *testing.T) {
+	defer func(old map[string]time.Duration) { timeouts = old }(timeouts)
+	timeouts = map[string]time.Duration{"trpc.sqlite.timeout.db": time.Second}
+	ct := NewClientTransport()
+	ct.DefaultPoolConfig.DriverName = "sqlite3"
+
+	isCancelConn := func(db *sql.DB) bool {
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		var ok bool
+		require.NoError(t, conn.Raw(func(driverConn any) error {
+			_, ok = driverConn.(*cancelConn)
+			return nil
+		}))
+		return ok
+	}
+	// Only the pools of the services with the statement timeout wrap the connections of the driver.
+	db, err := ct.GetDB("trpc.sqlite.timeout.db", "file:"+filepath.Join(t.TempDir(), "timeout.db"))
+	require.NoError(t, err)
+	require.True(t, isCancelConn(db))
+	db, err = ct.GetDB("trpc.sqlite.test.db", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	require.False(t, isCancelConn(db))
+}
@@ -7,11 +7,8 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"reflect"
-	"strings"
 	"unsafe"
 
-	"gorm.io/driver/mysql"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"trpc.group/trpc-go/trpc-go"
@@ -152,54 +149,36 @@ var NewConnPool = func(name string, opts ...client.Option) ConnPool {
 	c.opts = append(c.opts,
 		client.WithProtocol("gorm"),
 		client.WithDisableServiceRouter(),
+		// The rows returned by the query are bound to the context, so the timeout is not applied to all requests,
+		// the one in the plugin configuration is applied per statement instead, see withTimeout.
 		client.WithTimeout(0),
 	)
 	return c
 }
 
 // NewClientProxy generates a gorm.DB that can connect to a specified location and send all requests through tRPC.
+// The dialector is chosen by the database type in the service name trpc.${db type}.xxx.xxx,
+// see RegisterDialector for the supported types. MySQL is used when the type is unknown.
+// An error is returned for sqlite and sqlserver if their subpackages are not imported.
 var NewClientProxy = func(name string, opts ...client.Option) (*gorm.DB, error) {
-	connPool := NewConnPool(name, opts...)
-	// If you need to add other types of databases, add a switch...case statement here and in transport.go.
-	splitServiceName := strings.Split(name, ".")
-	if len(splitServiceName) < 2 {
-		return gorm.Open(
-			mysql.New(
-				mysql.Config{
-					Conn: connPool,
-				}),
-			&gorm.Config{
-				Logger: getLogger(name),
-			})
-	}
-	// Support for other DBs.
 	// Compatibility logic, defaulting to MySQL.
 	// internal repository issues/235
-	dbEngineType := splitServiceName[1]
-	// Support postgresql.
-	switch dbEngineType {
-	case "postgres":
-		// Set the dialect configuration. Using the wrong dialect may cause strange errors.
-		return gorm.Open(
-			postgres.New(
-				postgres.Config{
-					PreferSimpleProtocol: true,
-					Conn:                 connPool,
-				}),
-			&gorm.Config{
-				Logger: getLogger(name),
-			})
-	default:
-		return gorm.Open(
-			mysql.New(
-				mysql.Config{
-					Conn: connPool,
-				}),
-			&gorm.Config{
-				Logger: getLogger(name),
-			},
-		)
+	newDialector, err := getDialectorFactory(getDBType(name))
+	if err != nil {
+		return nil, err
+	}
+	connPool := NewConnPool(name, opts...)
+	db, err := gorm.Open(newDialector(connPool), &gorm.Config{
+		Logger: getLogger(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Apply the migrations in migrations_dir of the plugin configuration for the first time.
+	if err := migrateOnce(name, db); err != nil {
+		return nil, err
 	}
+	return db, nil
 }
 
 // handleReq abstracts multiple types of requests into one type and identifies the type using 'op'.
@@ -212,6 +191,11 @@ func handleReq(ctx context.Context, cp gorm.ConnPool, mreq *Request, mrsp *Respo
 	} else {
 		gc = cp.(*Client)
 	}
+	opts := gc.opts
+	cancel := context.CancelFunc(func() {})
+	if mreq.Tx == nil {
+		ctx, opts, cancel = gc.withTimeout(ctx, mreq.Op)
+	}
 	mctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
 	msg.WithClientRPCName(fmt.Sprintf("/%s/%s", gc.ServiceName, mreq.Op))
@@ -223,10 +207,39 @@ func handleReq(ctx context.Context, cp gorm.ConnPool, mreq *Request, mrsp *Respo
 	// Handle request parameters.
 	err := handleReqArgs(mreq)
 	if err != nil {
+		cancel()
 		return err
 	}
 	// Pass the request to the tRPC framework.
-	return gc.Client.Invoke(mctx, mreq, mrsp, gc.opts...)
+	if err := gc.Client.Invoke(mctx, mreq, mrsp, opts...); err != nil {
+		cancel()
+		return err
+	}
+	if mrsp.Row != nil && mrsp.Row.Err() != nil {
+		// No rows to close.
+		cancel()
+	}
+	return nil
+}
+
+// withTimeout applies the statement timeout of the service configured by the plugin.
+// BeginTx and the statements inside a transaction are not limited,
+// since the transaction is bound to the context of BeginTx and would be rolled back on timeout.
+// The returned cancel function must be called if the request fails.
+func (gc *Client) withTimeout(ctx context.Context, op OpEnum) (context.Context, []client.Option,
+	context.CancelFunc) {
+	timeout := getTimeout(gc.ServiceName)
+	if timeout <= 0 || op == OpBeginTx {
+		return ctx, gc.opts, func() {}
+	}
+	if op == OpQueryContext || op == OpQueryRowContext {
+		// The rows are read after Invoke returns, so the context must not be canceled on return
+		// as client.WithTimeout does, but when the rows are closed, see openDB.
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return withCancelOnClose(ctx, cancel), gc.opts, cancel
+	}
+	// Options set by NewConnPool end with client.WithTimeout(0), which is overridden here.
+	return ctx, append(gc.opts[:len(gc.opts):len(gc.opts)], client.WithTimeout(timeout)), func() {}
 }
 
 // handleReqArgs handles the pass-through request parameters.
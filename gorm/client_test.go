@@ -6,12 +6,14 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/require"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"trpc.group/trpc-go/trpc-go/client"
@@ -632,3 +634,62 @@ func TestUnit_handleReqArgs(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 }
+
+func TestClient_withTimeout(t *testing.T) {
+	const name = "trpc.sqlite.test.timeout"
+	defer func() { timeouts = map[string]time.Duration{} }()
+	gc := NewConnPool(name).(*Client)
+
+	ctx, opts, _ := gc.withTimeout(context.Background(), OpExecContext)
+	require.Equal(t, context.Background(), ctx)
+	require.Len(t, opts, len(gc.opts))
+
+	timeouts = map[string]time.Duration{"*": time.Second}
+	ctx, opts, _ = gc.withTimeout(context.Background(), OpExecContext)
+	require.Equal(t, context.Background(), ctx)
+	require.Len(t, opts, len(gc.opts)+1)
+	require.Len(t, gc.opts, 3)
+
+	ctx, opts, _ = gc.withTimeout(context.Background(), OpQueryContext)
+	_, ok := ctx.Deadline()
+	require.True(t, ok)
+	require.Len(t, opts, len(gc.opts))
+
+	ctx, opts, _ = gc.withTimeout(context.Background(), OpBeginTx)
+	require.Equal(t, context.Background(), ctx)
+	require.Len(t, opts, len(gc.opts))
+}
+
+func TestClient_Timeout(t *testing.T) {
+	const name = "trpc.sqlite.test.timeout"
+	defer func() { timeouts = map[string]time.Duration{} }()
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	defer transport.RegisterClientTransport("gorm", defaultClientTransport)
+
+	type User struct {
+		ID   int
+		Name string
+	}
+	dsn := "file:" + filepath.Join(t.TempDir(), "gorm.db")
+	db, err := NewClientProxy(name, client.WithTarget("dsn://"+dsn))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+
+	timeouts = map[string]time.Duration{name: 50 * time.Millisecond}
+	require.NoError(t, db.Create(&User{Name: "Jobs"}).Error)
+	// The rows are still readable after the query returns.
+	var users []User
+	require.NoError(t, db.Find(&users).Error)
+	require.Equal(t, []User{{ID: 1, Name: "Jobs"}}, users)
+
+	// Statements in a transaction are not limited.
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		time.Sleep(100 * time.Millisecond)
+		return tx.Create(&User{Name: "Woz"}).Error
+	}))
+
+	timeouts = map[string]time.Duration{"*": time.Nanosecond}
+	require.Error(t, db.Exec("DELETE FROM users").Error)
+	require.Error(t, db.Find(&users).Error)
+}
@@ -0,0 +1,89 @@
+package gorm
+
+import (
+	"fmt"
+	"sync"
+
+	"gorm.io/driver/clickhouse"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// DialectorFactory creates the GORM dialector which sends all requests through connPool.
+type DialectorFactory func(connPool ConnPool) gorm.Dialector
+
+var (
+	dialectorsLock sync.RWMutex
+	// dialectors are the dialector factories keyed by database type,
+	// which is the second segment of the service name, such as postgres in trpc.postgres.xxx.xxx.
+	dialectors = map[string]DialectorFactory{
+		"mysql": func(connPool ConnPool) gorm.Dialector {
+			return mysql.New(mysql.Config{Conn: connPool})
+		},
+		"postgres": func(connPool ConnPool) gorm.Dialector {
+			// Set the dialect configuration. Using the wrong dialect may cause strange errors.
+			return postgres.New(postgres.Config{PreferSimpleProtocol: true, Conn: connPool})
+		},
+		"clickhouse": func(connPool ConnPool) gorm.Dialector {
+			return clickhouse.New(clickhouse.Config{Conn: connPool})
+		},
+	}
+	// driverNames are the database/sql driver names of the known database types, including those
+	// whose dialectors are registered by the sqlite and sqlserver subpackages.
+	driverNames = map[string]string{
+		"mysql":      "mysql",
+		"postgres":   "pgx", // The driver registered for postgres is pgx by default.
+		"sqlite":     "sqlite3",
+		"clickhouse": "clickhouse",
+		"sqlserver":  "sqlserver",
+	}
+	// dialectorPackages are the subpackages registering the dialectors of the database types.
+	dialectorPackages = map[string]string{
+		"sqlite":    "trpc.group/trpc-go/trpc-database/gorm/sqlite",
+		"sqlserver": "trpc.group/trpc-go/trpc-database/gorm/sqlserver",
+	}
+)
+
+// RegisterDialector registers the dialector factory of a database type, so that NewClientProxy
+// creates the dialector by f for the service names like trpc.${dbType}.xxx.xxx.
+// The built-in types are mysql, postgres and clickhouse, which can be overridden. The sqlite and sqlserver
+// types are registered by importing the subpackages gorm/sqlite and gorm/sqlserver, which are kept out of
+// this package since SQLite requires cgo.
+// For a new type, the database/sql driver registered with the name dbType is used to open the database,
+// unless driver_name is set in the plugin configuration.
+// It should be called before NewClientProxy, usually in init.
+func RegisterDialector(dbType string, f DialectorFactory) {
+	dialectorsLock.Lock()
+	defer dialectorsLock.Unlock()
+	dialectors[dbType] = f
+}
+
+// getDialectorFactory returns the dialector factory of the database type, MySQL is used if the type is unknown.
+// It returns an error if the type is known but its subpackage is not imported, since the database is
+// opened by the driver of the type, which MySQL statements can not be run on.
+func getDialectorFactory(dbType string) (DialectorFactory, error) {
+	dialectorsLock.RLock()
+	defer dialectorsLock.RUnlock()
+	if f, ok := dialectors[dbType]; ok {
+		return f, nil
+	}
+	if pkg, ok := dialectorPackages[dbType]; ok {
+		return nil, fmt.Errorf("gorm: dialector of %s is not registered, import _ %q", dbType, pkg)
+	}
+	return dialectors["mysql"], nil
+}
+
+// getDriverName returns the database/sql driver name of the database type.
+func getDriverName(dbType string) string {
+	if name, ok := driverNames[dbType]; ok {
+		return name
+	}
+	dialectorsLock.RLock()
+	defer dialectorsLock.RUnlock()
+	if _, ok := dialectors[dbType]; ok {
+		return dbType
+	}
+	// Compatibility logic, keeps MySQL as the default.
+	return "mysql"
+}
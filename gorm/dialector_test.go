@@ -0,0 +1,91 @@
+package gorm
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+func init() {
+	// The tests run on SQLite, whose dialector is registered by the sqlite subpackage in applications,
+	// which imports this package and can not be imported by the tests of it.
+	RegisterDialector("sqlite", func(connPool ConnPool) gorm.Dialector {
+		return &sqlite.Dialector{Conn: connPool}
+	})
+}
+
+func TestRegisterDialector(t *testing.T) {
+	const dbType = "tidb"
+	defer func() {
+		dialectorsLock.Lock()
+		delete(dialectors, dbType)
+		dialectorsLock.Unlock()
+	}()
+	require.Equal(t, "mysql", getDriverName(dbType))
+
+	var got ConnPool
+	RegisterDialector(dbType, func(connPool ConnPool) gorm.Dialector {
+		got = connPool
+		return &sqlite.Dialector{Conn: connPool}
+	})
+	require.Equal(t, dbType, getDriverName(dbType))
+
+	ct := NewClientTransport()
+	ct.DefaultPoolConfig.DriverName = sqlite.DriverName
+	transport.RegisterClientTransport("gorm", ct)
+	defer transport.RegisterClientTransport("gorm", defaultClientTransport)
+	dsn := "file:" + filepath.Join(t.TempDir(), "gorm.db")
+	db, err := NewClientProxy("trpc.tidb.test.db", client.WithTarget("dsn://"+dsn))
+	require.NoError(t, err)
+	require.Equal(t, "sqlite", db.Dialector.Name())
+	require.Equal(t, "trpc.tidb.test.db", got.(*Client).ServiceName)
+}
+
+func TestGetDialectorFactory(t *testing.T) {
+	tests := map[string]string{
+		"mysql":      "mysql",
+		"postgres":   "postgres",
+		"sqlite":     "sqlite",
+		"clickhouse": "clickhouse",
+		"unknown":    "mysql",
+	}
+	for dbType, name := range tests {
+		f, err := getDialectorFactory(dbType)
+		require.NoError(t, err)
+		require.Equal(t, name, f(NewConnPool("trpc."+dbType+".test.db")).Name(), dbType)
+	}
+	// The sqlserver dialector is registered by the sqlserver subpackage.
+	_, err := getDialectorFactory("sqlserver")
+	require.ErrorContains(t, err, "trpc.group/trpc-go/trpc-database/gorm/sqlserver")
+	_, err = NewClientProxy("trpc.sqlserver.test.db")
+	require.ErrorContains(t, err, "trpc.group/trpc-go/trpc-database/gorm/sqlserver")
+	require.Equal(t, "sqlite3", getDriverName("sqlite"))
+	require.Equal(t, "sqlserver", getDriverName("sqlserver"))
+	require.Equal(t, "pgx", getDriverName("postgres"))
+	require.Equal(t, "mysql", getDriverName("unknown"))
+}
+
+func TestNewClientProxy_SQLite(t *testing.T) {
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	defer transport.RegisterClientTransport("gorm", defaultClientTransport)
+
+	type User struct {
+		ID   int
+		Name string
+	}
+	dsn := "file:" + filepath.Join(t.TempDir(), "gorm.db")
+	db, err := NewClientProxy("trpc.sqlite.test.db", client.WithTarget("dsn://"+dsn))
+	require.NoError(t, err)
+	require.Equal(t, "sqlite", db.Dialector.Name())
+	require.NoError(t, db.AutoMigrate(&User{}))
+	require.NoError(t, db.Create(&User{Name: "Jobs"}).Error)
+	var user User
+	require.NoError(t, db.First(&user).Error)
+	require.Equal(t, User{ID: 1, Name: "Jobs"}, user)
+}
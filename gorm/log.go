@@ -4,8 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/utils"
@@ -29,6 +35,16 @@ const (
 	yellowBold  = "\033[33;1m"
 )
 
+const (
+	// tracerName is the instrumentation name of the spans recorded by TRPCLogger.
+	tracerName = "trpc.group/trpc-go/trpc-database/gorm"
+	// maxSpanSqlSize is the max length of the SQL recorded in spans if max_sql_size is not configured.
+	maxSpanSqlSize = 1024
+)
+
+// tablePattern matches the first table name in a SQL statement.
+var tablePattern = regexp.MustCompile("(?i)\\b(?:FROM|INTO|UPDATE|TABLE)\\s+([`\"\\[]?[\\w.]+)")
+
 // TRPCLogger implements the Gorm logger.Interface.
 type TRPCLogger struct {
 	maxSqlSize                          int
@@ -107,12 +123,15 @@ func (p *TRPCLogger) Error(ctx context.Context, format string, args ...interface
 
 // Trace prints detailed SQL logs of the corresponding level based on the SQL execution situation
 // and the configured log level.
+// A span is also recorded for each statement if an OpenTelemetry TracerProvider is set,
+// such as by the OpenTelemetry filter of trpc-go, regardless of the log level.
 func (p *TRPCLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	fc = p.traceSpan(ctx, begin, elapsed, fc, err)
 	if p.config.LogLevel <= logger.Silent {
 		return
 	}
 
-	elapsed := time.Since(begin)
 	switch {
 	case err != nil && p.config.LogLevel >= logger.Error &&
 		(!errors.Is(err, gorm.ErrRecordNotFound) || !p.config.IgnoreRecordNotFoundError):
@@ -153,3 +172,58 @@ func (p *TRPCLogger) truncateSQL(sql string) string {
 	}
 	return sql
 }
+
+// traceSpan records a span of the SQL statement as a child of the span in ctx, with the operation, the table name,
+// the rows affected and the truncated SQL as attributes. It returns fc, which is memoized if it has been called.
+func (p *TRPCLogger) traceSpan(ctx context.Context, begin time.Time, elapsed time.Duration,
+	fc func() (string, int64), err error) func() (string, int64) {
+	_, span := otel.Tracer(tracerName).Start(ctx, "gorm",
+		trace.WithTimestamp(begin), trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End(trace.WithTimestamp(begin.Add(elapsed)))
+	if !span.IsRecording() {
+		return fc
+	}
+
+	sql, rows := fc()
+	operation, table := parseSQL(sql)
+	if operation != "" {
+		span.SetName(strings.TrimSpace(operation + " " + table))
+	}
+	span.SetAttributes(
+		attribute.String("db.operation", operation),
+		attribute.String("db.sql.table", table),
+		attribute.String("db.statement", p.truncateSpanSQL(sql)),
+	)
+	if rows != -1 {
+		span.SetAttributes(attribute.Int64("db.rows_affected", rows))
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return func() (string, int64) { return sql, rows }
+}
+
+func (p *TRPCLogger) truncateSpanSQL(sql string) string {
+	if p.maxSqlSize > 0 {
+		return p.truncateSQL(sql)
+	}
+	if len(sql) > maxSpanSqlSize {
+		return sql[:maxSpanSqlSize-1] + " ..."
+	}
+	return sql
+}
+
+// parseSQL returns the operation, such as SELECT, and the first table name of the SQL statement.
+func parseSQL(sql string) (operation, table string) {
+	sql = strings.TrimSpace(sql)
+	if i := strings.IndexAny(sql, " \t\r\n("); i >= 0 {
+		operation = strings.ToUpper(sql[:i])
+	} else {
+		operation = strings.ToUpper(sql)
+	}
+	if m := tablePattern.FindStringSubmatch(sql); m != nil {
+		table = strings.Trim(m[1], "`\"[]")
+	}
+	return operation, table
+}
@@ -1,12 +1,21 @@
 package gorm
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"trpc.group/trpc-go/trpc-go"
 	"trpc.group/trpc-go/trpc-go/codec"
@@ -195,3 +204,74 @@ func TestUnit_Log_Trace_P0(t *testing.T) {
 		So(calledTimes, ShouldEqual, 6)
 	})
 }
+
+func TestTRPCLogger_TraceSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	silentLog := NewTRPCLogger(logger.Config{LogLevel: logger.Silent})
+	begin := time.Now().Add(-time.Second)
+	calls := 0
+	silentLog.Trace(ctx, begin, func() (string, int64) {
+		calls++
+		return "UPDATE `users` SET `name`='Jobs' WHERE `id` = 1", 3
+	}, nil)
+	silentLog.SetMaxSqlLength(10)
+	silentLog.Trace(ctx, begin, func() (string, int64) {
+		return "SELECT * FROM users WHERE id = 1", -1
+	}, gorm.ErrRecordNotFound)
+	silentLog.Trace(ctx, begin, func() (string, int64) {
+		return "INSERT INTO users (name) VALUES ('Jobs')", 0
+	}, errors.New("duplicate"))
+	parent.End()
+	require.Equal(t, 1, calls)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	span := spans[0]
+	require.Equal(t, "UPDATE users", span.Name())
+	require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	require.Equal(t, begin, span.StartTime())
+	require.True(t, span.EndTime().Sub(begin) >= time.Second)
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("db.operation", "UPDATE"),
+		attribute.String("db.sql.table", "users"),
+		attribute.String("db.statement", "UPDATE `users` SET `name`='Jobs' WHERE `id` = 1"),
+		attribute.Int64("db.rows_affected", 3),
+	}, span.Attributes())
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	span = spans[1]
+	require.Equal(t, "SELECT users", span.Name())
+	require.Contains(t, span.Attributes(), attribute.String("db.statement", "SELECT *  ..."))
+	require.NotContains(t, span.Attributes(), attribute.Int64("db.rows_affected", -1))
+	require.Equal(t, codes.Unset, span.Status().Code)
+
+	span = spans[2]
+	require.Equal(t, "INSERT users", span.Name())
+	require.Equal(t, codes.Error, span.Status().Code)
+	require.Equal(t, "duplicate", span.Status().Description)
+}
+
+func Test_parseSQL(t *testing.T) {
+	tests := []struct {
+		sql, operation, table string
+	}{
+		{"SELECT * FROM `users` WHERE id = 1", "SELECT", "users"},
+		{"  select count(*) from db.orders", "SELECT", "db.orders"},
+		{`INSERT INTO "users" ("name") VALUES ('Jobs')`, "INSERT", "users"},
+		{"UPDATE [users] SET name = 'Jobs'", "UPDATE", "users"},
+		{"DELETE FROM users", "DELETE", "users"},
+		{"CREATE TABLE `users` (`id` integer)", "CREATE", "users"},
+		{"SELECT 1", "SELECT", ""},
+		{"COMMIT", "COMMIT", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		operation, table := parseSQL(tt.sql)
+		require.Equal(t, tt.operation, operation, tt.sql)
+		require.Equal(t, tt.table, table, tt.sql)
+	}
+}
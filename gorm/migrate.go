@@ -0,0 +1,545 @@
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+const (
+	defaultMigrationTable       = "schema_migrations"
+	defaultMigrationLockTimeout = time.Minute
+)
+
+// ErrMigrationLocked is returned when the migration lock is not acquired in time,
+// usually because another replica is migrating.
+var ErrMigrationLocked = errors.New("gorm migrate: migration lock is held by others")
+
+// Migration is a versioned schema change. It is either a pair of SQL scripts or a pair of Go funcs.
+type Migration struct {
+	// Version orders the migrations, such as 1, 2, 3 or a timestamp like 20240101120000.
+	Version int64
+	// Name describes the migration.
+	Name string
+	// UpSQL and DownSQL are the SQL statements separated by semicolons.
+	UpSQL   string
+	DownSQL string
+	// Up and Down are the Go funcs, which are used instead of UpSQL and DownSQL if they are set.
+	Up   func(tx *gorm.DB) error
+	Down func(tx *gorm.DB) error
+}
+
+// migrationRecord is a row of the migration table, which records an applied migration.
+type migrationRecord struct {
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+// MigrationRunner applies the migrations to a database in the order of version, and records the applied versions
+// in the migration table. Only one MigrationRunner migrates a database at a time by taking an advisory lock,
+// which is supported by MySQL and PostgreSQL.
+//
+//	db, err := gormplugin.NewClientProxy("trpc.mysql.app.db")
+//	r, err := gormplugin.NewMigrationRunner(db, gormplugin.WithMigrationsDir("./migrations"))
+//	err = r.Up(ctx)
+type MigrationRunner struct {
+	db         *gorm.DB
+	opts       *migrationOptions
+	migrations []Migration
+}
+
+// NewMigrationRunner creates a MigrationRunner, and loads the migrations from the directory set by
+// WithMigrationsDir or WithMigrationsFS.
+func NewMigrationRunner(db *gorm.DB, opts ...MigrationOption) (*MigrationRunner, error) {
+	o := &migrationOptions{
+		table:       defaultMigrationTable,
+		lockTimeout: defaultMigrationLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	migrations := append([]Migration(nil), o.migrations...)
+	if o.fsys != nil {
+		loaded, err := LoadMigrations(o.fsys, o.dir)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, loaded...)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("gorm migrate: duplicate version %d", migrations[i].Version)
+		}
+	}
+	if o.lockName == "" {
+		o.lockName = "trpc-gorm-migrate:" + o.table
+	}
+	return &MigrationRunner{db: db, opts: o, migrations: migrations}, nil
+}
+
+// Migrate applies all the pending migrations, it is a shortcut of NewMigrationRunner and MigrationRunner.Up.
+func Migrate(ctx context.Context, db *gorm.DB, opts ...MigrationOption) error {
+	r, err := NewMigrationRunner(db, opts...)
+	if err != nil {
+		return err
+	}
+	return r.Up(ctx)
+}
+
+// Migrations returns all the migrations in the order of version.
+func (r *MigrationRunner) Migrations() []Migration {
+	return r.migrations
+}
+
+// Applied returns the applied versions in ascending order.
+func (r *MigrationRunner) Applied(ctx context.Context) ([]int64, error) {
+	db := r.db.WithContext(ctx)
+	if !db.Migrator().HasTable(r.opts.table) {
+		return nil, nil
+	}
+	var versions []int64
+	if err := db.Table(r.opts.table).Order("version").Pluck("version", &versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Pending returns the migrations which are not applied yet.
+func (r *MigrationRunner) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := r.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int64]bool, len(applied))
+	for _, v := range applied {
+		done[v] = true
+	}
+	var pending []Migration
+	for _, m := range r.migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies all the pending migrations in the order of version. Each migration runs in a transaction along with
+// its record, note that DDL statements are committed implicitly by some databases such as MySQL.
+// In dry-run mode, the pending statements are printed instead.
+func (r *MigrationRunner) Up(ctx context.Context) error {
+	return r.withLock(ctx, func() error {
+		pending, err := r.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if r.opts.dryRun == nil {
+			if err := r.db.WithContext(ctx).Table(r.opts.table).AutoMigrate(&migrationRecord{}); err != nil {
+				return fmt.Errorf("gorm migrate: create migration table error: %w", err)
+			}
+		}
+		for _, m := range pending {
+			if err := r.run(ctx, m, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the latest applied migrations, at most steps of them.
+// In dry-run mode, the statements are printed instead.
+func (r *MigrationRunner) Down(ctx context.Context, steps int) error {
+	return r.withLock(ctx, func() error {
+		applied, err := r.Applied(ctx)
+		if err != nil {
+			return err
+		}
+		migrations := make(map[int64]Migration, len(r.migrations))
+		for _, m := range r.migrations {
+			migrations[m.Version] = m
+		}
+		for i := len(applied) - 1; i >= 0 && steps > 0; i, steps = i-1, steps-1 {
+			m, ok := migrations[applied[i]]
+			if !ok {
+				return fmt.Errorf("gorm migrate: migration of applied version %d not found", applied[i])
+			}
+			if err := r.run(ctx, m, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// run applies or rolls back the migration along with its record.
+func (r *MigrationRunner) run(ctx context.Context, m Migration, up bool) error {
+	direction, fn, script := "up", m.Up, m.UpSQL
+	if !up {
+		direction, fn, script = "down", m.Down, m.DownSQL
+	}
+	if fn == nil && strings.TrimSpace(script) == "" {
+		return fmt.Errorf("gorm migrate: no %s migration of version %d", direction, m.Version)
+	}
+	if w := r.opts.dryRun; w != nil {
+		fmt.Fprintf(w, "-- %d_%s.%s\n", m.Version, m.Name, direction)
+		return dryRunMigration(r.db.WithContext(ctx), w, fn, script)
+	}
+
+	begin := time.Now()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if fn != nil {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		} else {
+			for _, stmt := range SplitStatements(script) {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+		}
+		records := tx.Table(r.opts.table)
+		if up {
+			return records.Create(&migrationRecord{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		}
+		return records.Where("version = ?", m.Version).Delete(&migrationRecord{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("gorm migrate: %d_%s.%s error: %w", m.Version, m.Name, direction, err)
+	}
+	log.InfoContextf(ctx, "gorm migrate: %d_%s.%s done, cost: %v", m.Version, m.Name, direction, time.Since(begin))
+	return nil
+}
+
+// dryRunMigration prints the statements of the migration. The Go func runs in a DryRun session,
+// so only the statements supported by the DryRun mode of GORM are printed.
+func dryRunMigration(db *gorm.DB, w io.Writer, fn func(*gorm.DB) error, script string) (err error) {
+	if fn == nil {
+		for _, stmt := range SplitStatements(script) {
+			fmt.Fprintf(w, "%s;\n", stmt)
+		}
+		return nil
+	}
+	defer func() {
+		// Queries like Row and Scan don't work in DryRun mode, which may cause panics.
+		if e := recover(); e != nil {
+			fmt.Fprintf(w, "-- statements after are unknown in dry-run mode: %v\n", e)
+		}
+	}()
+	tx := db.Session(&gorm.Session{DryRun: true, Logger: &dryRunLogger{Interface: logger.Discard, w: w}})
+	if err := fn(tx); err != nil {
+		fmt.Fprintf(w, "-- error in dry-run mode: %v\n", err)
+	}
+	return nil
+}
+
+// dryRunLogger prints the SQL statements traced by GORM.
+type dryRunLogger struct {
+	logger.Interface
+	w io.Writer
+}
+
+// LogMode implements logger.Interface.
+func (l *dryRunLogger) LogMode(logger.LogLevel) logger.Interface {
+	return l
+}
+
+// Trace implements logger.Interface.
+func (l *dryRunLogger) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	fmt.Fprintf(l.w, "%s;\n", sql)
+}
+
+// withLock runs fn with the advisory lock of the database held.
+// The lock is taken on a dedicated connection, since advisory locks belong to the session.
+func (r *MigrationRunner) withLock(ctx context.Context, fn func() error) error {
+	name := r.db.Dialector.Name()
+	if name != "mysql" && name != "postgres" {
+		log.DebugContextf(ctx, "gorm migrate: advisory lock is not supported by %s, migrate without lock", name)
+		return fn()
+	}
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	unlock, err := acquireLock(ctx, conn, name, r.opts.lockName, r.opts.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// acquireLock acquires the advisory lock of the dialect on conn, and returns the func to release it.
+func acquireLock(ctx context.Context, conn *sql.Conn, dialect, name string,
+	timeout time.Duration) (func(), error) {
+	switch dialect {
+	case "mysql":
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name,
+			int64(timeout/time.Second)).Scan(&acquired); err != nil {
+			return nil, fmt.Errorf("gorm migrate: acquire lock error: %w", err)
+		}
+		if acquired.Int64 != 1 {
+			return nil, ErrMigrationLocked
+		}
+		return func() {
+			if _, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name); err != nil {
+				log.Errorf("gorm migrate: release lock %s error: %v", name, err)
+			}
+		}, nil
+	default:
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		key := int64(h.Sum64())
+		lockCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			if lockCtx.Err() == context.DeadlineExceeded {
+				return nil, ErrMigrationLocked
+			}
+			return nil, fmt.Errorf("gorm migrate: acquire lock error: %w", err)
+		}
+		return func() {
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+				log.Errorf("gorm migrate: release lock %s error: %v", name, err)
+			}
+		}, nil
+	}
+}
+
+// migrationFilePattern matches the migration files like 1_create_users.up.sql and 1_create_users.down.sql.
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadMigrations loads the SQL migrations in dir of fsys, whose file names are like
+// ${version}_${name}.up.sql and ${version}_${name}.down.sql. Other files are ignored.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("gorm migrate: read migrations dir error: %w", err)
+	}
+	migrations := make(map[int64]*Migration)
+	for _, entry := range entries {
+		m := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("gorm migrate: invalid version of %s: %w", entry.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("gorm migrate: read %s error: %w", entry.Name(), err)
+		}
+		migration, ok := migrations[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			migrations[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("gorm migrate: version %d has different names %s and %s",
+				version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.UpSQL = string(b)
+		} else {
+			migration.DownSQL = string(b)
+		}
+	}
+	result := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// SplitStatements splits the SQL script into statements by semicolons,
+// ignoring the ones in quotes and comments. Empty statements are dropped.
+// Statements containing semicolons themselves, such as stored procedures, should be written as Go migrations.
+func SplitStatements(script string) []string {
+	var (
+		stmts []string
+		start int
+		empty = true // Whether the current statement has no code other than comments and spaces.
+	)
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			empty = false
+			for i++; i < len(script) && script[i] != c; i++ {
+				if script[i] == '\\' {
+					i++
+				}
+			}
+		case c == '-' && strings.HasPrefix(script[i:], "--"), c == '#':
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			if end := strings.Index(script[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(script)
+			}
+		case c == ';':
+			if !empty {
+				stmts = append(stmts, strings.TrimSpace(script[start:i]))
+			}
+			start, empty = i+1, true
+		case c != ' ' && c != '\t' && c != '\r' && c != '\n':
+			empty = false
+		}
+	}
+	if !empty {
+		stmts = append(stmts, strings.TrimSpace(script[start:]))
+	}
+	return stmts
+}
+
+// MigrationOption is the option of MigrationRunner.
+type MigrationOption func(*migrationOptions)
+
+type migrationOptions struct {
+	fsys        fs.FS
+	dir         string
+	migrations  []Migration
+	table       string
+	lockName    string
+	lockTimeout time.Duration
+	dryRun      io.Writer
+}
+
+// WithMigrationsDir loads the SQL migrations in the directory, see LoadMigrations for the file names.
+func WithMigrationsDir(dir string) MigrationOption {
+	return func(o *migrationOptions) {
+		o.fsys, o.dir = os.DirFS(dir), "."
+	}
+}
+
+// WithMigrationsFS loads the SQL migrations in dir of fsys, such as an embed.FS.
+func WithMigrationsFS(fsys fs.FS, dir string) MigrationOption {
+	return func(o *migrationOptions) {
+		o.fsys, o.dir = fsys, dir
+	}
+}
+
+// WithMigrations adds the migrations, usually the Go ones.
+func WithMigrations(migrations ...Migration) MigrationOption {
+	return func(o *migrationOptions) {
+		o.migrations = append(o.migrations, migrations...)
+	}
+}
+
+// WithMigrationTable sets the table recording the applied versions, which is schema_migrations by default.
+func WithMigrationTable(table string) MigrationOption {
+	return func(o *migrationOptions) {
+		o.table = table
+	}
+}
+
+// WithMigrationLock sets the name of the advisory lock and the timeout of acquiring it.
+// The name is derived from the migration table by default, and the timeout is 1 minute by default.
+func WithMigrationLock(name string, timeout time.Duration) MigrationOption {
+	return func(o *migrationOptions) {
+		o.lockName, o.lockTimeout = name, timeout
+	}
+}
+
+// WithDryRun prints the statements to w instead of executing them, nothing is changed in the database.
+func WithDryRun(w io.Writer) MigrationOption {
+	return func(o *migrationOptions) {
+		o.dryRun = w
+	}
+}
+
+var (
+	migrationsLock sync.Mutex
+	// migrationDirs are the migrations_dir of the services in the plugin configuration.
+	migrationDirs = map[string]string{}
+	// registeredMigrations are the Go migrations of the services registered by RegisterMigrations.
+	registeredMigrations = map[string][]Migration{}
+	// migrated records the migrations of the services in this process.
+	migrated = map[string]*serviceMigration{}
+)
+
+// serviceMigration serializes the migrations of a service, without blocking those of the others.
+type serviceMigration struct {
+	sync.Mutex
+	done bool
+}
+
+// RegisterMigrations registers the Go migrations of the service, which are applied along with the SQL migrations
+// in migrations_dir of the plugin configuration when NewClientProxy of the service is called for the first time.
+// It should be called before NewClientProxy, usually in init.
+func RegisterMigrations(serviceName string, migrations ...Migration) {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+	registeredMigrations[serviceName] = append(registeredMigrations[serviceName], migrations...)
+}
+
+// migrateOnce applies the migrations of the service configured by the plugin,
+// only once in the process if it succeeds. The migrations are bounded by the timeout of the service
+// in the plugin configuration if it is set.
+func migrateOnce(name string, db *gorm.DB) error {
+	migrationsLock.Lock()
+	dir, migrations := migrationDirs[name], registeredMigrations[name]
+	if dir == "" && len(migrations) == 0 {
+		migrationsLock.Unlock()
+		return nil
+	}
+	m, ok := migrated[name]
+	if !ok {
+		m = &serviceMigration{}
+		migrated[name] = m
+	}
+	migrationsLock.Unlock()
+
+	m.Lock()
+	defer m.Unlock()
+	if m.done {
+		return nil
+	}
+	opts := []MigrationOption{WithMigrations(migrations...)}
+	if dir != "" {
+		opts = append(opts, WithMigrationsDir(dir))
+	}
+	ctx := trpc.BackgroundContext()
+	if timeout := getTimeout(name); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := Migrate(ctx, db, opts...); err != nil {
+		return err
+	}
+	m.done = true
+	return nil
+}
@@ -0,0 +1,268 @@
+package gorm
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+// newMigrateTestDB creates a gorm.DB of an empty SQLite database.
+func newMigrateTestDB(t *testing.T, name string) *gorm.DB {
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	t.Cleanup(func() {
+		transport.RegisterClientTransport("gorm", defaultClientTransport)
+	})
+	dsn := "file:" + filepath.Join(t.TempDir(), "gorm.db")
+	db, err := NewClientProxy(name, client.WithTarget("dsn://"+dsn))
+	require.NoError(t, err)
+	return db
+}
+
+var indexMigration = Migration{
+	Version: 3,
+	Name:    "index_email",
+	Up: func(tx *gorm.DB) error {
+		return tx.Exec("CREATE INDEX idx_users_email ON users (email)").Error
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Exec("DROP INDEX idx_users_email").Error
+	},
+}
+
+func TestMigrationRunner(t *testing.T) {
+	ctx := context.Background()
+	db := newMigrateTestDB(t, "trpc.sqlite.test.migrate")
+
+	var out bytes.Buffer
+	r, err := NewMigrationRunner(db, WithMigrationsDir("testdata/migrations"), WithMigrations(indexMigration),
+		WithDryRun(&out))
+	require.NoError(t, err)
+	require.Len(t, r.Migrations(), 3)
+	require.NoError(t, r.Up(ctx))
+	require.Equal(t, `-- 1_create_users.up
+-- Users of the app; the name is unique.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE
+);
+INSERT INTO users (name) VALUES ('Jobs;Woz');
+-- 2_add_email.up
+ALTER TABLE users ADD COLUMN email TEXT;
+-- 3_index_email.up
+CREATE INDEX idx_users_email ON users (email);
+`, out.String())
+	require.False(t, db.Migrator().HasTable("users"))
+	require.False(t, db.Migrator().HasTable(defaultMigrationTable))
+
+	r, err = NewMigrationRunner(db, WithMigrationsDir("testdata/migrations"), WithMigrations(indexMigration))
+	require.NoError(t, err)
+	require.NoError(t, r.Up(ctx))
+	applied, err := r.Applied(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2, 3}, applied)
+	pending, err := r.Pending(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	require.True(t, db.Migrator().HasIndex("users", "idx_users_email"))
+	var name string
+	require.NoError(t, db.Table("users").Select("name").Where("email IS NULL").Scan(&name).Error)
+	require.Equal(t, "Jobs;Woz", name)
+	require.NoError(t, r.Up(ctx))
+
+	require.NoError(t, r.Down(ctx, 2))
+	applied, err = r.Applied(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int64{1}, applied)
+	require.False(t, db.Migrator().HasColumn("users", "email"))
+	require.NoError(t, r.Down(ctx, 10))
+	require.False(t, db.Migrator().HasTable("users"))
+
+	// A failed migration is not recorded.
+	require.NoError(t, Migrate(ctx, db, WithMigrationsDir("testdata/migrations")))
+	err = Migrate(ctx, db, WithMigrations(Migration{Version: 4, Name: "bad", UpSQL: "ALTER TABLE nobody ADD x TEXT"}))
+	require.ErrorContains(t, err, "4_bad.up")
+	pending, err = r.Pending(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+
+	// Errors of the migrations.
+	_, err = NewMigrationRunner(db, WithMigrations(Migration{Version: 1}, Migration{Version: 1}))
+	require.Error(t, err)
+	_, err = NewMigrationRunner(db, WithMigrationsDir("testdata/nothing"))
+	require.Error(t, err)
+	err = Migrate(ctx, db, WithMigrationTable("other_migrations"), WithMigrations(Migration{Version: 1, Name: "empty"}))
+	require.ErrorContains(t, err, "no up migration")
+}
+
+func TestMigrationRunner_DryRunGo(t *testing.T) {
+	db := newMigrateTestDB(t, "trpc.sqlite.test.migrate")
+	var out bytes.Buffer
+	type user struct {
+		ID   int
+		Name string
+	}
+	err := Migrate(context.Background(), db, WithDryRun(&out), WithMigrations(Migration{
+		Version: 1,
+		Name:    "seed",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Create(&user{Name: "Jobs"}).Error; err != nil {
+				return err
+			}
+			var n int
+			return tx.Raw("SELECT count(*) FROM users").Row().Scan(&n)
+		},
+	}))
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "-- 1_seed.up\nINSERT INTO `users` (`name`) VALUES (\"Jobs\")")
+	require.Contains(t, out.String(), "-- statements after are unknown in dry-run mode")
+}
+
+func TestMigrateOnce(t *testing.T) {
+	const name = "trpc.sqlite.test.migrate_once"
+	migrationsLock.Lock()
+	migrationDirs[name] = "testdata/migrations"
+	migrationsLock.Unlock()
+	RegisterMigrations(name, indexMigration)
+	defer func() {
+		migrationsLock.Lock()
+		delete(migrationDirs, name)
+		delete(registeredMigrations, name)
+		delete(migrated, name)
+		migrationsLock.Unlock()
+	}()
+
+	db := newMigrateTestDB(t, name)
+	require.True(t, db.Migrator().HasIndex("users", "idx_users_email"))
+	require.True(t, migrated[name].done)
+
+	// Migrations are applied only once in the process.
+	db = newMigrateTestDB(t, name)
+	require.False(t, db.Migrator().HasTable("users"))
+}
+
+func TestMigrateOnce_Concurrent(t *testing.T) {
+	const blocked, name = "trpc.sqlite.test.migrate_blocked", "trpc.sqlite.test.migrate_timeout"
+	defer func(old map[string]time.Duration) { timeouts = old }(timeouts)
+	timeouts = map[string]time.Duration{name: 50 * time.Millisecond}
+	start, release := make(chan struct{}), make(chan struct{})
+	RegisterMigrations(blocked, Migration{Version: 1, Name: "block", Up: func(*gorm.DB) error {
+		close(start)
+		<-release
+		return nil
+	}})
+	RegisterMigrations(name, Migration{Version: 1, Name: "wait", Up: func(tx *gorm.DB) error {
+		<-tx.Statement.Context.Done()
+		return tx.Statement.Context.Err()
+	}})
+	defer func() {
+		migrationsLock.Lock()
+		delete(registeredMigrations, blocked)
+		delete(registeredMigrations, name)
+		delete(migrated, blocked)
+		delete(migrated, name)
+		migrationsLock.Unlock()
+	}()
+
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	defer transport.RegisterClientTransport("gorm", defaultClientTransport)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := NewClientProxy(blocked, client.WithTarget("dsn://file:"+filepath.Join(t.TempDir(), "blocked.db")))
+		errs <- err
+	}()
+	<-start
+	// The migrations of a service neither block those of the others, nor run longer than its timeout.
+	_, err := NewClientProxy(name, client.WithTarget("dsn://file:"+filepath.Join(t.TempDir(), "gorm.db")))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.False(t, migrated[name].done)
+	close(release)
+	require.NoError(t, <-errs)
+	require.True(t, migrated[blocked].done)
+}
+
+func TestAcquireLock(t *testing.T) {
+	ctx := context.Background()
+	newConn := func() (*sql.Conn, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		conn, err := db.Conn(ctx)
+		require.NoError(t, err)
+		return conn, mock
+	}
+
+	conn, mock := newConn()
+	mock.ExpectQuery("SELECT GET_LOCK").WithArgs("lock", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectExec("SELECT RELEASE_LOCK").WithArgs("lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	unlock, err := acquireLock(ctx, conn, "mysql", "lock", 3*time.Second)
+	require.NoError(t, err)
+	unlock()
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	conn, mock = newConn()
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(0))
+	_, err = acquireLock(ctx, conn, "mysql", "lock", time.Second)
+	require.ErrorIs(t, err, ErrMigrationLocked)
+
+	conn, mock = newConn()
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+	unlock, err = acquireLock(ctx, conn, "postgres", "lock", time.Second)
+	require.NoError(t, err)
+	unlock()
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	conn, mock = newConn()
+	mock.ExpectExec("SELECT pg_advisory_lock").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err = acquireLock(ctx, conn, "postgres", "lock", 10*time.Millisecond)
+	require.ErrorIs(t, err, ErrMigrationLocked)
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"m/20240102_b.up.sql":   {Data: []byte("B")},
+		"m/20240101_a.up.sql":   {Data: []byte("A")},
+		"m/20240101_a.down.sql": {Data: []byte("-A")},
+		"m/other.sql":           {Data: []byte("X")},
+	}, "m")
+	require.NoError(t, err)
+	require.Equal(t, []Migration{
+		{Version: 20240101, Name: "a", UpSQL: "A", DownSQL: "-A"},
+		{Version: 20240102, Name: "b", UpSQL: "B"},
+	}, migrations)
+
+	_, err = LoadMigrations(fstest.MapFS{
+		"1_a.up.sql":   {Data: []byte("A")},
+		"1_b.down.sql": {Data: []byte("B")},
+	}, ".")
+	require.Error(t, err)
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := map[string][]string{
+		"":                            nil,
+		" ;\n; -- comment only\n":     nil,
+		"SELECT 1; SELECT 2":          {"SELECT 1", "SELECT 2"},
+		"SELECT ';', \"a;\", `b;`;":   {"SELECT ';', \"a;\", `b;`"},
+		"SELECT 'it\\'s;'; SELECT 3;": {"SELECT 'it\\'s;'", "SELECT 3"},
+		"-- a;b\nSELECT 1;":           {"-- a;b\nSELECT 1"},
+		"# a;b\nSELECT 1;":            {"# a;b\nSELECT 1"},
+		"/* a;b */ SELECT 1; /* c":    {"/* a;b */ SELECT 1"},
+	}
+	for script, want := range tests {
+		require.Equal(t, want, SplitStatements(script), script)
+	}
+}
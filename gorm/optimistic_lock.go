@@ -0,0 +1,286 @@
+package gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// optimisticLockName is the name of the OptimisticLock plugin as well as its callbacks.
+const optimisticLockName = "trpc:optimistic_lock"
+
+const optimisticLockVersionKey = optimisticLockName + ":version"
+
+// ErrOptimisticLock is returned when a record is updated or deleted by its version,
+// while the version has been changed by others, or the record has been deleted.
+var ErrOptimisticLock = errors.New("gorm: optimistic lock conflict")
+
+// OptimisticLock is a GORM plugin for optimistic locking by the version column of models.
+// Create sets the version of a record to 1 if it is zero. Update increments the version,
+// and Update and Delete of a record with the primary key are conditioned on its version,
+// which return ErrOptimisticLock if no rows are affected. The version of the record is incremented
+// after it is updated, so the record can be updated again. Updates of multiple rows increment their versions
+// without conditions.
+//
+//	type Account struct {
+//		ID      int
+//		Balance int
+//		Version int64
+//	}
+//
+//	err = db.Use(gormplugin.NewOptimisticLock())
+//	err = gormplugin.RetryOnConflict(ctx, db, func(tx *gorm.DB) error {
+//		var account Account
+//		if err := tx.First(&account, id).Error; err != nil {
+//			return err
+//		}
+//		return tx.Model(&account).Update("balance", account.Balance+10).Error
+//	})
+type OptimisticLock struct {
+	column string
+}
+
+// OptimisticLockOption is the option of OptimisticLock.
+type OptimisticLockOption func(*OptimisticLock)
+
+// WithVersionColumn sets the version column, which is "version" by default.
+func WithVersionColumn(column string) OptimisticLockOption {
+	return func(l *OptimisticLock) {
+		l.column = column
+	}
+}
+
+// NewOptimisticLock creates an OptimisticLock.
+func NewOptimisticLock(opts ...OptimisticLockOption) *OptimisticLock {
+	l := &OptimisticLock{column: "version"}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Name implements gorm.Plugin.
+func (l *OptimisticLock) Name() string {
+	return optimisticLockName
+}
+
+// Initialize implements gorm.Plugin, it registers the callbacks.
+func (l *OptimisticLock) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("gorm:create").Register(optimisticLockName, l.initVersion),
+		callbacks.Update().Before("gorm:update").Register(optimisticLockName+":before", l.beforeUpdate),
+		callbacks.Update().After("gorm:update").Register(optimisticLockName+":after", l.afterUpdate),
+		callbacks.Delete().Before("gorm:delete").Register(optimisticLockName+":before", l.beforeDelete),
+		callbacks.Delete().After("gorm:delete").Register(optimisticLockName+":after", l.checkRowsAffected),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionField returns the version field of the model.
+func (l *OptimisticLock) versionField(db *gorm.DB) *schema.Field {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return nil
+	}
+	field := db.Statement.Schema.LookUpField(l.column)
+	if field == nil || (field.DataType != schema.Int && field.DataType != schema.Uint) {
+		return nil
+	}
+	return field
+}
+
+// initVersion sets the versions of the records to 1 if they are zero.
+func (l *OptimisticLock) initVersion(db *gorm.DB) {
+	field := l.versionField(db)
+	if field == nil {
+		return
+	}
+	set := func(rv reflect.Value) {
+		if _, isZero := field.ValueOf(db.Statement.Context, rv); isZero && rv.CanAddr() {
+			db.AddError(field.Set(db.Statement.Context, rv, 1))
+		}
+	}
+	switch rv := db.Statement.ReflectValue; rv.Kind() {
+	case reflect.Struct:
+		set(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if elem := reflect.Indirect(rv.Index(i)); elem.Kind() == reflect.Struct {
+				set(elem)
+			}
+		}
+	}
+}
+
+// beforeUpdate increments the version, and conditions the update of a record on its version.
+func (l *OptimisticLock) beforeUpdate(db *gorm.DB) {
+	field := l.versionField(db)
+	if field == nil {
+		return
+	}
+	stmt := db.Statement
+	if _, ok := stmt.Clauses["SET"]; !ok {
+		// Builds the assignments in advance like gorm:update, which skips building if SET exists.
+		set := callbacks.ConvertToAssignments(stmt)
+		if len(set) == 0 {
+			return
+		}
+		stmt.AddClause(set)
+	}
+	set, ok := stmt.Clauses["SET"].Expression.(clause.Set)
+	if !ok {
+		return
+	}
+	assignments := make(clause.Set, 0, len(set)+1)
+	for _, assignment := range set {
+		if assignment.Column.Name != field.DBName {
+			assignments = append(assignments, assignment)
+		}
+	}
+	column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+	assignments = append(assignments, clause.Assignment{
+		Column: clause.Column{Name: field.DBName},
+		Value:  clause.Expr{SQL: "? + 1", Vars: []interface{}{column}},
+	})
+	stmt.AddClause(assignments)
+	l.conditionOnVersion(db, field)
+}
+
+// beforeDelete conditions the deletion of a record on its version.
+func (l *OptimisticLock) beforeDelete(db *gorm.DB) {
+	if field := l.versionField(db); field != nil {
+		l.conditionOnVersion(db, field)
+	}
+}
+
+// conditionOnVersion adds the condition on the version if the statement is on a record with the primary key.
+func (l *OptimisticLock) conditionOnVersion(db *gorm.DB, field *schema.Field) {
+	stmt := db.Statement
+	rv := stmt.ReflectValue
+	if rv.Kind() != reflect.Struct || len(stmt.Schema.PrimaryFields) == 0 {
+		return
+	}
+	for _, pk := range stmt.Schema.PrimaryFields {
+		if _, isZero := pk.ValueOf(stmt.Context, rv); isZero {
+			return
+		}
+	}
+	version, _ := field.ValueOf(stmt.Context, rv)
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: version},
+	}})
+	db.InstanceSet(optimisticLockVersionKey, version)
+}
+
+// checkRowsAffected returns ErrOptimisticLock if no rows are affected by the statement conditioned on the version.
+func (l *OptimisticLock) checkRowsAffected(db *gorm.DB) {
+	version, ok := db.InstanceGet(optimisticLockVersionKey)
+	if ok && db.Error == nil && !db.DryRun && db.RowsAffected == 0 {
+		db.AddError(fmt.Errorf("%w: table %s, version %v", ErrOptimisticLock, db.Statement.Table, version))
+	}
+}
+
+// afterUpdate checks the rows affected, and increments the version of the updated record.
+func (l *OptimisticLock) afterUpdate(db *gorm.DB) {
+	l.checkRowsAffected(db)
+	version, ok := db.InstanceGet(optimisticLockVersionKey)
+	stmt := db.Statement
+	if !ok || db.Error != nil || db.DryRun || !stmt.ReflectValue.CanAddr() {
+		return
+	}
+	if field := l.versionField(db); field != nil {
+		next := reflect.ValueOf(version).Convert(reflect.TypeOf(int64(0))).Int() + 1
+		db.AddError(field.Set(stmt.Context, stmt.ReflectValue, next))
+	}
+}
+
+// Restore restores the soft deleted record, whose model has a gorm.DeletedAt field.
+// It is conditioned on the version of the record if OptimisticLock is used.
+func Restore(db *gorm.DB, model interface{}) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return db.Unscoped().Model(model).Update(field.DBName, nil).Error
+		}
+	}
+	return fmt.Errorf("gorm: model %s is not soft deleted", stmt.Schema.Name)
+}
+
+// RetryOption is the option of RetryOnConflict.
+type RetryOption func(*retryPolicy)
+
+// retryPolicy is the retry policy of RetryOnConflict.
+type retryPolicy struct {
+	max     int
+	backoff time.Duration
+}
+
+// WithRetryMax sets the max times of retries, which is 3 by default.
+func WithRetryMax(max int) RetryOption {
+	return func(p *retryPolicy) {
+		p.max = max
+	}
+}
+
+// WithRetryBackoff sets the backoff of retries, which is 10ms by default.
+// The n-th retry waits for a random duration up to n times the backoff, so that the writers
+// conflicting on the same records do not collide again.
+func WithRetryBackoff(backoff time.Duration) RetryOption {
+	return func(p *retryPolicy) {
+		p.backoff = backoff
+	}
+}
+
+// RetryOnConflict runs fn in a transaction, and reruns it in a new transaction after a backoff
+// if it fails with ErrOptimisticLock, so fn must read the records again in the transaction.
+// If db is already in a transaction, fn is run in a nested transaction only once,
+// since the records read by the outer transaction can not be refreshed.
+func RetryOnConflict(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error, opts ...RetryOption) error {
+	policy := &retryPolicy{max: 3, backoff: 10 * time.Millisecond}
+	for _, opt := range opts {
+		opt(policy)
+	}
+	db = db.WithContext(ctx)
+	err := db.Transaction(fn)
+	if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); inTx {
+		return err
+	}
+	for i := 0; i < policy.max && errors.Is(err, ErrOptimisticLock); i++ {
+		if d := policy.delay(i); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		err = db.Transaction(fn)
+	}
+	return err
+}
+
+// delay returns the delay in (0, backoff*(i+1)] before the (i+1)-th retry.
+// Each conflict means that another writer has committed, rather than that the database is overloaded,
+// so the delay grows linearly with the writers who may be ahead, and is fully random to spread them.
+func (p *retryPolicy) delay(i int) time.Duration {
+	if p.backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(p.backoff)*int64(i+1))) + 1
+}
@@ -0,0 +1,181 @@
+package gorm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+type lockedAccount struct {
+	ID        int
+	Balance   int
+	Version   int64
+	DeletedAt gorm.DeletedAt
+}
+
+func newOptimisticLockTestDB(t *testing.T, opts ...OptimisticLockOption) *gorm.DB {
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	t.Cleanup(func() {
+		transport.RegisterClientTransport("gorm", defaultClientTransport)
+	})
+	db, err := NewClientProxy("trpc.sqlite.test.optimistic_lock",
+		client.WithTarget("dsn://file:"+filepath.Join(t.TempDir(), "gorm.db")))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&lockedAccount{}))
+	require.NoError(t, db.Use(NewOptimisticLock(opts...)))
+	return db
+}
+
+func TestOptimisticLock(t *testing.T) {
+	db := newOptimisticLockTestDB(t)
+
+	account := lockedAccount{Balance: 10}
+	require.NoError(t, db.Create(&account).Error)
+	require.Equal(t, int64(1), account.Version)
+	accounts := []lockedAccount{{Balance: 1}, {Balance: 2, Version: 5}}
+	require.NoError(t, db.Create(&accounts).Error)
+	require.Equal(t, int64(1), accounts[0].Version)
+	require.Equal(t, int64(5), accounts[1].Version)
+
+	// The version of the record is checked and incremented.
+	stale := account
+	require.NoError(t, db.Model(&account).Update("balance", 20).Error)
+	require.Equal(t, int64(2), account.Version)
+	require.NoError(t, db.Model(&account).Updates(lockedAccount{Balance: 30}).Error)
+	require.Equal(t, int64(3), account.Version)
+	account.Balance = 40
+	require.NoError(t, db.Save(&account).Error)
+	require.Equal(t, int64(4), account.Version)
+	var got lockedAccount
+	require.NoError(t, db.First(&got, account.ID).Error)
+	require.Equal(t, lockedAccount{ID: 1, Balance: 40, Version: 4}, got)
+
+	err := db.Model(&stale).Update("balance", 0).Error
+	require.ErrorIs(t, err, ErrOptimisticLock)
+	require.Equal(t, int64(1), stale.Version)
+	stale.Balance = 0
+	require.ErrorIs(t, db.Save(&stale).Error, ErrOptimisticLock)
+	require.ErrorIs(t, db.Delete(&stale).Error, ErrOptimisticLock)
+
+	// Updates of multiple rows increment the versions without conditions.
+	require.NoError(t, db.Model(&lockedAccount{}).Where("balance < ?", 10).Update("balance", 3).Error)
+	got = lockedAccount{}
+	require.NoError(t, db.First(&got, accounts[1].ID).Error)
+	require.Equal(t, int64(6), got.Version)
+
+	// Soft delete and restore are conditioned on the version.
+	require.NoError(t, db.Delete(&account).Error)
+	require.ErrorIs(t, db.First(&lockedAccount{}, account.ID).Error, gorm.ErrRecordNotFound)
+	require.ErrorIs(t, Restore(db, &stale), ErrOptimisticLock)
+	require.NoError(t, Restore(db, &account))
+	require.Equal(t, int64(5), account.Version)
+	got = lockedAccount{}
+	require.NoError(t, db.First(&got, account.ID).Error)
+	require.Equal(t, int64(5), got.Version)
+	require.Error(t, Restore(db, &lockedTag{}))
+}
+
+type lockedTag struct {
+	ID  int
+	Rev uint
+}
+
+func TestOptimisticLock_VersionColumn(t *testing.T) {
+	db := newOptimisticLockTestDB(t, WithVersionColumn("rev"))
+	require.NoError(t, db.AutoMigrate(&lockedTag{}))
+	tag := lockedTag{}
+	require.NoError(t, db.Create(&tag).Error)
+	require.Equal(t, uint(1), tag.Rev)
+	stale := tag
+	require.NoError(t, db.Model(&tag).Update("id", tag.ID).Error)
+	require.Equal(t, uint(2), tag.Rev)
+	require.ErrorIs(t, db.Model(&stale).Update("id", tag.ID).Error, ErrOptimisticLock)
+
+	// Models without the version column are not affected.
+	account := lockedAccount{}
+	require.NoError(t, db.Create(&account).Error)
+	require.Zero(t, account.Version)
+	require.NoError(t, db.Model(&account).Update("balance", 1).Error)
+}
+
+func TestRetryOnConflict(t *testing.T) {
+	db := newOptimisticLockTestDB(t)
+	account := lockedAccount{Balance: 10}
+	require.NoError(t, db.Create(&account).Error)
+
+	// The record read in the first two runs is stale.
+	var runs int
+	err := RetryOnConflict(context.Background(), db, func(tx *gorm.DB) error {
+		runs++
+		var got lockedAccount
+		if err := tx.First(&got, account.ID).Error; err != nil {
+			return err
+		}
+		if runs <= 2 {
+			got.Version--
+		}
+		return tx.Model(&got).Update("balance", got.Balance+10).Error
+	}, WithRetryBackoff(time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 3, runs)
+	var got lockedAccount
+	require.NoError(t, db.First(&got, account.ID).Error)
+	require.Equal(t, lockedAccount{ID: account.ID, Balance: 20, Version: 2}, got)
+
+	// Gives up after the max retries.
+	runs = 0
+	err = RetryOnConflict(context.Background(), db, func(tx *gorm.DB) error {
+		runs++
+		return ErrOptimisticLock
+	}, WithRetryMax(2), WithRetryBackoff(0))
+	require.ErrorIs(t, err, ErrOptimisticLock)
+	require.Equal(t, 3, runs)
+
+	// Other errors are not retried.
+	runs = 0
+	err = RetryOnConflict(context.Background(), db, func(tx *gorm.DB) error {
+		runs++
+		return errors.New("other")
+	})
+	require.EqualError(t, err, "other")
+	require.Equal(t, 1, runs)
+
+	// Retries stop when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	runs = 0
+	err = RetryOnConflict(ctx, db, func(tx *gorm.DB) error {
+		runs++
+		cancel()
+		return ErrOptimisticLock
+	}, WithRetryBackoff(time.Hour))
+	require.ErrorIs(t, err, ErrOptimisticLock)
+	require.Equal(t, 1, runs)
+
+	// Nested transactions are not retried.
+	runs = 0
+	require.ErrorIs(t, db.Transaction(func(tx *gorm.DB) error {
+		return RetryOnConflict(context.Background(), tx, func(tx *gorm.DB) error {
+			runs++
+			return ErrOptimisticLock
+		})
+	}), ErrOptimisticLock)
+	require.Equal(t, 1, runs)
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := &retryPolicy{backoff: 10 * time.Millisecond}
+	for i := 0; i < 5; i++ {
+		d := p.delay(i)
+		require.Greater(t, d, time.Duration(0))
+		require.LessOrEqual(t, d, time.Duration(i+1)*10*time.Millisecond)
+	}
+	require.Zero(t, (&retryPolicy{}).delay(1))
+}
@@ -31,6 +31,19 @@ func getLogger(name string) logger.Interface {
 	return trpcLogger
 }
 
+// timeouts are the statement timeouts defined in the configuration file, keyed by service name.
+var timeouts = map[string]time.Duration{}
+
+// getTimeout returns the statement timeout of the service, or the global one if it is not configured.
+// Zero means no timeout.
+func getTimeout(name string) time.Duration {
+	timeout, ok := timeouts[name]
+	if !ok {
+		timeout = timeouts["*"]
+	}
+	return timeout
+}
+
 // loggerConfig is the gorm logger configuration.
 type loggerConfig struct {
 	SlowThreshold             int64           `yaml:"slow_threshold"`
@@ -46,8 +59,13 @@ type Config struct {
 	MaxOpen     int           `yaml:"max_open"`     // Maximum number of connections that can be open at same time.
 	MaxLifetime int           `yaml:"max_lifetime"` // The maximum lifetime of each connection, in milliseconds.
 	DriverName  string        `yaml:"driver_name"`  // Driver name for customization.
+	Timeout     int           `yaml:"timeout"`      // The timeout of each statement, in milliseconds.
 	Logger      *loggerConfig `yaml:"logger"`       // Logger configuration.
-	Service     []struct {
+	// Sharding rules of the logical tables, which are used by NewSharding.
+	Sharding []ShardingRule `yaml:"sharding"`
+	// The config center which the pool configurations are reloaded from, see ClientTransport.Watch.
+	Watch   *watchConfig `yaml:"watch"`
+	Service []struct {
 		// In the case of having multiple database connections,
 		// you can configure the connection pool independently.
 		Name        string
@@ -55,13 +73,26 @@ type Config struct {
 		MaxOpen     int `yaml:"max_open"`     // Maximum number of connections that can be open at same time.
 		MaxLifetime int `yaml:"max_lifetime"` // The maximum lifetime of each connection, in milliseconds.
 		// The name of the custom driver used, which is empty by default.
-		DriverName string        `yaml:"driver_name"`
-		Logger     *loggerConfig `yaml:"logger"` // Logger configuration.
+		DriverName string `yaml:"driver_name"`
+		// The DSN which overrides the address selected by the target, which is empty by default.
+		DSN     string        `yaml:"dsn"`
+		Timeout int           `yaml:"timeout"` // The timeout of each statement, in milliseconds.
+		Logger  *loggerConfig `yaml:"logger"`  // Logger configuration.
+		// The directory of the SQL migrations, which are applied when NewClientProxy is called for the first time.
+		MigrationsDir string `yaml:"migrations_dir"`
 	}
 }
 
+// watchConfig is the configuration of the config center to watch.
+type watchConfig struct {
+	Config string `yaml:"config"` // The name of the config center, which is registered by config.Register.
+	Key    string `yaml:"key"`    // The key of the gorm plugin configuration in the config center.
+}
+
 // Plugin used to load the configuration of sql.DB connection parameters.
-type Plugin struct{}
+type Plugin struct {
+	watch *watchConfig
+}
 
 // Type returns the type of the plugin.
 func (m *Plugin) Type() string {
@@ -78,6 +109,13 @@ func (m *Plugin) Setup(name string, configDesc plugin.Decoder) (err error) {
 	if err = configDesc.Decode(&config); err != nil {
 		return
 	}
+	for i := range config.Sharding {
+		if err = config.Sharding[i].validate(); err != nil {
+			return
+		}
+	}
+	shardingRules = config.Sharding
+	m.watch = config.Watch
 	if config.Logger != nil {
 		loggers["*"] = NewTRPCLogger(logger.Config{
 			SlowThreshold:             time.Duration(config.Logger.SlowThreshold) * time.Millisecond,
@@ -87,22 +125,55 @@ func (m *Plugin) Setup(name string, configDesc plugin.Decoder) (err error) {
 		})
 		loggers["*"].maxSqlSize = config.Logger.MaxSqlSize
 	}
-	// Set pool configurations that effective for all GORM clients.
-	defaultClientTransport.DefaultPoolConfig = PoolConfig{
+	// Statements inside a transaction are not limited by the timeout, see Client.withTimeout.
+	timeouts = map[string]time.Duration{"*": time.Duration(config.Timeout) * time.Millisecond}
+	defaultPoolConfig, poolConfigs := newPoolConfigs(&config)
+	defaultClientTransport.configLock.Lock()
+	defaultClientTransport.DefaultPoolConfig = defaultPoolConfig
+	defaultClientTransport.PoolConfigs = poolConfigs
+	defaultClientTransport.configLock.Unlock()
+	for _, s := range config.Service {
+		if s.Timeout != 0 {
+			timeouts[s.Name] = time.Duration(s.Timeout) * time.Millisecond
+		}
+		if s.MigrationsDir != "" {
+			migrationsLock.Lock()
+			migrationDirs[s.Name] = s.MigrationsDir
+			migrationsLock.Unlock()
+		}
+		if s.Logger != nil {
+			loggers[s.Name] = NewTRPCLogger(logger.Config{
+				SlowThreshold:             time.Duration(s.Logger.SlowThreshold) * time.Millisecond,
+				Colorful:                  s.Logger.Colorful,
+				IgnoreRecordNotFoundError: s.Logger.IgnoreRecordNotFoundError,
+				LogLevel:                  s.Logger.LogLevel,
+			})
+			loggers[s.Name].maxSqlSize = s.Logger.MaxSqlSize
+		}
+	}
+	// Need to call the register function explicitly, otherwise the configuration will not take effect.
+	transport.RegisterClientTransport("gorm", defaultClientTransport)
+	return nil
+}
+
+// newPoolConfigs returns the pool configuration effective for all GORM clients,
+// and the ones effective for each GORM client.
+func newPoolConfigs(config *Config) (PoolConfig, map[string]PoolConfig) {
+	defaultPoolConfig := PoolConfig{
 		MaxIdle:     config.MaxIdle,
 		MaxOpen:     config.MaxOpen,
 		MaxLifetime: time.Duration(config.MaxLifetime) * time.Millisecond,
 		DriverName:  config.DriverName,
 	}
-	setDefaultValueOfGlobalPoolConfig(&defaultClientTransport.DefaultPoolConfig)
-	// Set pool configurations that effective for each GORM client.
+	setDefaultValueOfGlobalPoolConfig(&defaultPoolConfig)
 	poolConfigs := make(map[string]PoolConfig, len(config.Service))
 	for _, s := range config.Service {
 		servicePoolConfig := PoolConfig{
-			MaxIdle:     defaultClientTransport.DefaultPoolConfig.MaxIdle,
-			MaxOpen:     defaultClientTransport.DefaultPoolConfig.MaxOpen,
-			MaxLifetime: defaultClientTransport.DefaultPoolConfig.MaxLifetime,
-			DriverName:  defaultClientTransport.DefaultPoolConfig.DriverName,
+			MaxIdle:     defaultPoolConfig.MaxIdle,
+			MaxOpen:     defaultPoolConfig.MaxOpen,
+			MaxLifetime: defaultPoolConfig.MaxLifetime,
+			DriverName:  defaultPoolConfig.DriverName,
+			DSN:         s.DSN,
 		}
 		if s.MaxIdle != 0 {
 			servicePoolConfig.MaxIdle = s.MaxIdle
@@ -117,20 +188,8 @@ func (m *Plugin) Setup(name string, configDesc plugin.Decoder) (err error) {
 			servicePoolConfig.DriverName = s.DriverName
 		}
 		poolConfigs[s.Name] = servicePoolConfig
-		if s.Logger != nil {
-			loggers[s.Name] = NewTRPCLogger(logger.Config{
-				SlowThreshold:             time.Duration(s.Logger.SlowThreshold) * time.Millisecond,
-				Colorful:                  s.Logger.Colorful,
-				IgnoreRecordNotFoundError: s.Logger.IgnoreRecordNotFoundError,
-				LogLevel:                  s.Logger.LogLevel,
-			})
-			loggers[s.Name].maxSqlSize = s.Logger.MaxSqlSize
-		}
 	}
-	defaultClientTransport.PoolConfigs = poolConfigs
-	// Need to call the register function explicitly, otherwise the configuration will not take effect.
-	transport.RegisterClientTransport("gorm", defaultClientTransport)
-	return nil
+	return defaultPoolConfig, poolConfigs
 }
 
 const (
@@ -9,9 +9,11 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
 	"gorm.io/gorm/logger"
 	"trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/config"
 	"trpc.group/trpc-go/trpc-go/plugin"
 	"trpc.group/trpc-go/trpc-go/transport"
 )
@@ -39,6 +41,7 @@ plugins:
       max_idle: 20
       max_open: 100
       max_lifetime: 180
+      timeout: 500
       logger:
         slow_threshold: 1000
         colorful: true
@@ -49,6 +52,7 @@ plugins:
           max_idle: 10
           max_open: 20
           max_lifetime: 10000
+          timeout: 2000
           logger:
             slow_threshold: 1000
             colorful: true
@@ -108,6 +112,8 @@ plugins:
 			So(cmp.Equal(clientTransport.opts, expectedTransport.opts), ShouldBeTrue)
 			So(cmp.Equal(clientTransport, expectedTransport, cmpopts.IgnoreUnexported(ClientTransport{}),
 				cmpopts.IgnoreFields(ClientTransport{}, "SQLDBLock")), ShouldBeTrue)
+			So(getTimeout("trpc.mysql.test.db1"), ShouldEqual, 2*time.Second)
+			So(getTimeout("trpc.mysql.test.db2"), ShouldEqual, 500*time.Millisecond)
 		})
 	})
 }
@@ -383,3 +389,107 @@ func TestUnit_getLogger(t *testing.T) {
 		So(trpcLogger, ShouldEqual, DefaultTRPCLogger)
 	})
 }
+
+func TestGormPluginSetupSharding(t *testing.T) {
+	defer func() { shardingRules = nil }()
+	setup := func(bts string) error {
+		var cfg = trpc.Config{}
+		require.NoError(t, yaml.Unmarshal([]byte(bts), &cfg))
+		node := cfg.Plugins[pluginType][pluginName]
+		return (&Plugin{}).Setup(pluginName, &plugin.YamlNodeDecoder{Node: &node})
+	}
+
+	require.NoError(t, setup(`
+plugins:
+  database:
+    gorm:
+      sharding:
+        - table: orders
+          key: user_id
+          algorithm: range
+          shards:
+            - service: trpc.mysql.test.db1
+              table: orders_0
+              max: 1000000
+            - service: trpc.mysql.test.db2
+              table: orders_1
+              min: 1000000
+`))
+	require.Equal(t, []ShardingRule{{
+		Table:     "orders",
+		Key:       "user_id",
+		Algorithm: "range",
+		Shards: []Shard{
+			{Service: "trpc.mysql.test.db1", Table: "orders_0", Max: 1000000},
+			{Service: "trpc.mysql.test.db2", Table: "orders_1", Min: 1000000},
+		},
+	}}, shardingRules)
+
+	require.Error(t, setup(`
+plugins:
+  database:
+    gorm:
+      sharding:
+        - table: orders
+          key: user_id
+          algorithm: unknown
+          shards:
+            - table: orders_0
+`))
+}
+
+func TestGormPluginSetupMigrations(t *testing.T) {
+	defer func() {
+		migrationsLock.Lock()
+		delete(migrationDirs, "trpc.mysql.test.migrate")
+		migrationsLock.Unlock()
+	}()
+	var cfg = trpc.Config{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+plugins:
+  database:
+    gorm:
+      service:
+        - name: trpc.mysql.test.migrate
+          migrations_dir: ./migrations
+        - name: trpc.mysql.test.db
+`), &cfg))
+	node := cfg.Plugins[pluginType][pluginName]
+	require.NoError(t, (&Plugin{}).Setup(pluginName, &plugin.YamlNodeDecoder{Node: &node}))
+	require.Equal(t, "./migrations", migrationDirs["trpc.mysql.test.migrate"])
+	_, ok := migrationDirs["trpc.mysql.test.db"]
+	require.False(t, ok)
+}
+
+func TestGormPluginSetupWatch(t *testing.T) {
+	var cfg = trpc.Config{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+plugins:
+  database:
+    gorm:
+      watch:
+        config: fake
+        key: gorm
+      service:
+        - name: trpc.mysql.test.watch
+          dsn: root:root@tcp(127.0.0.1:3306)/test
+`), &cfg))
+	node := cfg.Plugins[pluginType][pluginName]
+	p := &Plugin{}
+	require.NoError(t, p.Setup(pluginName, &plugin.YamlNodeDecoder{Node: &node}))
+	require.Equal(t, &watchConfig{Config: "fake", Key: "gorm"}, p.watch)
+	require.Equal(t, "root:root@tcp(127.0.0.1:3306)/test",
+		defaultClientTransport.PoolConfigs["trpc.mysql.test.watch"].DSN)
+	require.Error(t, p.OnFinish(pluginName))
+
+	kv := &fakeKVConfig{
+		value:  "service:\n  - name: trpc.mysql.test.watch\n    dsn: root:root@tcp(127.0.0.1:3306)/watch\n",
+		events: make(chan config.Response),
+	}
+	defer close(kv.events)
+	config.Register(kv)
+	require.NoError(t, p.OnFinish(pluginName))
+	require.Equal(t, "root:root@tcp(127.0.0.1:3306)/watch",
+		defaultClientTransport.poolConfig("trpc.mysql.test.watch").DSN)
+	require.NoError(t, (&Plugin{}).OnFinish(pluginName))
+}
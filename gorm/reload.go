@@ -0,0 +1,125 @@
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/config"
+	"trpc.group/trpc-go/trpc-go/log"
+)
+
+// poolDrainTimeout is the max time to wait for the in-use connections of a replaced pool before closing it.
+var poolDrainTimeout = time.Minute
+
+// Reload replaces the pool configurations without restarting the process.
+// The pool settings of the opened databases are changed in place, while the databases whose DSN or driver is changed
+// are replaced: new requests go to the new databases, and the old ones are closed after their in-use connections
+// are released. The transactions in progress are not affected, since their connections are released on commit
+// or rollback, even if the old database has been closed.
+func (ct *ClientTransport) Reload(defaultConfig PoolConfig, configs map[string]PoolConfig) {
+	ct.configLock.Lock()
+	oldDefault, oldConfigs := ct.DefaultPoolConfig, ct.PoolConfigs
+	ct.DefaultPoolConfig, ct.PoolConfigs = defaultConfig, configs
+	ct.configLock.Unlock()
+
+	var replaced []*sql.DB
+	ct.SQLDBLock.Lock()
+	for dsn, serviceName := range ct.dbServices {
+		db, ok := ct.SQLDB[dsn]
+		if !ok {
+			continue
+		}
+		oldConf, ok := oldConfigs[serviceName]
+		if !ok {
+			oldConf = oldDefault
+		}
+		conf := ct.poolConfig(serviceName)
+		switch {
+		case conf.DSN != oldConf.DSN || conf.DriverName != oldConf.DriverName:
+			delete(ct.SQLDB, dsn)
+			delete(ct.dbServices, dsn)
+			replaced = append(replaced, db)
+		case conf != oldConf:
+			setPool(db, conf)
+		}
+	}
+	ct.SQLDBLock.Unlock()
+
+	for _, db := range replaced {
+		go drainDB(db, poolDrainTimeout)
+	}
+}
+
+// drainDB closes the database after its in-use connections are released or timeout.
+func drainDB(db *sql.DB, timeout time.Duration) {
+	const interval = 100 * time.Millisecond
+	for deadline := time.Now().Add(timeout); db.Stats().InUse > 0 && time.Now().Before(deadline); {
+		time.Sleep(interval)
+	}
+	if err := db.Close(); err != nil {
+		log.Errorf("gorm: close replaced database error: %v", err)
+	}
+}
+
+// Watch loads the gorm plugin configuration by the key from the config center, and reloads the pool configurations
+// whenever it is changed, until ctx is done. Only the pool configurations, including the DSNs, are reloaded,
+// other configurations such as loggers and timeouts take effect only when the process restarts.
+// An invalid configuration is ignored and the current pool configurations are kept.
+func (ct *ClientTransport) Watch(ctx context.Context, kv config.KVConfig, key string) error {
+	rsp, err := kv.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("gorm: get config %s from %s error: %w", key, kv.Name(), err)
+	}
+	if err := ct.reloadFrom(rsp.Value()); err != nil {
+		return err
+	}
+	events, err := kv.Watch(ctx, key)
+	if err != nil {
+		return fmt.Errorf("gorm: watch config %s from %s error: %w", key, kv.Name(), err)
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rsp, ok := <-events:
+				if !ok {
+					return
+				}
+				if rsp.Event() != config.EventTypePut {
+					continue
+				}
+				if err := ct.reloadFrom(rsp.Value()); err != nil {
+					log.Errorf("gorm: reload config %s from %s error: %v", key, kv.Name(), err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadFrom reloads the pool configurations from the YAML of the gorm plugin configuration.
+func (ct *ClientTransport) reloadFrom(value string) error {
+	var c Config
+	if err := config.GetUnmarshaler("yaml").Unmarshal([]byte(value), &c); err != nil {
+		return fmt.Errorf("gorm: decode config error: %w", err)
+	}
+	ct.Reload(newPoolConfigs(&c))
+	return nil
+}
+
+// OnFinish starts watching the config center configured by watch after all plugins are set up,
+// since the config center is usually a plugin too.
+func (m *Plugin) OnFinish(name string) error {
+	if m.watch == nil {
+		return nil
+	}
+	kv := config.Get(m.watch.Config)
+	if kv == nil {
+		return errors.New("gorm: config center " + m.watch.Config + " is not registered")
+	}
+	return defaultClientTransport.Watch(context.Background(), kv, m.watch.Key)
+}
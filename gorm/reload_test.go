@@ -0,0 +1,157 @@
+package gorm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/config"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+type reloadUser struct {
+	ID   int
+	Name string
+}
+
+// newReloadTestDB creates a gorm.DB of the service using a new ClientTransport, and returns the DSNs of two databases,
+// the first one of which is selected by the target.
+func newReloadTestDB(t *testing.T, name string) (*gorm.DB, *ClientTransport, [2]string) {
+	drainTimeout := poolDrainTimeout
+	poolDrainTimeout = 5 * time.Second
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	t.Cleanup(func() {
+		poolDrainTimeout = drainTimeout
+		transport.RegisterClientTransport("gorm", defaultClientTransport)
+	})
+
+	dir := t.TempDir()
+	dsns := [2]string{"file:" + filepath.Join(dir, "a.db"), "file:" + filepath.Join(dir, "b.db")}
+	for _, dsn := range dsns {
+		db, err := NewClientProxy(name, client.WithTarget("dsn://"+dsn))
+		require.NoError(t, err)
+		require.NoError(t, db.AutoMigrate(&reloadUser{}))
+	}
+	db, err := NewClientProxy(name, client.WithTarget("dsn://"+dsns[0]))
+	require.NoError(t, err)
+	return db, ct, dsns
+}
+
+func TestClientTransport_Reload(t *testing.T) {
+	const name = "trpc.sqlite.test.reload"
+	db, ct, dsns := newReloadTestDB(t, name)
+	require.NoError(t, db.Create(&reloadUser{Name: "a"}).Error)
+	old := ct.SQLDB[dsns[0]]
+	require.NotNil(t, old)
+
+	// Pool settings are changed in place.
+	ct.Reload(PoolConfig{MaxIdle: 1, MaxOpen: 5}, map[string]PoolConfig{name: {MaxIdle: 1, MaxOpen: 3}})
+	require.Equal(t, 3, old.Stats().MaxOpenConnections)
+	require.Same(t, old, ct.SQLDB[dsns[0]])
+
+	// A transaction begun before the DSN is changed is still on the old database.
+	tx := db.Begin()
+	require.NoError(t, tx.Error)
+	ct.Reload(PoolConfig{MaxIdle: 1, MaxOpen: 5}, map[string]PoolConfig{name: {MaxIdle: 1, MaxOpen: 3, DSN: dsns[1]}})
+	require.NotContains(t, ct.SQLDB, dsns[0])
+	require.NoError(t, db.Create(&reloadUser{Name: "b"}).Error)
+	require.NoError(t, tx.Create(&reloadUser{Name: "tx"}).Error)
+	require.NoError(t, old.Ping())
+	require.NoError(t, tx.Commit().Error)
+
+	// The old database is closed after the transaction is done.
+	require.Eventually(t, func() bool {
+		return old.Ping() != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	var users []reloadUser
+	require.NoError(t, db.Order("id").Find(&users).Error)
+	require.Equal(t, []reloadUser{{ID: 1, Name: "b"}}, users)
+	olddb, err := NewClientProxy(name+".old", client.WithTarget("dsn://"+dsns[0]))
+	require.NoError(t, err)
+	require.NoError(t, olddb.Order("id").Find(&users).Error)
+	require.Equal(t, []reloadUser{{ID: 1, Name: "a"}, {ID: 2, Name: "tx"}}, users)
+
+	// Databases of other services are not affected.
+	ct.Reload(PoolConfig{MaxIdle: 1, MaxOpen: 5}, map[string]PoolConfig{name: {MaxIdle: 1, MaxOpen: 3}})
+	require.Contains(t, ct.SQLDB, dsns[0])
+	require.NotContains(t, ct.SQLDB, dsns[1])
+}
+
+type fakeKVConfig struct {
+	config.KV
+	value  string
+	err    error
+	events chan config.Response
+}
+
+func (c *fakeKVConfig) Name() string {
+	return "fake"
+}
+
+func (c *fakeKVConfig) Get(context.Context, string, ...config.Option) (config.Response, error) {
+	return &fakeResponse{value: c.value, event: config.EventTypePut}, c.err
+}
+
+func (c *fakeKVConfig) Watch(context.Context, string, ...config.Option) (<-chan config.Response, error) {
+	return c.events, nil
+}
+
+type fakeResponse struct {
+	value string
+	event config.EventType
+}
+
+func (r *fakeResponse) Value() string {
+	return r.value
+}
+
+func (r *fakeResponse) MetaData() map[string]string {
+	return nil
+}
+
+func (r *fakeResponse) Event() config.EventType {
+	return r.event
+}
+
+func TestClientTransport_Watch(t *testing.T) {
+	const name = "trpc.sqlite.test.watch"
+	db, ct, dsns := newReloadTestDB(t, name)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.Error(t, ct.Watch(ctx, &fakeKVConfig{err: errors.New("not found")}, "gorm"))
+	require.Error(t, ct.Watch(ctx, &fakeKVConfig{value: "max_idle: [1]"}, "gorm"))
+
+	kv := &fakeKVConfig{
+		value:  "max_open: 5\nservice:\n  - name: " + name + "\n    max_open: 3\n",
+		events: make(chan config.Response),
+	}
+	require.NoError(t, ct.Watch(ctx, kv, "gorm"))
+	require.Equal(t, 5, ct.DefaultPoolConfig.MaxOpen)
+	require.Equal(t, defaultMaxIdle, ct.DefaultPoolConfig.MaxIdle)
+	require.Equal(t, 3, ct.poolConfig(name).MaxOpen)
+
+	// Invalid configurations and deletions are ignored.
+	kv.events <- &fakeResponse{value: "max_idle: [1]", event: config.EventTypePut}
+	kv.events <- &fakeResponse{event: config.EventTypeDel}
+	require.Equal(t, 3, ct.poolConfig(name).MaxOpen)
+
+	kv.events <- &fakeResponse{
+		value: "service:\n  - name: " + name + "\n    dsn: " + dsns[1] + "\n",
+		event: config.EventTypePut,
+	}
+	require.Eventually(t, func() bool {
+		return ct.poolConfig(name).DSN == dsns[1]
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, db.Create(&reloadUser{Name: "b"}).Error)
+	ct.SQLDBLock.RLock()
+	require.Contains(t, ct.SQLDB, dsns[1])
+	ct.SQLDBLock.RUnlock()
+}
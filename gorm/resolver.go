@@ -0,0 +1,260 @@
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+// resolverName is the name of the Resolver plugin as well as its callbacks and clause.
+const resolverName = "trpc:db_resolver"
+
+// ResolverConfig is the configuration of Resolver.
+type ResolverConfig struct {
+	// Sources are the service names for writes and transactions.
+	// The connection pool of the gorm.DB is used as the source if it is empty.
+	Sources []string
+	// Replicas are the service names for reads. Reads go to the sources if it is empty.
+	Replicas []string
+}
+
+// Resolver is a GORM plugin for read/write splitting. Unlike gorm.io/plugin/dbresolver,
+// the sources and replicas are tRPC services built by NewConnPool,
+// so that every request goes through the tRPC call chain including filters, selectors and metrics.
+//
+// Queries (First, Find, Scan, Row and raw SELECT statements) are routed to the replicas in turn,
+// while writes, transactions and locking reads (SELECT ... FOR UPDATE) are routed to the sources.
+// Statements in a transaction always stay in the transaction.
+//
+//	db, err := gormplugin.NewClientProxy("trpc.mysql.app.primary")
+//	err = db.Use(gormplugin.NewResolver(gormplugin.ResolverConfig{
+//		Replicas: []string{"trpc.mysql.app.replica1", "trpc.mysql.app.replica2"},
+//	}))
+//	db.Clauses(gormplugin.UseSource).First(&user) // Reads from the source.
+type Resolver struct {
+	config     ResolverConfig
+	clientOpts []client.Option
+	sources    []gorm.ConnPool
+	replicas   []gorm.ConnPool
+	pool       *resolverPool
+	nextSource uint32
+	nextRepl   uint32
+}
+
+// NewResolver creates a Resolver, opts are applied to the connection pools of all sources and replicas.
+func NewResolver(config ResolverConfig, opts ...client.Option) *Resolver {
+	return &Resolver{config: config, clientOpts: opts}
+}
+
+// Name implements gorm.Plugin.
+func (r *Resolver) Name() string {
+	return resolverName
+}
+
+// Initialize implements gorm.Plugin, it replaces the connection pool of db and registers the callbacks.
+func (r *Resolver) Initialize(db *gorm.DB) error {
+	for _, name := range r.config.Sources {
+		r.sources = append(r.sources, NewConnPool(name, r.clientOpts...))
+	}
+	if len(r.sources) == 0 {
+		if db.ConnPool == nil {
+			return errors.New("gorm resolver: no source")
+		}
+		r.sources = append(r.sources, db.ConnPool)
+	}
+	for _, name := range r.config.Replicas {
+		r.replicas = append(r.replicas, NewConnPool(name, r.clientOpts...))
+	}
+
+	r.pool = &resolverPool{resolver: r}
+	db.ConnPool = r.pool
+	db.Statement.ConnPool = r.pool
+
+	if err := db.Callback().Query().Before("gorm:query").Register(resolverName, r.switchReplica); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register(resolverName, r.switchReplica); err != nil {
+		return err
+	}
+	// The statements of chained methods are reused, such as tx.Count(&n) followed by tx.Updates(...),
+	// so the connection pool switched to a replica by a query is switched back for the writes,
+	// before their default transactions begin.
+	if err := db.Callback().Create().Before("gorm:begin_transaction").
+		Register(resolverName, r.switchSource); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:begin_transaction").
+		Register(resolverName, r.switchSource); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:begin_transaction").
+		Register(resolverName, r.switchSource); err != nil {
+		return err
+	}
+	return db.Callback().Raw().Before("gorm:raw").Register(resolverName, r.switchSource)
+}
+
+// owns reports whether pool is routed by the resolver, rather than a transaction or set by others.
+func (r *Resolver) owns(pool gorm.ConnPool) bool {
+	if pool == r.pool {
+		return true
+	}
+	for _, pools := range [][]gorm.ConnPool{r.sources, r.replicas} {
+		for _, p := range pools {
+			if pool == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// switchSource routes the write to a source, unless it is in a transaction.
+func (r *Resolver) switchSource(db *gorm.DB) {
+	if db.Error != nil || !r.owns(db.Statement.ConnPool) {
+		return
+	}
+	db.Statement.ConnPool = r.source()
+}
+
+// switchReplica routes the query to a replica, unless it is in a transaction, a locking read,
+// or the source is required by the UseSource clause.
+func (r *Resolver) switchReplica(db *gorm.DB) {
+	if db.Error != nil || !r.owns(db.Statement.ConnPool) {
+		return
+	}
+	if c, ok := db.Statement.Clauses[resolverName]; ok {
+		if op, ok := c.Expression.(ResolverOperation); ok {
+			if op == UseSource {
+				db.Statement.ConnPool = r.source()
+			} else {
+				db.Statement.ConnPool = r.replica()
+			}
+			return
+		}
+	}
+	if _, locking := db.Statement.Clauses["FOR"]; locking {
+		db.Statement.ConnPool = r.source()
+		return
+	}
+	if db.Statement.SQL.Len() > 0 {
+		// Raw SQL, which is routed by resolverPool according to the statement.
+		db.Statement.ConnPool = r.pool
+		return
+	}
+	db.Statement.ConnPool = r.replica()
+}
+
+// source returns the next source in turn.
+func (r *Resolver) source() gorm.ConnPool {
+	if len(r.sources) == 1 {
+		return r.sources[0]
+	}
+	return r.sources[int(atomic.AddUint32(&r.nextSource, 1)-1)%len(r.sources)]
+}
+
+// replica returns the next replica in turn, or a source if there is no replica.
+func (r *Resolver) replica() gorm.ConnPool {
+	if len(r.replicas) == 0 {
+		return r.source()
+	}
+	return r.replicas[int(atomic.AddUint32(&r.nextRepl, 1)-1)%len(r.replicas)]
+}
+
+// route returns the source for writes and locking reads, and a replica for other reads.
+func (r *Resolver) route(query string) gorm.ConnPool {
+	q := strings.ToUpper(strings.TrimSpace(query))
+	if strings.HasPrefix(q, "SELECT") && !strings.Contains(q, " FOR UPDATE") && !strings.Contains(q, " FOR SHARE") &&
+		!strings.Contains(q, " LOCK IN SHARE MODE") {
+		return r.replica()
+	}
+	return r.source()
+}
+
+// ResolverOperation is a clause that chooses the source or the replicas explicitly.
+type ResolverOperation string
+
+const (
+	// UseSource routes the query to the source, such as reading right after writing.
+	UseSource ResolverOperation = "source"
+	// UseReplica routes the query to the replicas.
+	UseReplica ResolverOperation = "replica"
+)
+
+// ModifyStatement implements gorm.StatementModifier.
+func (op ResolverOperation) ModifyStatement(stmt *gorm.Statement) {
+	stmt.Clauses[resolverName] = clause.Clause{Name: "", Expression: op}
+}
+
+// Build implements clause.Expression, it builds nothing.
+func (op ResolverOperation) Build(clause.Builder) {}
+
+// resolverPool is the connection pool of the gorm.DB using Resolver.
+// It routes the statements that are not routed by the callbacks, such as Exec, raw SQL and transactions.
+type resolverPool struct {
+	resolver *Resolver
+}
+
+// PrepareContext implements gorm.ConnPool.
+func (p *resolverPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.resolver.route(query).PrepareContext(ctx, query)
+}
+
+// ExecContext implements gorm.ConnPool.
+func (p *resolverPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.resolver.source().ExecContext(ctx, query, args...)
+}
+
+// QueryContext implements gorm.ConnPool.
+func (p *resolverPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.resolver.route(query).QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext implements gorm.ConnPool.
+func (p *resolverPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.resolver.route(query).QueryRowContext(ctx, query, args...)
+}
+
+// BeginTx implements gorm.ConnPoolBeginner, transactions always begin on a source.
+func (p *resolverPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	switch beginner := p.resolver.source().(type) {
+	case gorm.ConnPoolBeginner:
+		return beginner.BeginTx(ctx, opts)
+	case gorm.TxBeginner:
+		return beginner.BeginTx(ctx, opts)
+	default:
+		return nil, gorm.ErrInvalidTransaction
+	}
+}
+
+// Ping pings all sources and replicas.
+func (p *resolverPool) Ping() error {
+	for _, pools := range [][]gorm.ConnPool{p.resolver.sources, p.resolver.replicas} {
+		for _, pool := range pools {
+			if pinger, ok := pool.(interface{ Ping() error }); ok {
+				if err := pinger.Ping(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetDBConn implements gorm.GetDBConnector, it returns the sql.DB of the first source.
+func (p *resolverPool) GetDBConn() (*sql.DB, error) {
+	switch pool := p.resolver.sources[0].(type) {
+	case gorm.GetDBConnector:
+		return pool.GetDBConn()
+	case *sql.DB:
+		return pool, nil
+	default:
+		return nil, gorm.ErrInvalidDB
+	}
+}
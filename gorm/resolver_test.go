@@ -0,0 +1,149 @@
+package gorm
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+type resolverUser struct {
+	ID   int
+	Name string
+}
+
+// newResolverTestDB creates a gorm.DB whose source and replicas are separated SQLite databases,
+// each of which has a user named after the database.
+func newResolverTestDB(t *testing.T, config ResolverConfig) *gorm.DB {
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	t.Cleanup(func() {
+		transport.RegisterClientTransport("gorm", defaultClientTransport)
+	})
+
+	dir := t.TempDir()
+	targets := make(map[string]string)
+	for _, name := range append([]string{"trpc.sqlite.test.primary"}, append(config.Sources, config.Replicas...)...) {
+		dsn := "file:" + filepath.Join(dir, name+".db")
+		db, err := sql.Open("sqlite3", dsn)
+		require.NoError(t, err)
+		_, err = db.Exec("CREATE TABLE resolver_users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)")
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO resolver_users (name) VALUES (?)", name)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+		targets[name] = "dsn://" + dsn
+	}
+	newConnPool := NewConnPool
+	NewConnPool = func(name string, opts ...client.Option) ConnPool {
+		return newConnPool(name, append(opts, client.WithTarget(targets[name]))...)
+	}
+	t.Cleanup(func() {
+		NewConnPool = newConnPool
+	})
+
+	db, err := NewClientProxy("trpc.sqlite.test.primary")
+	require.NoError(t, err)
+	require.NoError(t, db.Use(NewResolver(config)))
+	return db
+}
+
+func TestResolver(t *testing.T) {
+	const (
+		primary  = "trpc.sqlite.test.primary"
+		replica1 = "trpc.sqlite.test.replica1"
+		replica2 = "trpc.sqlite.test.replica2"
+	)
+	db := newResolverTestDB(t, ResolverConfig{Replicas: []string{replica1, replica2}})
+
+	var user resolverUser
+	require.NoError(t, db.First(&user).Error)
+	require.Equal(t, replica1, user.Name)
+	require.NoError(t, db.First(&user).Error)
+	require.Equal(t, replica2, user.Name)
+	var names []string
+	require.NoError(t, db.Raw("SELECT name FROM resolver_users").Scan(&names).Error)
+	require.Equal(t, []string{replica1}, names)
+
+	// Writes, locking reads and explicit reads from the source.
+	require.NoError(t, db.Create(&resolverUser{Name: "Jobs"}).Error)
+	var count int64
+	require.NoError(t, db.Clauses(UseSource).Model(&resolverUser{}).Count(&count).Error)
+	require.Equal(t, int64(2), count)
+	require.NoError(t, db.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&resolverUser{}).
+		Count(&count).Error)
+	require.Equal(t, int64(2), count)
+	require.NoError(t, db.Model(&resolverUser{}).Count(&count).Error)
+	require.Equal(t, int64(1), count, "replicas are not changed")
+	require.NoError(t, db.Exec("DELETE FROM resolver_users WHERE name = ?", primary).Error)
+
+	// Reads in a transaction stay in the transaction.
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var users []resolverUser
+		if err := tx.Find(&users).Error; err != nil {
+			return err
+		}
+		require.Equal(t, []resolverUser{{ID: 2, Name: "Jobs"}}, users)
+		return nil
+	})
+	require.NoError(t, err)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	require.NotNil(t, sqlDB)
+	require.NoError(t, sqlDB.Ping())
+}
+
+func TestResolver_Sources(t *testing.T) {
+	const source = "trpc.sqlite.test.source"
+	db := newResolverTestDB(t, ResolverConfig{Sources: []string{source}})
+
+	var user resolverUser
+	require.NoError(t, db.Clauses(UseReplica).First(&user).Error)
+	require.Equal(t, source, user.Name, "reads go to the sources without replicas")
+	require.NoError(t, db.Create(&resolverUser{Name: "Jobs"}).Error)
+	var names []string
+	require.NoError(t, db.Raw("SELECT name FROM resolver_users ORDER BY id").Scan(&names).Error)
+	require.Equal(t, []string{source, "Jobs"}, names)
+	require.NoError(t, db.ConnPool.(*resolverPool).Ping())
+}
+
+func TestResolver_ReusedStatement(t *testing.T) {
+	const (
+		primary = "trpc.sqlite.test.primary"
+		replica = "trpc.sqlite.test.replica"
+	)
+	db := newResolverTestDB(t, ResolverConfig{Replicas: []string{replica}})
+
+	// The statement is reused by the chained methods, whose writes go to the source after the reads.
+	tx := db.Model(&resolverUser{}).Where("id = ?", 1)
+	var count int64
+	require.NoError(t, tx.Count(&count).Error)
+	require.Equal(t, int64(1), count)
+	require.NoError(t, tx.Updates(map[string]interface{}{"name": "Updated"}).Error)
+	var names []string
+	require.NoError(t, db.Clauses(UseSource).Model(&resolverUser{}).Pluck("name", &names).Error)
+	require.Equal(t, []string{"Updated"}, names)
+	require.NoError(t, db.Model(&resolverUser{}).Pluck("name", &names).Error)
+	require.Equal(t, []string{replica}, names, "replicas are not changed")
+
+	tx = db.Model(&resolverUser{}).Where("id = ?", 1)
+	require.NoError(t, tx.Count(&count).Error)
+	require.NoError(t, tx.Delete(&resolverUser{}).Error)
+	require.NoError(t, db.Clauses(UseSource).Model(&resolverUser{}).Count(&count).Error)
+	require.Equal(t, int64(0), count)
+
+	tx = db.Model(&resolverUser{})
+	require.NoError(t, tx.Count(&count).Error)
+	require.NoError(t, tx.Create(&resolverUser{Name: primary}).Error)
+	require.NoError(t, tx.Exec("INSERT INTO resolver_users (name) VALUES (?)", primary).Error)
+	require.NoError(t, db.Clauses(UseSource).Model(&resolverUser{}).Count(&count).Error)
+	require.Equal(t, int64(2), count)
+	require.NoError(t, db.Model(&resolverUser{}).Count(&count).Error)
+	require.Equal(t, int64(1), count, "replicas are not changed")
+}
@@ -0,0 +1,560 @@
+package gorm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+// shardingName is the name of the Sharding plugin as well as its callbacks and clause.
+const shardingName = "trpc:sharding"
+
+var (
+	// ErrMissingShardingKey is returned when the sharding key of a sharded table is not found in the statement.
+	ErrMissingShardingKey = errors.New("gorm sharding: sharding key is missing")
+	// ErrCrossShard is returned when a statement or a transaction spans multiple shards.
+	ErrCrossShard = errors.New("gorm sharding: statement across shards is not supported")
+)
+
+// ShardingRule is the sharding rule of a logical table.
+type ShardingRule struct {
+	// Table is the logical table name, such as the table name of the model.
+	Table string `yaml:"table"`
+	// Key is the column of the sharding key.
+	Key string `yaml:"key"`
+	// Algorithm is modulo, range, hash or a registered one, modulo is used if it is empty.
+	Algorithm string `yaml:"algorithm"`
+	// Shards are the physical tables.
+	Shards []Shard `yaml:"shards"`
+}
+
+// Shard is a physical table of a logical table.
+type Shard struct {
+	// Service is the service name of the database,
+	// the connection pool of the gorm.DB is used if it is empty.
+	Service string `yaml:"service"`
+	// Table is the physical table name.
+	Table string `yaml:"table"`
+	// Min and Max are used by the range algorithm, the shard holds the keys in [Min, Max).
+	// Max 0 means no upper bound.
+	Min int64 `yaml:"min"`
+	Max int64 `yaml:"max"`
+}
+
+// ShardingAlgorithm returns the index in rule.Shards of the shard holding the value of the sharding key.
+type ShardingAlgorithm func(rule *ShardingRule, value interface{}) (int, error)
+
+var (
+	shardingAlgorithmsLock sync.RWMutex
+	// shardingAlgorithms are the sharding algorithms keyed by name.
+	shardingAlgorithms = map[string]ShardingAlgorithm{
+		"modulo": moduloSharding,
+		"range":  rangeSharding,
+		"hash":   hashSharding,
+	}
+	// shardingRules are the sharding rules in the plugin configuration.
+	shardingRules []ShardingRule
+)
+
+// RegisterShardingAlgorithm registers a sharding algorithm, which can be used by ShardingRule.Algorithm.
+// The built-in algorithms are modulo, range and hash, which can be overridden.
+// It should be called before the plugin is set up, usually in init.
+func RegisterShardingAlgorithm(name string, f ShardingAlgorithm) {
+	shardingAlgorithmsLock.Lock()
+	defer shardingAlgorithmsLock.Unlock()
+	shardingAlgorithms[name] = f
+}
+
+func getShardingAlgorithm(name string) (ShardingAlgorithm, bool) {
+	if name == "" {
+		name = "modulo"
+	}
+	shardingAlgorithmsLock.RLock()
+	defer shardingAlgorithmsLock.RUnlock()
+	f, ok := shardingAlgorithms[name]
+	return f, ok
+}
+
+// moduloSharding routes the integer key to the shard key % len(shards).
+func moduloSharding(rule *ShardingRule, value interface{}) (int, error) {
+	v, err := toInt64(value)
+	if err != nil {
+		return 0, err
+	}
+	n := int64(len(rule.Shards))
+	return int((v%n + n) % n), nil
+}
+
+// rangeSharding routes the integer key to the shard whose [Min, Max) contains it.
+func rangeSharding(rule *ShardingRule, value interface{}) (int, error) {
+	v, err := toInt64(value)
+	if err != nil {
+		return 0, err
+	}
+	for i, shard := range rule.Shards {
+		if v >= shard.Min && (shard.Max == 0 || v < shard.Max) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("gorm sharding: no shard of table %s for key %d", rule.Table, v)
+}
+
+// hashSharding routes the key to the shard FNV-1a(key) % len(shards), the key can be of any type.
+func hashSharding(rule *ShardingRule, value interface{}) (int, error) {
+	if valuer, ok := value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return 0, err
+		}
+		value = v
+	}
+	h := fnv.New32a()
+	if b, ok := value.([]byte); ok {
+		h.Write(b)
+	} else {
+		h.Write([]byte(fmt.Sprint(value)))
+	}
+	return int(h.Sum32() % uint32(len(rule.Shards))), nil
+}
+
+// toInt64 converts the value of the sharding key to int64.
+func toInt64(value interface{}) (int64, error) {
+	if valuer, ok := value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return 0, err
+		}
+		value = v
+	}
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	case reflect.String:
+		return strconv.ParseInt(rv.String(), 10, 64)
+	default:
+		return 0, fmt.Errorf("gorm sharding: sharding key %v of type %T is not an integer", value, value)
+	}
+}
+
+// validate checks the rule.
+func (rule *ShardingRule) validate() error {
+	if rule.Table == "" || rule.Key == "" {
+		return errors.New("gorm sharding: table and key are required")
+	}
+	if len(rule.Shards) == 0 {
+		return fmt.Errorf("gorm sharding: no shard of table %s", rule.Table)
+	}
+	for _, shard := range rule.Shards {
+		if shard.Table == "" {
+			return fmt.Errorf("gorm sharding: physical table of %s is required", rule.Table)
+		}
+	}
+	if _, ok := getShardingAlgorithm(rule.Algorithm); !ok {
+		return fmt.Errorf("gorm sharding: unknown algorithm %s of table %s", rule.Algorithm, rule.Table)
+	}
+	return nil
+}
+
+// Sharding is a GORM plugin which routes the statements of logical tables to physical tables and databases.
+// The shard is chosen by the value of the sharding key, which is taken from the ShardKey clause,
+// the WHERE conditions like `key = ?` and `key IN ?`, or the model for Create, Update and Delete.
+// The statements which can not be routed to a single shard are rejected with ErrMissingShardingKey or ErrCrossShard.
+//
+//	db, err := gormplugin.NewClientProxy("trpc.mysql.app.order0")
+//	err = db.Use(gormplugin.NewSharding(nil)) // Uses the rules in the plugin configuration.
+//	db.Where("user_id = ?", 10).Find(&orders)
+//	db.Clauses(gormplugin.ShardKey(10)).Raw("SELECT count(*) FROM orders").Scan(&count)
+type Sharding struct {
+	rules      []ShardingRule
+	clientOpts []client.Option
+	tables     map[string]*shardingTable
+	base       gorm.ConnPool
+	pools      map[string]gorm.ConnPool
+}
+
+// shardingTable is the rule of a logical table with its table name pattern.
+type shardingTable struct {
+	*ShardingRule
+	algorithm ShardingAlgorithm
+	pattern   *regexp.Regexp
+}
+
+// NewSharding creates a Sharding by the rules, or by the rules in the plugin configuration if rules is empty.
+// opts are applied to the connection pools of the services of the shards.
+func NewSharding(rules []ShardingRule, opts ...client.Option) *Sharding {
+	return &Sharding{rules: rules, clientOpts: opts}
+}
+
+// Name implements gorm.Plugin.
+func (s *Sharding) Name() string {
+	return shardingName
+}
+
+// Initialize implements gorm.Plugin, it creates the connection pools of the shards and registers the callbacks.
+func (s *Sharding) Initialize(db *gorm.DB) error {
+	rules := s.rules
+	if len(rules) == 0 {
+		rules = shardingRules
+	}
+	s.base = db.ConnPool
+	s.tables = make(map[string]*shardingTable, len(rules))
+	s.pools = make(map[string]gorm.ConnPool)
+	for i := range rules {
+		rule := &rules[i]
+		if err := rule.validate(); err != nil {
+			return err
+		}
+		algorithm, _ := getShardingAlgorithm(rule.Algorithm)
+		s.tables[rule.Table] = &shardingTable{
+			ShardingRule: rule,
+			algorithm:    algorithm,
+			pattern:      regexp.MustCompile(`\b` + regexp.QuoteMeta(rule.Table) + `\b`),
+		}
+		for _, shard := range rule.Shards {
+			if _, ok := s.pools[shard.Service]; !ok && shard.Service != "" {
+				s.pools[shard.Service] = NewConnPool(shard.Service, s.clientOpts...)
+			}
+		}
+	}
+
+	// The callbacks run first, so that the default transaction of GORM begins on the database of the shard.
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("*").Register(shardingName, s.routeWrite),
+		callbacks.Update().Before("*").Register(shardingName, s.routeWrite),
+		callbacks.Delete().Before("*").Register(shardingName, s.routeWrite),
+		callbacks.Query().Before("*").Register(shardingName, s.route),
+		callbacks.Row().Before("*").Register(shardingName, s.route),
+		callbacks.Raw().Before("*").Register(shardingName, s.route),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routeWrite routes Create, Update and Delete, whose model may hold the sharding key.
+func (s *Sharding) routeWrite(db *gorm.DB) {
+	s.doRoute(db, true)
+}
+
+// route routes queries and raw statements.
+func (s *Sharding) route(db *gorm.DB) {
+	s.doRoute(db, false)
+}
+
+func (s *Sharding) doRoute(db *gorm.DB, fromModel bool) {
+	if db.Error != nil {
+		return
+	}
+	stmt := db.Statement
+	if stmt.SQL.Len() > 0 {
+		// Raw SQL, whose logical tables are rewritten directly.
+		if err := s.routeRaw(stmt); err != nil {
+			db.AddError(err)
+		}
+		return
+	}
+	table, ok := s.tables[stmt.Table]
+	if !ok {
+		return
+	}
+	shard, err := s.shardOf(stmt, table, fromModel)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if err := s.usePool(stmt, shard); err != nil {
+		db.AddError(err)
+		return
+	}
+	stmt.Table = shard.Table
+	if stmt.TableExpr != nil {
+		stmt.TableExpr = &clause.Expr{
+			SQL:  table.pattern.ReplaceAllLiteralString(stmt.TableExpr.SQL, shard.Table),
+			Vars: stmt.TableExpr.Vars,
+		}
+	}
+	rewriteWhere(stmt, table, shard.Table)
+}
+
+// routeRaw routes the raw SQL by the ShardKey clause, all the logical tables in it must be in the same database.
+func (s *Sharding) routeRaw(stmt *gorm.Statement) error {
+	var tables []*shardingTable
+	for _, table := range s.tables {
+		if table.pattern.MatchString(stmt.SQL.String()) {
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Table < tables[j].Table })
+	values, ok := shardKeyOf(stmt)
+	if !ok {
+		return fmt.Errorf("%w: the ShardKey clause is required by raw SQL of table %s", ErrMissingShardingKey,
+			tables[0].Table)
+	}
+	sql := stmt.SQL.String()
+	var service string
+	for i, table := range tables {
+		shard, err := table.shardOf(values)
+		if err != nil {
+			return err
+		}
+		if i > 0 && shard.Service != service {
+			return fmt.Errorf("%w: tables are in different databases", ErrCrossShard)
+		}
+		service = shard.Service
+		if i == 0 {
+			if err := s.usePool(stmt, shard); err != nil {
+				return err
+			}
+		}
+		sql = table.pattern.ReplaceAllLiteralString(sql, shard.Table)
+	}
+	stmt.SQL.Reset()
+	stmt.SQL.WriteString(sql)
+	return nil
+}
+
+// shardOf returns the shard of the statement on the logical table.
+func (s *Sharding) shardOf(stmt *gorm.Statement, table *shardingTable, fromModel bool) (*Shard, error) {
+	values, ok := shardKeyOf(stmt)
+	if !ok {
+		values, ok = whereValuesOf(stmt, table.Key)
+	}
+	if !ok && fromModel {
+		values, ok = modelValuesOf(stmt, table.Key)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: table %s, key %s", ErrMissingShardingKey, table.Table, table.Key)
+	}
+	return table.shardOf(values)
+}
+
+// shardOf returns the shard holding all the values, or ErrCrossShard if they are in different shards.
+func (table *shardingTable) shardOf(values []interface{}) (*Shard, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: table %s, key %s", ErrMissingShardingKey, table.Table, table.Key)
+	}
+	index := -1
+	for _, value := range values {
+		i, err := table.algorithm(table.ShardingRule, value)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= len(table.Shards) {
+			return nil, fmt.Errorf("gorm sharding: shard index %d of table %s out of range", i, table.Table)
+		}
+		if index != -1 && i != index {
+			return nil, fmt.Errorf("%w: table %s", ErrCrossShard, table.Table)
+		}
+		index = i
+	}
+	return &table.Shards[index], nil
+}
+
+// usePool sets the connection pool of the statement to the database of the shard.
+// A transaction is kept if it is on the same database, otherwise ErrCrossShard is returned.
+func (s *Sharding) usePool(stmt *gorm.Statement, shard *Shard) error {
+	pool := s.base
+	if shard.Service != "" {
+		pool = s.pools[shard.Service]
+	}
+	if _, ok := stmt.ConnPool.(gorm.TxCommitter); ok {
+		if txService, service := serviceOf(stmt.ConnPool), serviceOf(pool); txService != "" && service != "" && txService != service {
+			return fmt.Errorf("%w: the transaction is on %s, but table %s is on %s", ErrCrossShard,
+				txService, shard.Table, service)
+		}
+		return nil
+	}
+	stmt.ConnPool = pool
+	return nil
+}
+
+// serviceOf returns the service name of the connection pool, or empty if it is unknown.
+func serviceOf(pool gorm.ConnPool) string {
+	switch p := pool.(type) {
+	case *Client:
+		return p.ServiceName
+	case *TxClient:
+		return p.Client.ServiceName
+	default:
+		return ""
+	}
+}
+
+// shardKeyOf returns the values of the ShardKey clause.
+func shardKeyOf(stmt *gorm.Statement) ([]interface{}, bool) {
+	if c, ok := stmt.Clauses[shardingName]; ok {
+		if key, ok := c.Expression.(ShardingKey); ok {
+			return []interface{}{key.Value}, true
+		}
+	}
+	return nil, false
+}
+
+// keyExprPattern matches the conditions `key = ?` and `key IN ?` with an optional table qualifier and quotes.
+var keyExprPattern = regexp.MustCompile("(?i)^\\s*(?:[`\"]?\\w+[`\"]?\\.)?[`\"]?(\\w+)[`\"]?\\s*(=|IN)\\s*\\(?\\s*\\?\\s*\\)?\\s*$")
+
+// whereValuesOf returns the values of the key in the top-level conditions of the WHERE clause,
+// the conditions in OR are ignored since they can't narrow down the shards.
+func whereValuesOf(stmt *gorm.Statement, key string) ([]interface{}, bool) {
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return nil, false
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return nil, false
+	}
+	for _, expr := range where.Exprs {
+		switch e := expr.(type) {
+		case clause.Eq:
+			if columnName(e.Column) == key {
+				return []interface{}{e.Value}, true
+			}
+		case clause.IN:
+			if columnName(e.Column) == key {
+				return e.Values, true
+			}
+		case clause.Expr:
+			m := keyExprPattern.FindStringSubmatch(e.SQL)
+			if m == nil || !strings.EqualFold(m[1], key) || len(e.Vars) != 1 {
+				continue
+			}
+			if strings.EqualFold(m[2], "IN") {
+				return expandValues(e.Vars[0]), true
+			}
+			return e.Vars, true
+		}
+	}
+	return nil, false
+}
+
+// modelValuesOf returns the non-zero values of the key in the model, such as the records to create.
+func modelValuesOf(stmt *gorm.Statement, key string) ([]interface{}, bool) {
+	if stmt.Schema == nil || !stmt.ReflectValue.IsValid() {
+		return nil, false
+	}
+	field := stmt.Schema.LookUpField(key)
+	if field == nil {
+		return nil, false
+	}
+	rv := stmt.ReflectValue
+	if rv.Kind() == reflect.Map && stmt.Model != nil {
+		// Update with a map or a column, such as db.Model(&order).Update("amount", 1).
+		rv = reflect.Indirect(reflect.ValueOf(stmt.Model))
+	}
+	var values []interface{}
+	add := func(rv reflect.Value) bool {
+		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+			rv = rv.Elem()
+		}
+		if rv.Kind() != reflect.Struct {
+			return false
+		}
+		value, zero := field.ValueOf(stmt.Context, rv)
+		if zero {
+			return false
+		}
+		values = append(values, value)
+		return true
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			// Every record must have the key, otherwise it can't be routed.
+			if !add(rv.Index(i)) {
+				return nil, false
+			}
+		}
+	default:
+		add(rv)
+	}
+	return values, len(values) > 0
+}
+
+// expandValues expands the slice of `key IN ?`.
+func expandValues(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []interface{}{v}
+	}
+	values := make([]interface{}, rv.Len())
+	for i := range values {
+		values[i] = rv.Index(i).Interface()
+	}
+	return values
+}
+
+func columnName(column interface{}) string {
+	switch c := column.(type) {
+	case clause.Column:
+		return c.Name
+	case string:
+		return c
+	default:
+		return ""
+	}
+}
+
+// rewriteWhere rewrites the logical table qualifiers in the raw conditions, such as `orders.user_id = ?`.
+func rewriteWhere(stmt *gorm.Statement, table *shardingTable, physical string) {
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return
+	}
+	exprs := make([]clause.Expression, len(where.Exprs))
+	for i, expr := range where.Exprs {
+		if e, ok := expr.(clause.Expr); ok {
+			e.SQL = table.pattern.ReplaceAllLiteralString(e.SQL, physical)
+			expr = e
+		}
+		exprs[i] = expr
+	}
+	c.Expression = clause.Where{Exprs: exprs}
+	stmt.Clauses["WHERE"] = c
+}
+
+// ShardingKey is a clause that sets the value of the sharding key explicitly,
+// which is required by raw SQL and queries without the key in the conditions.
+type ShardingKey struct {
+	Value interface{}
+}
+
+// ShardKey returns a ShardingKey clause.
+func ShardKey(value interface{}) ShardingKey {
+	return ShardingKey{Value: value}
+}
+
+// ModifyStatement implements gorm.StatementModifier.
+func (key ShardingKey) ModifyStatement(stmt *gorm.Statement) {
+	stmt.Clauses[shardingName] = clause.Clause{Name: "", Expression: key}
+}
+
+// Build implements clause.Expression, it builds nothing.
+func (key ShardingKey) Build(clause.Builder) {}
@@ -0,0 +1,234 @@
+package gorm
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+type shardingOrder struct {
+	ID     int
+	UserID int
+	Amount int
+}
+
+func (shardingOrder) TableName() string {
+	return "orders"
+}
+
+const (
+	shard0 = "trpc.sqlite.test.shard0"
+	shard1 = "trpc.sqlite.test.shard1"
+)
+
+// shardingTestRules shards orders by user_id % 4, orders_0 and orders_2 are in shard0, the others are in shard1.
+var shardingTestRules = []ShardingRule{{
+	Table: "orders",
+	Key:   "user_id",
+	Shards: []Shard{
+		{Table: "orders_0"},
+		{Service: shard1, Table: "orders_1"},
+		{Service: shard0, Table: "orders_2"},
+		{Service: shard1, Table: "orders_3"},
+	},
+}}
+
+// newShardingTestDB creates a gorm.DB of shard0 using Sharding, and returns the sql.DB of each shard.
+func newShardingTestDB(t *testing.T, rules []ShardingRule) (*gorm.DB, map[string]*sql.DB) {
+	ct := NewClientTransport()
+	transport.RegisterClientTransport("gorm", ct)
+	t.Cleanup(func() {
+		transport.RegisterClientTransport("gorm", defaultClientTransport)
+	})
+
+	dir := t.TempDir()
+	targets := make(map[string]string)
+	dbs := make(map[string]*sql.DB)
+	for name, tables := range map[string][]string{
+		shard0: {"orders_0", "orders_2", "users"},
+		shard1: {"orders_1", "orders_3"},
+	} {
+		dsn := "file:" + filepath.Join(dir, name+".db")
+		db, err := sql.Open("sqlite3", dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		for _, table := range tables {
+			_, err = db.Exec("CREATE TABLE " + table +
+				" (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, amount INTEGER)")
+			require.NoError(t, err)
+		}
+		targets[name] = "dsn://" + dsn
+		dbs[name] = db
+	}
+	newConnPool := NewConnPool
+	NewConnPool = func(name string, opts ...client.Option) ConnPool {
+		return newConnPool(name, append(opts, client.WithTarget(targets[name]))...)
+	}
+	t.Cleanup(func() {
+		NewConnPool = newConnPool
+	})
+
+	db, err := NewClientProxy(shard0)
+	require.NoError(t, err)
+	require.NoError(t, db.Use(NewSharding(rules)))
+	return db, dbs
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	var n int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM "+table).Scan(&n))
+	return n
+}
+
+func TestSharding(t *testing.T) {
+	db, dbs := newShardingTestDB(t, shardingTestRules)
+
+	// Create is routed by the model.
+	require.NoError(t, db.Create(&shardingOrder{UserID: 5, Amount: 1}).Error)
+	require.Equal(t, 1, countRows(t, dbs[shard1], "orders_1"))
+	require.NoError(t, db.Create(&[]shardingOrder{{UserID: 2}, {UserID: 6}}).Error)
+	require.Equal(t, 2, countRows(t, dbs[shard0], "orders_2"))
+	require.NoError(t, db.Create(&shardingOrder{UserID: 4}).Error)
+	require.Equal(t, 1, countRows(t, dbs[shard0], "orders_0"))
+	require.ErrorIs(t, db.Create(&[]shardingOrder{{UserID: 1}, {UserID: 2}}).Error, ErrCrossShard)
+	require.ErrorIs(t, db.Create(&shardingOrder{}).Error, ErrMissingShardingKey)
+
+	// Queries are routed by the conditions.
+	var orders []shardingOrder
+	require.NoError(t, db.Where("user_id = ?", 5).Find(&orders).Error)
+	require.Equal(t, []shardingOrder{{ID: 1, UserID: 5, Amount: 1}}, orders)
+	require.NoError(t, db.Where(&shardingOrder{UserID: 6}).Find(&orders).Error)
+	require.Equal(t, []shardingOrder{{ID: 2, UserID: 6}}, orders)
+	require.NoError(t, db.Where("orders.user_id IN ?", []int{2, 6}).Order("id").Find(&orders).Error)
+	require.Len(t, orders, 2)
+	require.NoError(t, db.Where(map[string]interface{}{"user_id": []int{1, 5}}).Find(&orders).Error)
+	require.Len(t, orders, 1)
+	var order shardingOrder
+	require.NoError(t, db.First(&order, "user_id = ?", 4).Error)
+	require.Equal(t, 4, order.UserID)
+	require.ErrorIs(t, db.Where("user_id IN ?", []int{1, 2}).Find(&orders).Error, ErrCrossShard)
+	require.ErrorIs(t, db.Find(&orders).Error, ErrMissingShardingKey)
+	require.ErrorIs(t, db.Where("user_id = ? OR amount = ?", 5, 1).Find(&orders).Error, ErrMissingShardingKey)
+	var n int64
+	require.NoError(t, db.Model(&shardingOrder{}).Clauses(ShardKey(2)).Count(&n).Error)
+	require.Equal(t, int64(2), n)
+
+	// Update and Delete are routed by the conditions or the model.
+	require.NoError(t, db.Model(&shardingOrder{}).Where("user_id = ?", 5).Update("amount", 10).Error)
+	require.NoError(t, db.Model(&shardingOrder{ID: 1, UserID: 5}).Update("amount", 11).Error)
+	require.NoError(t, db.First(&order, "user_id = ?", 5).Error)
+	require.Equal(t, 11, order.Amount)
+	require.NoError(t, db.Delete(&shardingOrder{ID: 1, UserID: 5}).Error)
+	require.Equal(t, 0, countRows(t, dbs[shard1], "orders_1"))
+	require.ErrorIs(t, db.Delete(&shardingOrder{ID: 2}).Error, ErrMissingShardingKey)
+
+	// Raw SQL requires the ShardKey clause.
+	require.NoError(t, db.Clauses(ShardKey(2)).Raw("SELECT count(*) FROM `orders` WHERE orders.amount = ?", 0).
+		Scan(&n).Error)
+	require.Equal(t, int64(2), n)
+	require.NoError(t, db.Clauses(ShardKey(3)).Exec("INSERT INTO orders (user_id) VALUES (?)", 3).Error)
+	require.Equal(t, 1, countRows(t, dbs[shard1], "orders_3"))
+	require.ErrorIs(t, db.Raw("SELECT count(*) FROM orders").Scan(&n).Error, ErrMissingShardingKey)
+
+	// Tables not sharded are not affected.
+	require.NoError(t, db.Exec("INSERT INTO users (user_id) VALUES (1)").Error)
+	require.NoError(t, db.Table("users").Count(&n).Error)
+	require.Equal(t, int64(1), n)
+}
+
+func TestSharding_Transaction(t *testing.T) {
+	db, dbs := newShardingTestDB(t, shardingTestRules)
+
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&shardingOrder{UserID: 2}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&shardingOrder{UserID: 4}).Error
+	}))
+	require.Equal(t, 1, countRows(t, dbs[shard0], "orders_2"))
+	require.Equal(t, 1, countRows(t, dbs[shard0], "orders_0"))
+
+	// The transaction is on shard0, while orders_1 is on shard1.
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&shardingOrder{UserID: 1}).Error
+	})
+	require.ErrorIs(t, err, ErrCrossShard)
+	require.Equal(t, 0, countRows(t, dbs[shard1], "orders_1"))
+}
+
+func TestSharding_Config(t *testing.T) {
+	defer func() { shardingRules = nil }()
+	shardingRules = []ShardingRule{{
+		Table:     "orders",
+		Key:       "user_id",
+		Algorithm: "range",
+		Shards: []Shard{
+			{Service: shard1, Table: "orders_1", Max: 100},
+			{Table: "orders_2", Min: 100},
+		},
+	}}
+	db, dbs := newShardingTestDB(t, nil)
+	require.NoError(t, db.Create(&shardingOrder{UserID: 99}).Error)
+	require.NoError(t, db.Create(&shardingOrder{UserID: 100}).Error)
+	require.Equal(t, 1, countRows(t, dbs[shard1], "orders_1"))
+	require.Equal(t, 1, countRows(t, dbs[shard0], "orders_2"))
+
+	require.Error(t, (&gorm.DB{Config: &gorm.Config{}}).Use(NewSharding([]ShardingRule{{Table: "orders"}})))
+}
+
+func TestShardingAlgorithms(t *testing.T) {
+	rule := &ShardingRule{Table: "t", Key: "k", Shards: make([]Shard, 4)}
+	for value, want := range map[interface{}]int{5: 1, int64(-5): 3, uint8(8): 0, "7": 3} {
+		i, err := moduloSharding(rule, value)
+		require.NoError(t, err)
+		require.Equal(t, want, i, value)
+	}
+	_, err := moduloSharding(rule, "x")
+	require.Error(t, err)
+	_, err = moduloSharding(rule, 1.5)
+	require.Error(t, err)
+	v := int32(6)
+	i, err := moduloSharding(rule, &v)
+	require.NoError(t, err)
+	require.Equal(t, 2, i)
+
+	rule.Shards = []Shard{{Min: 0, Max: 10}, {Min: 10, Max: 20}, {Min: 30}}
+	for value, want := range map[int]int{0: 0, 9: 0, 10: 1, 19: 1, 30: 2, 1 << 40: 2} {
+		i, err := rangeSharding(rule, value)
+		require.NoError(t, err)
+		require.Equal(t, want, i, value)
+	}
+	_, err = rangeSharding(rule, 25)
+	require.Error(t, err)
+	_, err = rangeSharding(rule, -1)
+	require.Error(t, err)
+
+	i, err = hashSharding(rule, "alice")
+	require.NoError(t, err)
+	j, err := hashSharding(rule, "alice")
+	require.NoError(t, err)
+	require.Equal(t, i, j)
+	require.True(t, i >= 0 && i < 3)
+	j, err = hashSharding(rule, sql.NullString{String: "alice", Valid: true})
+	require.NoError(t, err)
+	require.Equal(t, i, j)
+
+	RegisterShardingAlgorithm("first", func(*ShardingRule, interface{}) (int, error) { return 0, nil })
+	defer func() {
+		shardingAlgorithmsLock.Lock()
+		delete(shardingAlgorithms, "first")
+		shardingAlgorithmsLock.Unlock()
+	}()
+	rule.Shards = []Shard{{Table: "t_0"}}
+	rule.Algorithm = "first"
+	require.NoError(t, rule.validate())
+	rule.Algorithm = "unknown"
+	require.Error(t, rule.validate())
+	require.Error(t, (&ShardingRule{Table: "t", Key: "k"}).validate())
+	require.Error(t, (&ShardingRule{Table: "t", Key: "k", Shards: []Shard{{}}}).validate())
+}
@@ -0,0 +1,20 @@
+// Package sqlite registers the SQLite dialector for the service names like trpc.sqlite.xxx.xxx,
+// which requires cgo. Import it for its side effects:
+//
+//	import _ "trpc.group/trpc-go/trpc-database/gorm/sqlite"
+package sqlite
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	tgorm "trpc.group/trpc-go/trpc-database/gorm"
+)
+
+func init() {
+	tgorm.RegisterDialector("sqlite", New)
+}
+
+// New creates the SQLite dialector which sends all requests through connPool.
+func New(connPool tgorm.ConnPool) gorm.Dialector {
+	return &sqlite.Dialector{Conn: connPool}
+}
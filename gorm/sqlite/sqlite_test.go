@@ -0,0 +1,26 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tgorm "trpc.group/trpc-go/trpc-database/gorm"
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+func TestNewClientProxy(t *testing.T) {
+	type User struct {
+		ID   int
+		Name string
+	}
+	dsn := "file:" + filepath.Join(t.TempDir(), "gorm.db")
+	db, err := tgorm.NewClientProxy("trpc.sqlite.test.db", client.WithTarget("dsn://"+dsn))
+	require.NoError(t, err)
+	require.Equal(t, "sqlite", db.Dialector.Name())
+	require.NoError(t, db.AutoMigrate(&User{}))
+	require.NoError(t, db.Create(&User{Name: "Jobs"}).Error)
+	var user User
+	require.NoError(t, db.First(&user).Error)
+	require.Equal(t, User{ID: 1, Name: "Jobs"}, user)
+}
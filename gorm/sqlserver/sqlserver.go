@@ -0,0 +1,20 @@
+// Package sqlserver registers the SQL Server dialector for the service names like trpc.sqlserver.xxx.xxx.
+// Import it for its side effects:
+//
+//	import _ "trpc.group/trpc-go/trpc-database/gorm/sqlserver"
+package sqlserver
+
+import (
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+	tgorm "trpc.group/trpc-go/trpc-database/gorm"
+)
+
+func init() {
+	tgorm.RegisterDialector("sqlserver", New)
+}
+
+// New creates the SQL Server dialector which sends all requests through connPool.
+func New(connPool tgorm.ConnPool) gorm.Dialector {
+	return sqlserver.New(sqlserver.Config{Conn: connPool})
+}
@@ -0,0 +1,12 @@
+package sqlserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tgorm "trpc.group/trpc-go/trpc-database/gorm"
+)
+
+func TestNew(t *testing.T) {
+	require.Equal(t, "sqlserver", New(tgorm.NewConnPool("trpc.sqlserver.test.db")).Name())
+}
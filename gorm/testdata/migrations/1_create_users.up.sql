@@ -0,0 +1,6 @@
+-- Users of the app; the name is unique.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE
+);
+INSERT INTO users (name) VALUES ('Jobs;Woz');
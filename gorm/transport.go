@@ -32,6 +32,8 @@ type PoolConfig struct {
 	MaxOpen     int
 	MaxLifetime time.Duration
 	DriverName  string
+	// DSN overrides the address selected by the target of the client if it is not empty.
+	DSN string
 }
 
 // ClientTransport is a struct that implements the trpc ClientTransport interface
@@ -44,6 +46,10 @@ type ClientTransport struct {
 	sfg               singleflight.Group
 	DefaultPoolConfig PoolConfig
 	PoolConfigs       map[string]PoolConfig
+	// configLock guards DefaultPoolConfig and PoolConfigs, which may be changed by Reload.
+	configLock sync.RWMutex
+	// dbServices are the names of the services which open the databases in SQLDB, keyed by DSN.
+	dbServices map[string]string
 }
 
 // defaultClientTransport is the default client transport.
@@ -108,22 +114,43 @@ func (ct *ClientTransport) RoundTrip(ctx context.Context, reqBuf []byte,
 	for _, o := range callOpts {
 		o(sqlOpts)
 	}
-	withCommonMetaCalleeAppServer(msg, ct.getDBType(msg.CalleeServiceName()), mask(sqlOpts.Address))
+	address := ct.dsn(msg.CalleeServiceName(), sqlOpts.Address)
+	withCommonMetaCalleeAppServer(msg, getDBType(msg.CalleeServiceName()), mask(address))
 
-	err = ct.getDBAndRunCommand(ctx, sqlOpts.Address, req, rsp)
+	err = ct.getDBAndRunCommand(ctx, address, req, rsp)
 	if err == nil {
 		return
 	}
 
-	// DB might be closed by gorm.io/gorm. If the failure is due to the closure of DB, recreate DB and retry.
+	// DB might be closed by gorm.io/gorm or replaced by Reload.
+	// If the failure is due to the closure of DB, recreate DB and retry.
 	// internal repository issues/525
 	if isDBClosedErr(err) {
-		ct.deleteDB(sqlOpts.Address)
-		err = ct.getDBAndRunCommand(ctx, sqlOpts.Address, req, rsp)
+		ct.deleteDB(address)
+		err = ct.getDBAndRunCommand(ctx, ct.dsn(msg.CalleeServiceName(), sqlOpts.Address), req, rsp)
 	}
 	return
 }
 
+// dsn returns the DSN of the service, which is the one in the pool configuration if it is set,
+// otherwise the address selected by the target.
+func (ct *ClientTransport) dsn(serviceName, address string) string {
+	if conf := ct.poolConfig(serviceName); conf.DSN != "" {
+		return conf.DSN
+	}
+	return address
+}
+
+// poolConfig returns the pool configuration of the service, or the default one if it is not configured.
+func (ct *ClientTransport) poolConfig(serviceName string) PoolConfig {
+	ct.configLock.RLock()
+	defer ct.configLock.RUnlock()
+	if conf, ok := ct.PoolConfigs[serviceName]; ok {
+		return conf
+	}
+	return ct.DefaultPoolConfig
+}
+
 func (ct *ClientTransport) getDBAndRunCommand(ctx context.Context, address string, req *Request, rsp *Response) error {
 	// If a new type of database is added, the database type needs to be passed in here.
 	// The CalleeServcieName can be read from sqlOpts.Msg,
@@ -279,15 +306,13 @@ func (ct *ClientTransport) GetDB(serviceName, dsn string) (*sql.DB, error) {
 		if err != nil {
 			return nil, wrapperSQLOpenError(err)
 		}
-		poolConfig, ok := ct.PoolConfigs[serviceName]
-		if !ok {
-			poolConfig = ct.DefaultPoolConfig
-		}
-		db.SetMaxIdleConns(poolConfig.MaxIdle)
-		db.SetMaxOpenConns(poolConfig.MaxOpen)
-		db.SetConnMaxLifetime(poolConfig.MaxLifetime)
+		setPool(db, ct.poolConfig(serviceName))
 		ct.SQLDBLock.Lock()
 		ct.SQLDB[dsn] = db
+		if ct.dbServices == nil {
+			ct.dbServices = make(map[string]string)
+		}
+		ct.dbServices[dsn] = serviceName
 		ct.SQLDBLock.Unlock()
 		return db, nil
 	})
@@ -300,10 +325,18 @@ func (ct *ClientTransport) GetDB(serviceName, dsn string) (*sql.DB, error) {
 func (ct *ClientTransport) deleteDB(dsn string) {
 	ct.SQLDBLock.Lock()
 	delete(ct.SQLDB, dsn)
+	delete(ct.dbServices, dsn)
 	ct.SQLDBLock.Unlock()
 }
 
-func (ct *ClientTransport) getDBType(s string) string {
+func setPool(db *sql.DB, conf PoolConfig) {
+	db.SetMaxIdleConns(conf.MaxIdle)
+	db.SetMaxOpenConns(conf.MaxOpen)
+	db.SetConnMaxLifetime(conf.MaxLifetime)
+}
+
+// getDBType returns the database type in the service name trpc.${db type}.xxx.xxx.
+func getDBType(s string) string {
 	splitServiceName := strings.Split(s, ".")
 	// Compatibility logic, keeps MySQL as the default.
 	// internal repository issues/235
@@ -318,21 +351,20 @@ func (ct *ClientTransport) initDB(s, dsn string) (*sql.DB, error) {
 	// When the serviceName is not standard, mysql is used by default.
 	// The code is borrowed from the getAppServerService(string) (string, string, string,bool) method
 	// in trpc.group/trpc-go/trpc-go/codec/message_impl.go.
+	ct.configLock.RLock()
+	driverName := ct.DefaultPoolConfig.DriverName
 	if conf, ok := ct.PoolConfigs[s]; ok && conf.DriverName != "" {
-		return ct.opener(conf.DriverName, dsn)
+		driverName = conf.DriverName
 	}
-	if ct.DefaultPoolConfig.DriverName != "" {
-		return ct.opener(ct.DefaultPoolConfig.DriverName, dsn)
+	ct.configLock.RUnlock()
+	if driverName == "" {
+		driverName = getDriverName(getDBType(s))
 	}
-	switch ct.getDBType(s) {
-	case "clickhouse":
-		return ct.opener("clickhouse", dsn)
-	case "postgres":
-		// The driver registered for postgres is pgx by default.
-		return ct.opener("pgx", dsn)
-	default:
-		return ct.opener("mysql", dsn)
+	// Only the services with the statement timeout release it when the rows are closed, see withTimeout.
+	if getTimeout(s) > 0 {
+		return openDB(ct.opener, driverName, dsn)
 	}
+	return ct.opener(driverName, dsn)
 }
 
 func wrapperSQLOpenError(err error) error {
@@ -0,0 +1,366 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"trpc.group/trpc-go/trpc-go/codec"
+)
+
+const (
+	// maxPlaceholders is the maximum number of placeholders of a prepared statement supported by MySQL.
+	maxPlaceholders = 65535
+	// defaultMaxPacketSize is the default max_allowed_packet of MySQL 5.7.
+	defaultMaxPacketSize = 4 << 20
+)
+
+// BatchInsert inserts rows into table with multi-row INSERT statements and returns the summed RowsAffected.
+// rows is a slice of structs (or struct pointers) whose columns are mapped by the `db` tag,
+// or a mysql.Args whose maps must share the same keys.
+// Rows are split into several statements that respect max_allowed_packet and the placeholder limit,
+// which are executed in order but not in a transaction. If one of them fails, the rows affected by
+// the statements already executed are returned along with the error.
+func (c *mysqlCli) BatchInsert(ctx context.Context, table string, rows interface{},
+	opts ...BatchOption) (int64, error) {
+	return c.batchInsert(ctx, "BatchInsert", table, rows, nil, opts...)
+}
+
+// Upsert is similar to BatchInsert, except that it appends ON DUPLICATE KEY UPDATE to each statement,
+// so that updateColumns of the existing rows are overwritten by the inserted values.
+// All inserted columns are updated if updateColumns is empty.
+// Note that MySQL counts 1 for each inserted row and 2 for each updated row in RowsAffected.
+func (c *mysqlCli) Upsert(ctx context.Context, table string, rows interface{}, updateColumns []string,
+	opts ...BatchOption) (int64, error) {
+	if updateColumns == nil {
+		updateColumns = []string{}
+	}
+	return c.batchInsert(ctx, "Upsert", table, rows, updateColumns, opts...)
+}
+
+// batchInsert builds the insert statements and sends them in a single request.
+// A nil updateColumns means no ON DUPLICATE KEY UPDATE clause.
+func (c *mysqlCli) batchInsert(ctx context.Context, method string, table string, rows interface{},
+	updateColumns []string, opts ...BatchOption) (int64, error) {
+	o := &batchOptions{maxPacketSize: defaultMaxPacketSize}
+	for _, opt := range opts {
+		opt(o)
+	}
+	stmts, err := buildBatchStatements(table, rows, updateColumns, o)
+	if err != nil {
+		return 0, err
+	}
+	if len(stmts) == 0 {
+		return 0, nil
+	}
+	mreq := &Request{
+		batch:  stmts,
+		op:     opBatchExec,
+		unsafe: c.unsafe,
+	}
+	mrsp := &Response{}
+
+	ctx, msg := codec.WithCloneMessage(ctx)
+	defer codec.PutBackMessage(msg)
+	msg.WithClientRPCName(rpcName(c.serviceName, method, stmts[0].query))
+	msg.WithCalleeServiceName(c.serviceName)
+	msg.WithSerializationType(codec.SerializationTypeUnsupported)
+	msg.WithCompressType(codec.CompressTypeNoop)
+	msg.WithClientReqHead(mreq)
+	msg.WithClientRspHead(mrsp)
+
+	err = c.client.Invoke(ctx, mreq, mrsp, c.opts...)
+	var affected int64
+	if mrsp.Result != nil {
+		affected, _ = mrsp.Result.RowsAffected()
+	}
+	return affected, err
+}
+
+// statement is a SQL statement with its arguments.
+type statement struct {
+	query string
+	args  []interface{}
+}
+
+// batchResult is the sql.Result of a batch of statements.
+type batchResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+// LastInsertId returns the id of the first row inserted by the first statement.
+func (r *batchResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+// RowsAffected returns the summed RowsAffected of all statements.
+func (r *batchResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+func handleBatchExec(ctx context.Context, db *sql.DB, req *Request) (sql.Result, error) {
+	result := &batchResult{}
+	for i, stmt := range req.batch {
+		r, err := db.ExecContext(ctx, stmt.query, stmt.args...)
+		if err != nil {
+			return result, err
+		}
+		affected, err := r.RowsAffected()
+		if err != nil {
+			return result, err
+		}
+		result.rowsAffected += affected
+		if i == 0 {
+			if result.lastInsertID, err = r.LastInsertId(); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// buildBatchStatements converts rows into insert statements, each of which fits the limits in o.
+func buildBatchStatements(table string, rows interface{}, updateColumns []string,
+	o *batchOptions) ([]statement, error) {
+	if table == "" {
+		return nil, errors.New("trpc-mysql: empty table name")
+	}
+	columns, values, err := extractRows(rows, o.omitColumns)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	head := "INSERT INTO " + quoteIdentifier(table) + " (" + joinNames(columns) + ") VALUES "
+	var tail string
+	if updateColumns != nil {
+		if len(updateColumns) == 0 {
+			updateColumns = columns
+		}
+		assignments := make([]string, 0, len(updateColumns))
+		for _, col := range updateColumns {
+			quoted := quoteName(col)
+			assignments = append(assignments, quoted+"=VALUES("+quoted+")")
+		}
+		tail = " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ",")
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+
+	maxRows := maxPlaceholders / len(columns)
+	if o.batchSize > 0 && o.batchSize < maxRows {
+		maxRows = o.batchSize
+	}
+	var (
+		stmts []statement
+		sb    strings.Builder
+		args  []interface{}
+		n     int
+		size  int
+	)
+	flush := func() {
+		sb.WriteString(tail)
+		stmts = append(stmts, statement{query: sb.String(), args: args})
+		sb.Reset()
+		args, n, size = nil, 0, 0
+	}
+	for _, row := range values {
+		rowSize := len(placeholders) + 1
+		for _, v := range row {
+			rowSize += estimateArgSize(v)
+		}
+		if n > 0 && (n >= maxRows || size+rowSize > o.maxPacketSize) {
+			flush()
+		}
+		if n == 0 {
+			sb.WriteString(head)
+			size = len(head) + len(tail)
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(placeholders)
+		args = append(args, row...)
+		size += rowSize
+		n++
+	}
+	flush()
+	return stmts, nil
+}
+
+// extractRows returns the columns and the values of each row.
+func extractRows(rows interface{}, omitColumns []string) ([]string, [][]interface{}, error) {
+	rv := reflect.Indirect(reflect.ValueOf(rows))
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, nil, fmt.Errorf("trpc-mysql: batch rows should be a slice, got %T", rows)
+	}
+	if rv.Len() == 0 {
+		return nil, nil, nil
+	}
+	omit := make(map[string]bool, len(omitColumns))
+	for _, col := range omitColumns {
+		omit[col] = true
+	}
+
+	elemType := rv.Type().Elem()
+	if elemType.Kind() == reflect.Map && elemType.Key().Kind() == reflect.String {
+		return extractMapRows(rv, omit)
+	}
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("trpc-mysql: batch rows should be structs or maps, got %T", rows)
+	}
+	fields := structFields(elemType, nil)
+	var columns []string
+	var indexes [][]int
+	for _, f := range fields {
+		if !omit[f.column] {
+			columns = append(columns, f.column)
+			indexes = append(indexes, f.index)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("trpc-mysql: no column found in %s", elemType)
+	}
+	values := make([][]interface{}, rv.Len())
+	for i := range values {
+		elem := reflect.Indirect(rv.Index(i))
+		if !elem.IsValid() {
+			return nil, nil, fmt.Errorf("trpc-mysql: batch row %d is nil", i)
+		}
+		row := make([]interface{}, len(indexes))
+		for j, index := range indexes {
+			row[j] = elem.FieldByIndex(index).Interface()
+		}
+		values[i] = row
+	}
+	return columns, values, nil
+}
+
+// extractMapRows returns the columns and the values of map rows, the columns are sorted by name.
+func extractMapRows(rv reflect.Value, omit map[string]bool) ([]string, [][]interface{}, error) {
+	var columns []string
+	for _, key := range rv.Index(0).MapKeys() {
+		if col := key.String(); !omit[col] {
+			columns = append(columns, col)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, nil, errors.New("trpc-mysql: no column found in the first batch row")
+	}
+	sort.Strings(columns)
+	values := make([][]interface{}, rv.Len())
+	for i := range values {
+		m := rv.Index(i)
+		row := make([]interface{}, len(columns))
+		for j, col := range columns {
+			v := m.MapIndex(reflect.ValueOf(col).Convert(m.Type().Key()))
+			if !v.IsValid() {
+				return nil, nil, fmt.Errorf("trpc-mysql: batch row %d misses column %s", i, col)
+			}
+			row[j] = v.Interface()
+		}
+		if m.Len() != len(columns)+countOmitted(m, omit) {
+			return nil, nil, fmt.Errorf("trpc-mysql: batch row %d has different columns from the first row", i)
+		}
+		values[i] = row
+	}
+	return columns, values, nil
+}
+
+// countOmitted returns the number of omitted columns in the map m.
+func countOmitted(m reflect.Value, omit map[string]bool) int {
+	var n int
+	for col := range omit {
+		if m.MapIndex(reflect.ValueOf(col).Convert(m.Type().Key())).IsValid() {
+			n++
+		}
+	}
+	return n
+}
+
+// structField is a struct field mapped to a column.
+type structField struct {
+	column string
+	index  []int
+}
+
+// structFields returns the fields of t mapped by the `db` tag, following the same rules as sqlx:
+// untagged fields are mapped by their lowercase names, and untagged embedded structs are flattened.
+func structFields(t reflect.Type, parent []int) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("db"), ",")[0]
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		index := append(append([]int(nil), parent...), i)
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, structFields(f.Type, index)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = strings.ToLower(f.Name)
+		}
+		fields = append(fields, structField{column: tag, index: index})
+	}
+	return fields
+}
+
+// estimateArgSize estimates the size of an argument in the packet sent to MySQL.
+func estimateArgSize(v interface{}) int {
+	const (
+		quoteSize   = 2
+		defaultSize = 32
+	)
+	switch v := v.(type) {
+	case nil:
+		return len("NULL")
+	case string:
+		// Escaping may double the size in the worst case.
+		return len(v)*2 + quoteSize
+	case []byte:
+		return len(v)*2 + quoteSize
+	case *string:
+		if v != nil {
+			return len(*v)*2 + quoteSize
+		}
+		return len("NULL")
+	default:
+		return defaultSize
+	}
+}
+
+// quoteIdentifier quotes a possibly qualified identifier such as db.table with backticks.
+func quoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = quoteName(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteName quotes a single name such as a column with backticks.
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// joinNames quotes names and joins them with commas.
+func joinNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteName(name)
+	}
+	return strings.Join(quoted, ",")
+}
@@ -0,0 +1,144 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+type batchBase struct {
+	ID int64 `db:"id"`
+}
+
+type batchUser struct {
+	batchBase
+	Name    string `db:"name"`
+	Age     int
+	Ignored string `db:"-"`
+	private string
+}
+
+func Test_buildBatchStatements(t *testing.T) {
+	users := []*batchUser{
+		{batchBase: batchBase{ID: 1}, Name: "Jobs", Age: 15},
+		{batchBase: batchBase{ID: 2}, Name: "Alice", Age: 16},
+		{batchBase: batchBase{ID: 3}, Name: "Foo", Age: 17},
+	}
+
+	t.Run("insert in one statement", func(t *testing.T) {
+		stmts, err := buildBatchStatements("db.user", users, nil, &batchOptions{maxPacketSize: defaultMaxPacketSize})
+		require.NoError(t, err)
+		require.Equal(t, []statement{{
+			query: "INSERT INTO `db`.`user` (`id`,`name`,`age`) VALUES (?,?,?),(?,?,?),(?,?,?)",
+			args:  []interface{}{int64(1), "Jobs", 15, int64(2), "Alice", 16, int64(3), "Foo", 17},
+		}}, stmts)
+	})
+	t.Run("split by batch size", func(t *testing.T) {
+		stmts, err := buildBatchStatements("user", users, nil,
+			&batchOptions{batchSize: 2, maxPacketSize: defaultMaxPacketSize, omitColumns: []string{"id"}})
+		require.NoError(t, err)
+		require.Equal(t, []statement{
+			{
+				query: "INSERT INTO `user` (`name`,`age`) VALUES (?,?),(?,?)",
+				args:  []interface{}{"Jobs", 15, "Alice", 16},
+			},
+			{
+				query: "INSERT INTO `user` (`name`,`age`) VALUES (?,?)",
+				args:  []interface{}{"Foo", 17},
+			},
+		}, stmts)
+	})
+	t.Run("split by packet size", func(t *testing.T) {
+		stmts, err := buildBatchStatements("user", users, nil, &batchOptions{maxPacketSize: 1})
+		require.NoError(t, err)
+		require.Len(t, stmts, 3)
+	})
+	t.Run("upsert with maps", func(t *testing.T) {
+		rows := Args{
+			{"id": 1, "name": "Jobs"},
+			{"id": 2, "name": "Alice"},
+		}
+		stmts, err := buildBatchStatements("user", rows, []string{"name"},
+			&batchOptions{maxPacketSize: defaultMaxPacketSize})
+		require.NoError(t, err)
+		require.Equal(t, []statement{{
+			query: "INSERT INTO `user` (`id`,`name`) VALUES (?,?),(?,?) ON DUPLICATE KEY UPDATE `name`=VALUES(`name`)",
+			args:  []interface{}{1, "Jobs", 2, "Alice"},
+		}}, stmts)
+	})
+	t.Run("upsert all columns", func(t *testing.T) {
+		stmts, err := buildBatchStatements("user", Args{{"id": 1, "name": "Jobs"}}, []string{},
+			&batchOptions{maxPacketSize: defaultMaxPacketSize})
+		require.NoError(t, err)
+		require.Equal(t, "INSERT INTO `user` (`id`,`name`) VALUES (?,?) "+
+			"ON DUPLICATE KEY UPDATE `id`=VALUES(`id`),`name`=VALUES(`name`)", stmts[0].query)
+	})
+	t.Run("empty rows", func(t *testing.T) {
+		stmts, err := buildBatchStatements("user", []batchUser{}, nil, &batchOptions{})
+		require.NoError(t, err)
+		require.Empty(t, stmts)
+	})
+	t.Run("invalid rows", func(t *testing.T) {
+		o := &batchOptions{maxPacketSize: defaultMaxPacketSize}
+		_, err := buildBatchStatements("", users, nil, o)
+		require.Error(t, err)
+		_, err = buildBatchStatements("user", batchUser{}, nil, o)
+		require.Error(t, err)
+		_, err = buildBatchStatements("user", []int{1}, nil, o)
+		require.Error(t, err)
+		_, err = buildBatchStatements("user", []*batchUser{nil}, nil, o)
+		require.Error(t, err)
+		_, err = buildBatchStatements("user", Args{{"id": 1}, {"name": "Jobs"}}, nil, o)
+		require.Error(t, err)
+		_, err = buildBatchStatements("user", Args{{"id": 1}, {"id": 2, "name": "Jobs"}}, nil, o)
+		require.Error(t, err)
+	})
+}
+
+func Test_mysqlCli_BatchInsert(t *testing.T) {
+	c := NewClientProxy(MySQLName)
+	patches := prepareTestData(t, c)
+	defer patches.Reset()
+
+	users := []User{{Name: "Bar", Age: 30}, {Name: "Baz", Age: 31}, {Name: "Qux", Age: 32}}
+	n, err := c.BatchInsert(trpc.BackgroundContext(), "user", users, WithOmitColumns("id"), WithBatchSize(2))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+
+	var got []*User
+	err = c.Select(trpc.BackgroundContext(), &got, "SELECT * FROM user WHERE age >= ? ORDER BY id", 30)
+	require.NoError(t, err)
+	require.Equal(t, []*User{{101, "Bar", 30}, {102, "Baz", 31}, {103, "Qux", 32}}, got)
+
+	n, err = c.BatchInsert(trpc.BackgroundContext(), "user", Args{{"id": 1, "name": "Dup", "age": 1}})
+	require.Error(t, err)
+	require.Equal(t, int64(0), n)
+}
+
+func Test_mysqlCli_Upsert(t *testing.T) {
+	client.DefaultClient = &mockClient{func(ctx context.Context, req interface{}, rsp interface{},
+		opts ...client.Option) error {
+		mreq := req.(*Request)
+		require.Equal(t, opBatchExec, mreq.op)
+		require.Len(t, mreq.batch, 1)
+		rsp.(*Response).Result = &batchResult{rowsAffected: 3}
+		return errors.New("fake error")
+	}}
+	defer func() {
+		client.DefaultClient = client.New()
+	}()
+
+	c := NewClientProxy(MySQLName)
+	n, err := c.Upsert(context.Background(), "user", []User{{ID: 1, Name: "Jobs"}, {ID: 2, Name: "Foo"}}, nil)
+	require.EqualError(t, err, "fake error")
+	require.Equal(t, int64(3), n)
+
+	n, err = c.Upsert(context.Background(), "user", []User{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), n)
+}
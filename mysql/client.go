@@ -29,6 +29,8 @@ const (
 	opNamedExec
 	opNamedQuery
 	opTransactionx
+	opBatchExec
+	opQueryx
 )
 
 // Client client Structure
@@ -42,6 +44,7 @@ type Client interface {
 	Transaction(ctx context.Context, fn TxFunc, opts ...TxOption) error
 
 	// Implementation via sqlx.
+	Queryx(ctx context.Context, next NextxFunc, query string, args ...interface{}) error
 	Get(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 	Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 	NamedExec(ctx context.Context, query string, args interface{}) (sql.Result, error)
@@ -49,6 +52,11 @@ type Client interface {
 	QueryToStruct(ctx context.Context, dst interface{}, query string, args ...interface{}) error
 	QueryToStructs(ctx context.Context, dst interface{}, query string, args ...interface{}) error
 	Transactionx(ctx context.Context, fn TxxFunc, opts ...TxOption) error
+
+	// Batch insert via multi-row statements.
+	BatchInsert(ctx context.Context, table string, rows interface{}, opts ...BatchOption) (int64, error)
+	Upsert(ctx context.Context, table string, rows interface{}, updateColumns []string,
+		opts ...BatchOption) (int64, error)
 }
 
 // Client back-end request structure.
@@ -59,14 +67,28 @@ type mysqlCli struct {
 	// if unsafe mode is on, it will silently succeed to scan when
 	// columns in the SQL result have no fields in the destination struct.
 	unsafe bool
+	// replicas picks the replica service for read operations.
+	replicas replicaPicker
+	// replicaOpts are the options of the calls to the replica services.
+	replicaOpts []client.Option
 }
 
 func newMysqlCli(name string, opts ...client.Option) *mysqlCli {
+	opts = append([]client.Option{client.WithProtocol("mysql"), client.WithDisableServiceRouter()}, opts...)
 	return &mysqlCli{
 		serviceName: name,
 		client:      client.DefaultClient,
-		opts:        append([]client.Option{client.WithProtocol("mysql"), client.WithDisableServiceRouter()}, opts...),
+		opts:        opts,
+		replicaOpts: replicaOptions(opts),
+	}
+}
+
+// readOptions returns the options of the read operations sent to the service picked by the replicas.
+func (c *mysqlCli) readOptions(serviceName string) []client.Option {
+	if serviceName == c.serviceName {
+		return c.opts
 	}
+	return c.replicaOpts
 }
 
 // NewClientProxy Create a new mysql backend request proxy Mandatory parameter mysql service name: trpc.mysql.xxx.xxx.
@@ -94,9 +116,11 @@ type Request struct {
 
 	op     int
 	next   NextFunc
+	nextx  NextxFunc
 	tx     TxFunc
 	txx    TxxFunc
 	txOpts *sql.TxOptions
+	batch  []statement
 
 	QueryToDest        interface{}
 	QueryToStructDest  interface{}
@@ -111,7 +135,7 @@ type Request struct {
 // Note that NextFunc and TxFunc are closures and we can only make a normal copy of them.
 // But this necessarily breaks concurrency safety. Therefore, Copy returns an error for a non-empty next or tx.
 func (r *Request) Copy() (interface{}, error) {
-	if r.next != nil {
+	if r.next != nil || r.nextx != nil {
 		return nil, errors.New("request with non nil next closure does not support Copy")
 	}
 	if r.tx != nil {
@@ -126,6 +150,7 @@ func (r *Request) Copy() (interface{}, error) {
 		next:   r.next,
 		tx:     r.tx,
 		txOpts: r.txOpts,
+		batch:  r.batch,
 		unsafe: r.unsafe,
 	}
 
@@ -156,7 +181,7 @@ func (r *Request) Copy() (interface{}, error) {
 // CopyTo copies a Request into another Request. dst must be of type *Request.
 // Similar to Copy, CopyTo returns an error for a non-empty next or tx.
 func (r *Request) CopyTo(dst interface{}) error {
-	if r.next != nil {
+	if r.next != nil || r.nextx != nil {
 		return fmt.Errorf("request with non nil next closure does not support CopyTo")
 	}
 	if r.tx != nil {
@@ -175,6 +200,7 @@ func (r *Request) CopyTo(dst interface{}) error {
 	dr.next = r.next
 	dr.tx = r.tx
 	dr.txOpts = r.txOpts
+	dr.batch = r.batch
 	dr.unsafe = r.unsafe
 
 	// If QueryToStructsDest or QueryRowDest exist, then
@@ -226,6 +252,10 @@ type (
 	// Return value error ==nil continue to the next row, ==ErrBreak end the loop early ! =nil return failure.
 	NextFunc func(*sql.Rows) error
 
+	// NextxFunc is similar to NextFunc, except that it receives sqlx.Rows,
+	// which is able to scan a row into a struct by StructScan.
+	NextxFunc func(*sqlx.Rows) error
+
 	// TxFunc is a user transaction function that returns err ! = nil when
 	// the transaction is automatically rolled back, otherwise the transaction is automatically committed.
 	// TxFunc receives native sql.Tx.
@@ -255,14 +285,15 @@ func (c *mysqlCli) Query(ctx context.Context, next NextFunc, query string, args
 
 	mctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/Query", c.serviceName))
-	msg.WithCalleeServiceName(c.serviceName)
+	serviceName := c.replicas.pick(ctx, c.serviceName)
+	msg.WithClientRPCName(rpcName(serviceName, "Query", query))
+	msg.WithCalleeServiceName(serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
 	msg.WithClientReqHead(mreq)
 	msg.WithClientRspHead(mrsp)
 
-	err := c.client.Invoke(mctx, mreq, mrsp, c.opts...)
+	err := c.client.Invoke(mctx, mreq, mrsp, c.readOptions(serviceName)...)
 	if err != nil {
 		return err
 	}
@@ -270,6 +301,30 @@ func (c *mysqlCli) Query(ctx context.Context, next NextFunc, query string, args
 	return nil
 }
 
+// Queryx executes the mysql select command via sqlx, next is called for each row of the result.
+func (c *mysqlCli) Queryx(ctx context.Context, next NextxFunc, query string, args ...interface{}) error {
+	mreq := &Request{
+		Query:  query,
+		Args:   args,
+		nextx:  next,
+		op:     opQueryx,
+		unsafe: c.unsafe,
+	}
+	mrsp := &Response{}
+
+	mctx, msg := codec.WithCloneMessage(ctx)
+	defer codec.PutBackMessage(msg)
+	serviceName := c.replicas.pick(ctx, c.serviceName)
+	msg.WithClientRPCName(rpcName(serviceName, "Queryx", query))
+	msg.WithCalleeServiceName(serviceName)
+	msg.WithSerializationType(codec.SerializationTypeUnsupported)
+	msg.WithCompressType(codec.CompressTypeNoop)
+	msg.WithClientReqHead(mreq)
+	msg.WithClientRspHead(mrsp)
+
+	return c.client.Invoke(mctx, mreq, mrsp, c.readOptions(serviceName)...)
+}
+
 // QueryRow Execute the mysql QueryRow command.
 func (c *mysqlCli) QueryRow(ctx context.Context, dest []interface{}, query string, args ...interface{}) error {
 	mreq := &Request{
@@ -283,14 +338,15 @@ func (c *mysqlCli) QueryRow(ctx context.Context, dest []interface{}, query strin
 
 	mctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/QueryRow", c.serviceName))
-	msg.WithCalleeServiceName(c.serviceName)
+	serviceName := c.replicas.pick(ctx, c.serviceName)
+	msg.WithClientRPCName(rpcName(serviceName, "QueryRow", query))
+	msg.WithCalleeServiceName(serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
 	msg.WithClientReqHead(mreq)
 	msg.WithClientRspHead(mrsp)
 
-	err := c.client.Invoke(mctx, mreq, mrsp, c.opts...)
+	err := c.client.Invoke(mctx, mreq, mrsp, c.readOptions(serviceName)...)
 	if err != nil {
 		return err
 	}
@@ -310,14 +366,15 @@ func (c *mysqlCli) QueryToStruct(ctx context.Context, dest interface{}, query st
 	mrsp := &Response{}
 
 	ctx, msg := codec.WithCloneMessage(ctx)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/QueryToStruct", c.serviceName))
-	msg.WithCalleeServiceName(c.serviceName)
+	serviceName := c.replicas.pick(ctx, c.serviceName)
+	msg.WithClientRPCName(rpcName(serviceName, "QueryToStruct", query))
+	msg.WithCalleeServiceName(serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
 	msg.WithClientReqHead(mreq)
 	msg.WithClientRspHead(mrsp)
 
-	err := c.client.Invoke(ctx, mreq, mrsp, c.opts...)
+	err := c.client.Invoke(ctx, mreq, mrsp, c.readOptions(serviceName)...)
 	if err != nil {
 		return err
 	}
@@ -337,14 +394,15 @@ func (c *mysqlCli) QueryToStructs(ctx context.Context, dest interface{}, query s
 
 	ctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/QueryToStructs", c.serviceName))
-	msg.WithCalleeServiceName(c.serviceName)
+	serviceName := c.replicas.pick(ctx, c.serviceName)
+	msg.WithClientRPCName(rpcName(serviceName, "QueryToStructs", query))
+	msg.WithCalleeServiceName(serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
 	msg.WithClientReqHead(mreq)
 	msg.WithClientRspHead(mrsp)
 
-	err := c.client.Invoke(ctx, mreq, mrsp, c.opts...)
+	err := c.client.Invoke(ctx, mreq, mrsp, c.readOptions(serviceName)...)
 	if err != nil {
 		return err
 	}
@@ -364,7 +422,7 @@ func (c *mysqlCli) Exec(ctx context.Context, query string, args ...interface{})
 
 	ctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/Exec", c.serviceName))
+	msg.WithClientRPCName(rpcName(c.serviceName, "Exec", query))
 	msg.WithCalleeServiceName(c.serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
@@ -388,7 +446,7 @@ func (c *mysqlCli) Exec(ctx context.Context, query string, args ...interface{})
 func (c *mysqlCli) NamedExec(ctx context.Context, query string, args interface{}) (sql.Result, error) {
 	ctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/NamedExec", c.serviceName))
+	msg.WithClientRPCName(rpcName(c.serviceName, "NamedExec", query))
 	msg.WithCalleeServiceName(c.serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
@@ -414,6 +472,8 @@ func (c *mysqlCli) NamedExec(ctx context.Context, query string, args interface{}
 // Transaction executes a mysql transaction, fn is a multi-callback function that receives *sql.Tx.
 // fn returns error ! = nil the transaction is automatically rolled back, otherwise the transaction
 // is automatically committed.
+// If ctx carries a transaction by ContextWithTx or ContextWithTxx, fn runs in a savepoint of it instead.
+// If ctx is returned by WithTxRetry, the whole transaction is retried on deadlocks and lock wait timeouts.
 func (c *mysqlCli) Transaction(ctx context.Context, fn TxFunc, opts ...TxOption) error {
 	txOpts := new(sql.TxOptions)
 	for _, o := range opts {
@@ -425,26 +485,26 @@ func (c *mysqlCli) Transaction(ctx context.Context, fn TxFunc, opts ...TxOption)
 		op:     opTransaction,
 		unsafe: c.unsafe,
 	}
-	mrsp := &Response{}
-	ctx, msg := codec.WithCloneMessage(ctx)
-	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/Transaction", c.serviceName))
-	msg.WithCalleeServiceName(c.serviceName)
-	msg.WithSerializationType(codec.SerializationTypeUnsupported)
-	msg.WithCompressType(codec.CompressTypeNoop)
-	msg.WithClientReqHead(mreq)
-	msg.WithClientRspHead(mrsp)
-
-	err := c.client.Invoke(ctx, mreq, mrsp, c.opts...)
-	if err != nil {
-		return err
-	}
-	return nil
+	return txRetryFromContext(ctx).run(ctx, func() error {
+		mrsp := &Response{}
+		ctx, msg := codec.WithCloneMessage(ctx)
+		defer codec.PutBackMessage(msg)
+		msg.WithClientRPCName(fmt.Sprintf("/%s/Transaction", c.serviceName))
+		msg.WithCalleeServiceName(c.serviceName)
+		msg.WithSerializationType(codec.SerializationTypeUnsupported)
+		msg.WithCompressType(codec.CompressTypeNoop)
+		msg.WithClientReqHead(mreq)
+		msg.WithClientRspHead(mrsp)
+
+		return c.client.Invoke(ctx, mreq, mrsp, c.opts...)
+	})
 }
 
 // Transactionx executes mysql transactions via sqlx, fn is a multi-callback function that receives *sqlx.
 // fn returns error ! = nil the transaction is automatically rolled back,
 // otherwise the transaction is automatically committed.
+// If ctx carries a transaction by ContextWithTx or ContextWithTxx, fn runs in a savepoint of it instead.
+// If ctx is returned by WithTxRetry, the whole transaction is retried on deadlocks and lock wait timeouts.
 func (c *mysqlCli) Transactionx(ctx context.Context, fn TxxFunc, opts ...TxOption) error {
 	txOpts := new(sql.TxOptions)
 	for _, o := range opts {
@@ -456,22 +516,19 @@ func (c *mysqlCli) Transactionx(ctx context.Context, fn TxxFunc, opts ...TxOptio
 		op:     opTransactionx,
 		unsafe: c.unsafe,
 	}
-
-	mrsp := &Response{}
-	ctx, msg := codec.WithCloneMessage(ctx)
-	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/Transactionx", c.serviceName))
-	msg.WithCalleeServiceName(c.serviceName)
-	msg.WithSerializationType(codec.SerializationTypeUnsupported)
-	msg.WithCompressType(codec.CompressTypeNoop)
-	msg.WithClientReqHead(mreq)
-	msg.WithClientRspHead(mrsp)
-
-	err := c.client.Invoke(ctx, mreq, mrsp, c.opts...)
-	if err != nil {
-		return err
-	}
-	return nil
+	return txRetryFromContext(ctx).run(ctx, func() error {
+		mrsp := &Response{}
+		ctx, msg := codec.WithCloneMessage(ctx)
+		defer codec.PutBackMessage(msg)
+		msg.WithClientRPCName(fmt.Sprintf("/%s/Transactionx", c.serviceName))
+		msg.WithCalleeServiceName(c.serviceName)
+		msg.WithSerializationType(codec.SerializationTypeUnsupported)
+		msg.WithCompressType(codec.CompressTypeNoop)
+		msg.WithClientReqHead(mreq)
+		msg.WithClientRspHead(mrsp)
+
+		return c.client.Invoke(ctx, mreq, mrsp, c.opts...)
+	})
 }
 
 // Get Query a single data item Scan the result to dest.
@@ -487,14 +544,15 @@ func (c *mysqlCli) Get(ctx context.Context, dest interface{}, query string, args
 
 	ctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/Get", c.serviceName))
-	msg.WithCalleeServiceName(c.serviceName)
+	serviceName := c.replicas.pick(ctx, c.serviceName)
+	msg.WithClientRPCName(rpcName(serviceName, "Get", query))
+	msg.WithCalleeServiceName(serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
 	msg.WithClientReqHead(mreq)
 	msg.WithClientRspHead(mrsp)
 
-	err := c.client.Invoke(ctx, mreq, mrsp, c.opts...)
+	err := c.client.Invoke(ctx, mreq, mrsp, c.readOptions(serviceName)...)
 	if err != nil {
 		return err
 	}
@@ -515,14 +573,15 @@ func (c *mysqlCli) Select(ctx context.Context, dest interface{}, query string, a
 
 	ctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/Select", c.serviceName))
-	msg.WithCalleeServiceName(c.serviceName)
+	serviceName := c.replicas.pick(ctx, c.serviceName)
+	msg.WithClientRPCName(rpcName(serviceName, "Select", query))
+	msg.WithCalleeServiceName(serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
 	msg.WithClientReqHead(mreq)
 	msg.WithClientRspHead(mrsp)
 
-	err := c.client.Invoke(ctx, mreq, mrsp, c.opts...)
+	err := c.client.Invoke(ctx, mreq, mrsp, c.readOptions(serviceName)...)
 	if err != nil {
 		return err
 	}
@@ -543,7 +602,7 @@ func (c *mysqlCli) NamedQuery(ctx context.Context, query string, args interface{
 
 	ctx, msg := codec.WithCloneMessage(ctx)
 	defer codec.PutBackMessage(msg)
-	msg.WithClientRPCName(fmt.Sprintf("/%s/NamedQuery", c.serviceName))
+	msg.WithClientRPCName(rpcName(c.serviceName, "NamedQuery", query))
 	msg.WithCalleeServiceName(c.serviceName)
 	msg.WithSerializationType(codec.SerializationTypeUnsupported)
 	msg.WithCompressType(codec.CompressTypeNoop)
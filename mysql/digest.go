@@ -0,0 +1,187 @@
+package mysql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// maxDigestLength is the maximum length of a SQL digest, longer ones are truncated.
+	maxDigestLength = 512
+	// maxMaskedArgs is the maximum number of arguments shown in slow query logs.
+	maxMaskedArgs = 20
+)
+
+// sqlDigest is 1 if the RPC names are the digests of the statements, see setSQLDigest.
+var sqlDigest int32
+
+// setSQLDigest sets whether the RPC names of the messages are the digests of the statements instead of the
+// method names, so that metrics break down per statement shape.
+func setSQLDigest(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&sqlDigest, v)
+}
+
+// rpcName returns the RPC name of calling the method with the query, which is set before the client filters.
+func rpcName(serviceName, method, query string) string {
+	if atomic.LoadInt32(&sqlDigest) == 1 && query != "" {
+		return fmt.Sprintf("/%s/%s", serviceName, digest(query))
+	}
+	return fmt.Sprintf("/%s/%s", serviceName, method)
+}
+
+var (
+	// inListPattern matches IN lists of placeholders, such as IN (?, ?, ?).
+	inListPattern = regexp.MustCompile(`(?i)\bIN \( ?\?(?: ?, ?\?)* ?\)`)
+	// valuesPattern matches multiple rows of VALUES, such as VALUES (?,?),(?,?).
+	valuesPattern = regexp.MustCompile(`(?i)\bVALUES ?(\([^()]*\))(?: ?, ?\([^()]*\))+`)
+)
+
+// digest returns the fingerprint of a SQL statement: literals are replaced by ?, comments are removed,
+// whitespaces are collapsed, IN lists are replaced by (...) and multiple VALUES rows are reduced to one,
+// so that statements of the same shape share the same digest.
+func digest(query string) string {
+	var sb strings.Builder
+	sb.Grow(len(query))
+	space := false
+	write := func(s string) {
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteString(s)
+	}
+	// identBefore reports whether the last written character is part of an identifier,
+	// in which case a digit following it belongs to the identifier, such as t1.
+	identBefore := func() bool {
+		if space || sb.Len() == 0 {
+			return false
+		}
+		return isIdentChar(sb.String()[sb.Len()-1])
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+			space = true
+		case c == '#' || (c == '-' && strings.HasPrefix(query[i:], "--")):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end
+			}
+			space = true
+		case c == '\'' || c == '"':
+			i = skipQuoted(query, i)
+			write("?")
+		case c == '`':
+			end := skipQuoted(query, i)
+			write(query[i:end])
+			i = end
+		case (isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1]))) && !identBefore():
+			j := i + 1
+			for j < len(query) && (isIdentChar(query[j]) || query[j] == '.' ||
+				((query[j] == '+' || query[j] == '-') && (query[j-1] == 'e' || query[j-1] == 'E'))) {
+				j++
+			}
+			i = j
+			write("?")
+		default:
+			write(query[i : i+1])
+			i++
+		}
+	}
+
+	d := inListPattern.ReplaceAllString(sb.String(), "IN (...)")
+	d = valuesPattern.ReplaceAllString(d, "VALUES $1")
+	if len(d) > maxDigestLength {
+		d = d[:maxDigestLength-4] + " ..."
+	}
+	return d
+}
+
+// skipQuoted returns the index right after the quoted string starting at i,
+// both backslash escapes and doubled quotes are supported.
+func skipQuoted(query string, i int) int {
+	quote := query[i]
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if quote != '`' {
+				j++
+			}
+		case quote:
+			if j+1 < len(query) && query[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(query)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '$'
+}
+
+// maskArgs formats args for logs without their values, only the types and the lengths of strings are kept.
+func maskArgs(args []interface{}) string {
+	masked := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i == maxMaskedArgs {
+			masked = append(masked, fmt.Sprintf("...(%d more)", len(args)-i))
+			break
+		}
+		switch v := arg.(type) {
+		case nil:
+			masked = append(masked, "NULL")
+		case string:
+			masked = append(masked, fmt.Sprintf("string(%d)", len(v)))
+		case []byte:
+			masked = append(masked, fmt.Sprintf("[]byte(%d)", len(v)))
+		default:
+			masked = append(masked, fmt.Sprintf("%T", v))
+		}
+	}
+	return "[" + strings.Join(masked, ", ") + "]"
+}
+
+// statementOf returns the SQL statement and its arguments of req,
+// the query is empty if req does not carry a statement, such as a transaction.
+func statementOf(req *Request) (string, []interface{}) {
+	switch {
+	case req.Query != "":
+		return req.Query, req.Args
+	case req.Exec != "":
+		return req.Exec, req.Args
+	case len(req.batch) > 0:
+		var args []interface{}
+		for _, stmt := range req.batch {
+			args = append(args, stmt.args...)
+		}
+		return req.batch[0].query, args
+	default:
+		return "", nil
+	}
+}
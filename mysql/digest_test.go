@@ -0,0 +1,94 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"trpc.group/trpc-go/trpc-go/client"
+	"trpc.group/trpc-go/trpc-go/codec"
+	"trpc.group/trpc-go/trpc-go/transport"
+)
+
+func Test_digest(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM user WHERE id = 1", "SELECT * FROM user WHERE id = ?"},
+		{"select  name\n\tfrom t1 where name='it''s' and age>-1.5e3", "select name from t1 where name=? and age>-?"},
+		{`SELECT "a\"b", 0x1F, .5 FROM db.t2`, "SELECT ?, ?, ? FROM db.t2"},
+		{"SELECT `col 1` FROM t /* comment */ WHERE a = ? -- tail", "SELECT `col 1` FROM t WHERE a = ?"},
+		{"SELECT * FROM t WHERE id IN (1, 2, 3) AND b in (?,?)", "SELECT * FROM t WHERE id IN (...) AND b IN (...)"},
+		{"INSERT INTO t (a,b) VALUES (1,'x'),(2,'y'), (?,?)", "INSERT INTO t (a,b) VALUES (?,?)"},
+		{"UPDATE t SET a = :a WHERE id = :id # named", "UPDATE t SET a = :a WHERE id = :id"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, digest(tt.query), tt.query)
+	}
+	require.Len(t, digest("SELECT "+string(make([]byte, 2*maxDigestLength))), maxDigestLength)
+}
+
+func Test_maskArgs(t *testing.T) {
+	require.Equal(t, "[NULL, string(5), []byte(2), int, time.Time]",
+		maskArgs([]interface{}{nil, "Alice", []byte("ab"), 1, time.Time{}}))
+	require.Equal(t, "[]", maskArgs(nil))
+	require.Contains(t, maskArgs(make([]interface{}, maxMaskedArgs+5)), "...(5 more)")
+}
+
+func TestClientTransport_RoundTrip_SlowQuery(t *testing.T) {
+	db := mustNewTestingDB()
+	defer db.Close()
+	ct := &ClientTransport{
+		dbs:           map[string]*sql.DB{dbDsn: db},
+		SlowThreshold: time.Nanosecond,
+	}
+	for _, req := range []*Request{
+		{op: opExec, Exec: "CREATE TABLE t (id INTEGER, name TEXT)"},
+		{op: opExec, Exec: "INSERT INTO t VALUES (1, 'a'), (2, ?)", Args: []interface{}{"b"}},
+		{op: opTransaction, tx: func(*sql.Tx) error { return nil }},
+	} {
+		ctx, msg := codec.WithNewMessage(context.Background())
+		msg.WithCalleeServiceName(MySQLName)
+		msg.WithClientRPCName("/" + MySQLName + "/Exec")
+		msg.WithClientReqHead(req)
+		msg.WithClientRspHead(&Response{})
+		_, err := ct.RoundTrip(ctx, nil, transport.WithDialAddress(dbDsn))
+		require.NoError(t, err)
+		// The RPC name is not changed by the transport, after the client filters run.
+		require.Equal(t, "/"+MySQLName+"/Exec", msg.ClientRPCName())
+	}
+}
+
+func TestMysqlCli_SQLDigest(t *testing.T) {
+	var names []string
+	client.DefaultClient = &mockClient{func(ctx context.Context, req interface{}, rsp interface{},
+		opts ...client.Option) error {
+		names = append(names, codec.Message(ctx).ClientRPCName())
+		return nil
+	}}
+	defer func() {
+		client.DefaultClient = client.New()
+	}()
+	c := NewClientProxy(MySQLName)
+	ctx := context.Background()
+
+	setSQLDigest(true)
+	defer setSQLDigest(false)
+	_, err := c.Exec(ctx, "INSERT INTO t VALUES (1, 'a'), (2, ?)", "b")
+	require.NoError(t, err)
+	require.NoError(t, c.QueryRow(ctx, nil, "SELECT name FROM t WHERE id = 1"))
+	require.NoError(t, c.Transaction(ctx, mockTx))
+	setSQLDigest(false)
+	_, err = c.Exec(ctx, "DELETE FROM t")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/" + MySQLName + "/INSERT INTO t VALUES (?, ?)",
+		"/" + MySQLName + "/SELECT name FROM t WHERE id = ?",
+		"/" + MySQLName + "/Transaction",
+		"/" + MySQLName + "/Exec",
+	}, names)
+}
@@ -9,8 +9,10 @@ import (
 )
 
 const (
-	errcodeDupEntry = 1062
-	errcodeSyntax   = 1064
+	errcodeDupEntry        = 1062
+	errcodeSyntax          = 1064
+	errcodeDeadlock        = 1213
+	errcodeLockWaitTimeout = 1205
 
 	errcodeNoRows = 10000
 )
@@ -40,6 +42,26 @@ func IsSyntaxError(err error) bool {
 	return e.Code == errcodeSyntax
 }
 
+// IsDeadlockError Whether a deadlock is found when trying to get lock.
+func IsDeadlockError(err error) bool {
+	e, ok := err.(*errs.Error)
+	if !ok {
+		return false
+	}
+
+	return e.Code == errcodeDeadlock
+}
+
+// IsLockWaitTimeoutError Whether the lock wait timeout is exceeded.
+func IsLockWaitTimeoutError(err error) bool {
+	e, ok := err.(*errs.Error)
+	if !ok {
+		return false
+	}
+
+	return e.Code == errcodeLockWaitTimeout
+}
+
 // IsNoRowsError Determine if it is an ErrNoRows type error.
 func IsNoRowsError(err error) bool {
 	if e, ok := err.(*errs.Error); ok {
@@ -12,6 +12,8 @@ import (
 var (
 	dupEntryError = errs.New(errcodeDupEntry, "dupEntryError")
 	syntaxError   = errs.New(errcodeSyntax, "syntaxError")
+	deadlockError = errs.New(errcodeDeadlock, "deadlockError")
+	lockWaitError = errs.New(errcodeLockWaitTimeout, "lockWaitError")
 	stdError      = errors.New("stdError")
 )
 
@@ -73,6 +75,44 @@ func TestUnit_IsSyntaxError(t *testing.T) {
 	}
 }
 
+func TestUnit_IsDeadlockError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"touch IsDeadlockError", deadlockError, true},
+		{"not touch IsDeadlockError", lockWaitError, false},
+		{"std error", stdError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsDeadlockError(tt.err); got != tt.want {
+				t.Errorf("IsDeadlockError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnit_IsLockWaitTimeoutError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"touch IsLockWaitTimeoutError", lockWaitError, true},
+		{"not touch IsLockWaitTimeoutError", deadlockError, false},
+		{"std error", stdError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsLockWaitTimeoutError(tt.err); got != tt.want {
+				t.Errorf("IsLockWaitTimeoutError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 // TestUnit_IsNoRowsError Line error unit test.
 func TestUnit_IsNoRowsError(t *testing.T) {
 	type args struct {
@@ -0,0 +1,131 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Iterator iterates over the rows of a query result, scanning each row into a T.
+// Struct types are scanned by sqlx StructScan, other types are scanned as a single column.
+//
+// Rows are read by a background request which stays open until the iterator is exhausted or closed,
+// so the tRPC call (and its tracing span) covers the whole iteration. Close must always be called.
+type Iterator[T any] struct {
+	ctx   context.Context
+	rows  chan T
+	done  chan struct{}
+	once  sync.Once
+	value T
+	err   error
+}
+
+// QueryIter executes the mysql select command via c.Queryx and returns an iterator over the result.
+// The timeout of the client applies to the whole iteration, and cancelling ctx stops the iteration.
+//
+//	it := mysql.QueryIter[User](ctx, proxy, "SELECT id, name FROM users WHERE age > ?", 18)
+//	defer it.Close()
+//	for it.Next() {
+//		user := it.Value()
+//		// ...
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+func QueryIter[T any](ctx context.Context, c Client, query string, args ...interface{}) *Iterator[T] {
+	it := &Iterator[T]{
+		ctx:  ctx,
+		rows: make(chan T),
+		done: make(chan struct{}),
+	}
+	go func() {
+		// The error is written before rows is closed, which happens before it is read by the consumer.
+		it.err = c.Queryx(ctx, it.send, query, args...)
+		close(it.rows)
+	}()
+	return it
+}
+
+// send scans the current row and hands it over to the consumer.
+func (it *Iterator[T]) send(rows *sqlx.Rows) error {
+	var v T
+	dest := interface{}(&v)
+	if rv := reflect.ValueOf(dest).Elem(); rv.Kind() == reflect.Ptr {
+		// Allocate the value pointed to by T, so that *User can be used as T as well as User.
+		rv.Set(reflect.New(rv.Type().Elem()))
+		dest = v
+	}
+	if err := scanRow(rows, dest); err != nil {
+		return err
+	}
+	select {
+	case it.rows <- v:
+		return nil
+	case <-it.done:
+		return ErrBreak
+	case <-it.ctx.Done():
+		return it.ctx.Err()
+	}
+}
+
+// Next advances the iterator to the next row, it returns false when there are no more rows,
+// an error occurs or ctx is done.
+func (it *Iterator[T]) Next() bool {
+	select {
+	case v, ok := <-it.rows:
+		if !ok {
+			return false
+		}
+		it.value = v
+		return true
+	case <-it.ctx.Done():
+		return false
+	}
+}
+
+// Value returns the current row.
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// Err returns the error that ends the iteration, it should be checked after Next returns false.
+func (it *Iterator[T]) Err() error {
+	select {
+	case <-it.ctx.Done():
+		// Wait for the background request to end, whose error reflects the cancellation.
+		for range it.rows {
+		}
+	default:
+	}
+	return it.err
+}
+
+// Close stops the iteration and waits for the background request to end.
+// It returns the error of the request, if any.
+// It is safe to call Close multiple times.
+func (it *Iterator[T]) Close() error {
+	it.once.Do(func() {
+		close(it.done)
+	})
+	for range it.rows {
+	}
+	return it.err
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// scanRow scans a row into dest, which is a pointer.
+func scanRow(rows *sqlx.Rows, dest interface{}) error {
+	t := reflect.TypeOf(dest)
+	if t.Elem().Kind() == reflect.Struct && t.Elem() != timeType && !t.Implements(scannerType) {
+		return rows.StructScan(dest)
+	}
+	return rows.Scan(dest)
+}
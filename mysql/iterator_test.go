@@ -0,0 +1,78 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"trpc.group/trpc-go/trpc-go"
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+func TestQueryIter(t *testing.T) {
+	c := NewClientProxy(MySQLName)
+	patches := prepareTestData(t, c)
+	defer patches.Reset()
+
+	t.Run("iterate structs", func(t *testing.T) {
+		it := QueryIter[*User](trpc.BackgroundContext(), c, "SELECT * FROM user WHERE age >= ? ORDER BY id", 18)
+		var users []*User
+		for it.Next() {
+			users = append(users, it.Value())
+		}
+		require.NoError(t, it.Err())
+		require.NoError(t, it.Close())
+		require.Equal(t, []*User{{4, "Boo", 18}, {10, "King", 18}, {100, "Haha", 20}}, users)
+	})
+	t.Run("iterate scalars", func(t *testing.T) {
+		it := QueryIter[string](trpc.BackgroundContext(), c, "SELECT name FROM user ORDER BY id")
+		defer it.Close()
+		var names []string
+		for it.Next() {
+			names = append(names, it.Value())
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, []string{"Jobs", "Alice", "Foo", "Boo", "King", "Haha"}, names)
+	})
+	t.Run("close early", func(t *testing.T) {
+		it := QueryIter[User](trpc.BackgroundContext(), c, "SELECT * FROM user ORDER BY id")
+		require.True(t, it.Next())
+		require.Equal(t, User{1, "Jobs", 15}, it.Value())
+		require.NoError(t, it.Close())
+		require.False(t, it.Next())
+		require.NoError(t, it.Close())
+	})
+	t.Run("cancel context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(trpc.BackgroundContext())
+		it := QueryIter[User](ctx, c, "SELECT * FROM user ORDER BY id")
+		defer it.Close()
+		require.True(t, it.Next())
+		cancel()
+		for it.Next() {
+		}
+		require.Error(t, it.Err())
+	})
+	t.Run("scan error", func(t *testing.T) {
+		it := QueryIter[int](trpc.BackgroundContext(), c, "SELECT id, name FROM user")
+		defer it.Close()
+		require.False(t, it.Next())
+		require.Error(t, it.Err())
+	})
+}
+
+func TestQueryIter_InvokeError(t *testing.T) {
+	client.DefaultClient = &mockClient{func(ctx context.Context, req interface{}, rsp interface{},
+		opts ...client.Option) error {
+		return errors.New("fake error")
+	}}
+	defer func() {
+		client.DefaultClient = client.New()
+	}()
+
+	it := QueryIter[User](context.Background(), NewClientProxy(MySQLName), "SELECT * FROM user")
+	require.False(t, it.Next())
+	require.EqualError(t, it.Err(), "fake error")
+	require.EqualError(t, it.Close(), "fake error")
+}
@@ -37,6 +37,26 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
+// BatchInsert mocks base method.
+func (m *MockClient) BatchInsert(ctx context.Context, table string, rows interface{}, opts ...mysql.BatchOption) (int64, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, table, rows}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "BatchInsert", varargs...)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BatchInsert indicates an expected call of BatchInsert.
+func (mr *MockClientMockRecorder) BatchInsert(ctx, table, rows interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, table, rows}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchInsert", reflect.TypeOf((*MockClient)(nil).BatchInsert), varargs...)
+}
+
 // Exec mocks base method.
 func (m *MockClient) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
 	m.ctrl.T.Helper()
@@ -182,6 +202,25 @@ func (mr *MockClientMockRecorder) QueryToStructs(ctx, dst, query interface{}, ar
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryToStructs", reflect.TypeOf((*MockClient)(nil).QueryToStructs), varargs...)
 }
 
+// Queryx mocks base method.
+func (m *MockClient) Queryx(ctx context.Context, next mysql.NextxFunc, query string, args ...interface{}) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, next, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Queryx", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Queryx indicates an expected call of Queryx.
+func (mr *MockClientMockRecorder) Queryx(ctx, next, query interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, next, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Queryx", reflect.TypeOf((*MockClient)(nil).Queryx), varargs...)
+}
+
 // Select mocks base method.
 func (m *MockClient) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
 	m.ctrl.T.Helper()
@@ -238,3 +277,23 @@ func (mr *MockClientMockRecorder) Transactionx(ctx, fn interface{}, opts ...inte
 	varargs := append([]interface{}{ctx, fn}, opts...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transactionx", reflect.TypeOf((*MockClient)(nil).Transactionx), varargs...)
 }
+
+// Upsert mocks base method.
+func (m *MockClient) Upsert(ctx context.Context, table string, rows interface{}, updateColumns []string, opts ...mysql.BatchOption) (int64, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, table, rows, updateColumns}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Upsert", varargs...)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Upsert indicates an expected call of Upsert.
+func (mr *MockClientMockRecorder) Upsert(ctx, table, rows, updateColumns interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, table, rows, updateColumns}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upsert", reflect.TypeOf((*MockClient)(nil).Upsert), varargs...)
+}
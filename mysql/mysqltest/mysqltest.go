@@ -0,0 +1,162 @@
+// Package mysqltest provides a mysql.Client backed by an embedded SQLite database for unit tests.
+//
+// The client is a real mysql.Client: requests go through the tRPC client and mysql.ClientTransport
+// just like production, except that the statements are executed by SQLite instead of a MySQL server.
+// So repository code can be tested end-to-end without a MySQL server or scripting every call of a mock.
+//
+// Note that SQLite understands most but not all MySQL syntax, for example AUTO_INCREMENT must be written as
+// INTEGER PRIMARY KEY AUTOINCREMENT, and ON DUPLICATE KEY UPDATE (used by Upsert) is not supported.
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	// Register the sqlite3 driver.
+	_ "github.com/mattn/go-sqlite3"
+
+	"trpc.group/trpc-go/trpc-database/mysql"
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+const driverName = "sqlite3"
+
+// DB is a SQLite database serving a mysql.Client.
+type DB struct {
+	mysql.Client
+
+	// SQLDB is the underlying database, which is shared with the client.
+	// It can be used to prepare or check data without going through the client.
+	SQLDB *sql.DB
+	// Transport is the transport of the client.
+	Transport *mysql.ClientTransport
+	// ServiceName is the callee service name of the client.
+	ServiceName string
+}
+
+// serviceSeq makes the default service names unique within the process.
+var serviceSeq uint64
+
+// New creates a database in the temporary directory of tb, and returns a client of it.
+// The fixtures set by WithFiles and WithStatements are loaded in order before New returns,
+// and the database is closed when the test ends.
+//
+//	db := mysqltest.New(t, mysqltest.WithFiles("testdata/schema.sql", "testdata/users.sql"))
+//	repo := NewUserRepo(db) // db is a mysql.Client.
+func New(tb testing.TB, opts ...Option) *DB {
+	tb.Helper()
+	o := &options{
+		serviceName: fmt.Sprintf("trpc.mysql.mysqltest.db%d", atomic.AddUint64(&serviceSeq, 1)),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	// A file database is used instead of an in-memory one, since each connection of the pool
+	// would have its own in-memory database.
+	dsn := "file:" + filepath.Join(tb.TempDir(), "mysqltest.db") + "?_busy_timeout=5000"
+	ct := mysql.NewClientTransport().(*mysql.ClientTransport)
+	ct.DriverName = driverName
+	sqlDB, err := ct.GetDB(dsn)
+	if err != nil {
+		tb.Fatalf("mysqltest: open database error: %v", err)
+	}
+	tb.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	clientOpts := append([]client.Option{
+		client.WithTarget("dsn://" + dsn),
+		client.WithTransport(ct),
+	}, o.clientOpts...)
+	newClient := mysql.NewClientProxy
+	if o.unsafe {
+		newClient = mysql.NewUnsafeClient
+	}
+	db := &DB{
+		Client:      newClient(o.serviceName, clientOpts...),
+		SQLDB:       sqlDB,
+		Transport:   ct,
+		ServiceName: o.serviceName,
+	}
+	for _, f := range o.fixtures {
+		if err := f(db); err != nil {
+			tb.Fatalf("mysqltest: load fixture error: %v", err)
+		}
+	}
+	return db
+}
+
+// ExecFile executes the SQL statements in the file, which are separated by semicolons.
+func (db *DB) ExecFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := db.SQLDB.ExecContext(context.Background(), string(b)); err != nil {
+		return fmt.Errorf("exec %s error: %w", path, err)
+	}
+	return nil
+}
+
+// Option options of New.
+type Option func(*options)
+
+type options struct {
+	serviceName string
+	unsafe      bool
+	clientOpts  []client.Option
+	fixtures    []func(*DB) error
+}
+
+// WithServiceName sets the callee service name of the client, which is unique per database by default.
+func WithServiceName(name string) Option {
+	return func(o *options) {
+		o.serviceName = name
+	}
+}
+
+// WithUnsafe creates the client by mysql.NewUnsafeClient instead of mysql.NewClientProxy.
+func WithUnsafe() Option {
+	return func(o *options) {
+		o.unsafe = true
+	}
+}
+
+// WithClientOptions appends options of the client, such as client.WithTimeout.
+func WithClientOptions(opts ...client.Option) Option {
+	return func(o *options) {
+		o.clientOpts = append(o.clientOpts, opts...)
+	}
+}
+
+// WithFiles loads fixtures from SQL files, such as the schema and the initial data.
+// Files are executed in order, and the statements in a file are separated by semicolons.
+func WithFiles(paths ...string) Option {
+	return func(o *options) {
+		for _, path := range paths {
+			path := path
+			o.fixtures = append(o.fixtures, func(db *DB) error {
+				return db.ExecFile(path)
+			})
+		}
+	}
+}
+
+// WithStatements loads fixtures from SQL statements, which are executed in order along with WithFiles.
+func WithStatements(stmts ...string) Option {
+	return func(o *options) {
+		for _, stmt := range stmts {
+			stmt := stmt
+			o.fixtures = append(o.fixtures, func(db *DB) error {
+				_, err := db.SQLDB.ExecContext(context.Background(), stmt)
+				return err
+			})
+		}
+	}
+}
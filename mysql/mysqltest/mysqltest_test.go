@@ -0,0 +1,75 @@
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"trpc.group/trpc-go/trpc-database/mysql"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+	Age  int    `db:"age"`
+}
+
+func TestNew(t *testing.T) {
+	db := New(t, WithFiles("testdata/schema.sql", "testdata/users.sql"),
+		WithStatements("INSERT INTO user (name, age) VALUES ('Foo', 17)"))
+	ctx := context.Background()
+
+	var users []user
+	require.NoError(t, db.Select(ctx, &users, "SELECT * FROM user ORDER BY id"))
+	require.Equal(t, []user{{1, "Jobs", 15}, {2, "Alice", 16}, {3, "Foo", 17}}, users)
+
+	result, err := db.Exec(ctx, "UPDATE user SET age = age + 1 WHERE age > ?", 15)
+	require.NoError(t, err)
+	n, err := result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	err = db.Transaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM user WHERE id = 1")
+		return err
+	})
+	require.NoError(t, err)
+	var count int
+	require.NoError(t, db.SQLDB.QueryRow("SELECT COUNT(*) FROM user").Scan(&count))
+	require.Equal(t, 2, count)
+
+	var u user
+	err = db.Get(ctx, &u, "SELECT * FROM user WHERE id = ?", 1)
+	require.True(t, mysql.IsNoRowsError(err))
+
+	it := mysql.QueryIter[user](ctx, db, "SELECT * FROM user ORDER BY id")
+	defer it.Close()
+	var names []string
+	for it.Next() {
+		names = append(names, it.Value().Name)
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, []string{"Alice", "Foo"}, names)
+}
+
+func TestNew_Isolated(t *testing.T) {
+	db1 := New(t, WithFiles("testdata/schema.sql"))
+	db2 := New(t, WithServiceName("trpc.mysql.test.user"), WithUnsafe())
+	require.NotEqual(t, db1.ServiceName, db2.ServiceName)
+	require.Equal(t, "trpc.mysql.test.user", db2.ServiceName)
+
+	ctx := context.Background()
+	_, err := db1.Exec(ctx, "INSERT INTO user (name) VALUES ('Jobs')")
+	require.NoError(t, err)
+	_, err = db2.Exec(ctx, "INSERT INTO user (name) VALUES ('Jobs')")
+	require.Error(t, err, "db2 has no table user")
+}
+
+func TestDB_ExecFile(t *testing.T) {
+	db := New(t)
+	require.Error(t, db.ExecFile("testdata/nonexistent.sql"))
+	require.NoError(t, db.ExecFile("testdata/schema.sql"))
+	require.Error(t, db.ExecFile("testdata/schema.sql"), "table user already exists")
+}
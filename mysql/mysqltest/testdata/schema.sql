@@ -0,0 +1,5 @@
+CREATE TABLE user (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(64) NOT NULL DEFAULT '',
+    age INTEGER NOT NULL DEFAULT 0
+);
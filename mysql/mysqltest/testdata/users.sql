@@ -0,0 +1,3 @@
+-- Initial users.
+INSERT INTO user (id, name, age) VALUES (1, 'Jobs', 15);
+INSERT INTO user (id, name, age) VALUES (2, 'Alice', 16);
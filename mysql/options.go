@@ -18,3 +18,35 @@ func WithTxReadOnly(readOnly bool) TxOption {
 		o.ReadOnly = readOnly
 	}
 }
+
+// BatchOption options of BatchInsert and Upsert.
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	batchSize     int
+	maxPacketSize int
+	omitColumns   []string
+}
+
+// WithBatchSize sets the maximum number of rows of each insert statement.
+// By default, it is only limited by the packet size and the placeholder limit of MySQL.
+func WithBatchSize(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.batchSize = n
+	}
+}
+
+// WithMaxPacketSize sets the estimated maximum size in bytes of each insert statement,
+// which should not exceed the max_allowed_packet of the MySQL server. The default is 4MB.
+func WithMaxPacketSize(size int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxPacketSize = size
+	}
+}
+
+// WithOmitColumns excludes the columns from insert statements, such as an auto-increment primary key.
+func WithOmitColumns(columns ...string) BatchOption {
+	return func(o *batchOptions) {
+		o.omitColumns = columns
+	}
+}
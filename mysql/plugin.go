@@ -1,9 +1,16 @@
 package mysql
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"errors"
+	"fmt"
+	"os"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+
 	"trpc.group/trpc-go/trpc-go/plugin"
 	"trpc.group/trpc-go/trpc-go/transport"
 )
@@ -17,12 +24,52 @@ func init() {
 	plugin.Register(pluginName, &Plugin{})
 }
 
+// defaultStatsReporter reports the pool statistics of DefaultClientTransport set up by the plugin.
+var defaultStatsReporter *statsReporter
+
 // Config mysql Proxy configuration structure declaration.
 type Config struct {
-	MaxIdle     int    `yaml:"max_idle"`     // Maximum number of idle connections
-	MaxOpen     int    `yaml:"max_open"`     // Maximum number of simultaneous online connections
-	MaxLifetime int    `yaml:"max_lifetime"` // Maximum lifetime per connection, in milliseconds
-	DriverName  string `yaml:"driver_name"`  // The driver name used, default is mysql
+	MaxIdle     int    `yaml:"max_idle"`      // Maximum number of idle connections
+	MaxOpen     int    `yaml:"max_open"`      // Maximum number of simultaneous online connections
+	MaxLifetime int    `yaml:"max_lifetime"`  // Maximum lifetime per connection, in milliseconds
+	MaxIdleTime int    `yaml:"max_idle_time"` // Maximum idle time per connection, in milliseconds
+	DriverName  string `yaml:"driver_name"`   // The driver name used, default is mysql
+	// Maximum number of prepared statements cached per connection pool, 0 means no cache.
+	StmtCacheSize int `yaml:"stmt_cache_size"`
+	// Statements taking longer than it are logged as slow queries, in milliseconds, 0 means no logging.
+	SlowThreshold int `yaml:"slow_threshold"`
+	// Whether to use the normalized SQL digest instead of the method name as the RPC name of the message.
+	SQLDigest bool `yaml:"sql_digest"`
+	// Interval of reporting connection pool statistics through metrics, in milliseconds, 0 means no reporting.
+	StatsInterval int `yaml:"stats_interval"`
+	// Replica callee service names keyed by the primary callee service name, used for read/write splitting.
+	Replicas map[string][]string `yaml:"replicas"`
+	// Connection pool configurations of each callee service, the unset fields inherit the global ones above.
+	Service []ServiceConfig `yaml:"service"`
+}
+
+// ServiceConfig is the connection pool configuration of a callee service.
+type ServiceConfig struct {
+	Name          string     `yaml:"name"`            // Callee service name
+	MaxIdle       int        `yaml:"max_idle"`        // Maximum number of idle connections
+	MaxOpen       int        `yaml:"max_open"`        // Maximum number of simultaneous online connections
+	MaxLifetime   int        `yaml:"max_lifetime"`    // Maximum lifetime per connection, in milliseconds
+	MaxIdleTime   int        `yaml:"max_idle_time"`   // Maximum idle time per connection, in milliseconds
+	DriverName    string     `yaml:"driver_name"`     // The driver name used
+	StmtCacheSize int        `yaml:"stmt_cache_size"` // Maximum number of cached prepared statements, -1 means no cache
+	TLS           *TLSConfig `yaml:"tls"`             // TLS settings of the connections
+}
+
+// TLSConfig is the TLS configuration of the connections to mysql.
+type TLSConfig struct {
+	// Name of the TLS config registered by mysql.RegisterTLSConfig, or one of "true", "skip-verify" and "preferred".
+	// The other fields are ignored if it is set.
+	Name               string `yaml:"name"`
+	CAFile             string `yaml:"ca_file"`              // CA certificate file to verify the server
+	CertFile           string `yaml:"cert_file"`            // Client certificate file
+	KeyFile            string `yaml:"key_file"`             // Client private key file
+	ServerName         string `yaml:"server_name"`          // Server name to verify, default is the host of DSN
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Whether to skip the verification of the server
 }
 
 func (c *Config) setDefault() {
@@ -46,14 +93,111 @@ func (m *Plugin) Setup(name string, configDesc plugin.Decoder) (err error) {
 		return
 	}
 	config.setDefault()
-	DefaultClientTransport = &ClientTransport{
+	ct := &ClientTransport{
 		dbs:         make(map[string]*sql.DB),
 		MaxIdle:     config.MaxIdle,
 		MaxOpen:     config.MaxOpen,
 		MaxLifetime: time.Duration(config.MaxLifetime) * time.Millisecond,
+		MaxIdleTime: time.Duration(config.MaxIdleTime) * time.Millisecond,
 		DriverName:  config.DriverName,
+
+		StmtCacheSize: config.StmtCacheSize,
+		SlowThreshold: time.Duration(config.SlowThreshold) * time.Millisecond,
+	}
+	if len(config.Service) > 0 {
+		ct.PoolConfigs = make(map[string]PoolConfig, len(config.Service))
+	}
+	for _, s := range config.Service {
+		poolConfig, err := newPoolConfig(ct, s)
+		if err != nil {
+			return fmt.Errorf("mysql service %s config error: %w", s.Name, err)
+		}
+		ct.PoolConfigs[s.Name] = poolConfig
+	}
+	DefaultClientTransport = ct
+	setSQLDigest(config.SQLDigest)
+	defaultStatsReporter.stop()
+	defaultStatsReporter = nil
+	if config.StatsInterval > 0 {
+		defaultStatsReporter = startStatsReporter(ct, time.Duration(config.StatsInterval)*time.Millisecond)
+	}
+	for primary, replicaNames := range config.Replicas {
+		RegisterReplicas(primary, replicaNames...)
 	}
 	// register needs to be called explicitly, otherwise the configuration will not take effect.
 	transport.RegisterClientTransport("mysql", DefaultClientTransport)
 	return nil
 }
+
+// newPoolConfig returns the pool configuration of the service, whose unset fields inherit from ct.
+func newPoolConfig(ct *ClientTransport, s ServiceConfig) (PoolConfig, error) {
+	poolConfig := PoolConfig{
+		MaxIdle:     ct.MaxIdle,
+		MaxOpen:     ct.MaxOpen,
+		MaxLifetime: ct.MaxLifetime,
+		MaxIdleTime: ct.MaxIdleTime,
+		DriverName:  ct.DriverName,
+
+		StmtCacheSize: ct.StmtCacheSize,
+	}
+	if s.MaxIdle != 0 {
+		poolConfig.MaxIdle = s.MaxIdle
+	}
+	if s.MaxOpen != 0 {
+		poolConfig.MaxOpen = s.MaxOpen
+	}
+	if s.MaxLifetime != 0 {
+		poolConfig.MaxLifetime = time.Duration(s.MaxLifetime) * time.Millisecond
+	}
+	if s.MaxIdleTime != 0 {
+		poolConfig.MaxIdleTime = time.Duration(s.MaxIdleTime) * time.Millisecond
+	}
+	if s.DriverName != "" {
+		poolConfig.DriverName = s.DriverName
+	}
+	if s.StmtCacheSize != 0 {
+		poolConfig.StmtCacheSize = s.StmtCacheSize
+	}
+	if s.TLS != nil {
+		name, err := registerTLSConfig(s.Name, s.TLS)
+		if err != nil {
+			return PoolConfig{}, err
+		}
+		poolConfig.TLSConfig = name
+	}
+	return poolConfig, nil
+}
+
+// registerTLSConfig registers the TLS configuration of the service to the mysql driver and returns its name.
+func registerTLSConfig(serviceName string, c *TLSConfig) (string, error) {
+	if c.Name != "" {
+		return c.Name, nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return "", fmt.Errorf("read ca file error: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", errors.New("no certificate found in ca file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return "", fmt.Errorf("load client certificate error: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	name := "trpc-" + serviceName
+	if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -1,12 +1,15 @@
 package mysql
 
 import (
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 	"trpc.group/trpc-go/trpc-go"
 	"trpc.group/trpc-go/trpc-go/plugin"
+	"trpc.group/trpc-go/trpc-go/transport"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -50,5 +53,106 @@ plugins:
 			err = mp.Setup(pluginName, &plugin.YamlNodeDecoder{Node: yamlNode})
 			So(err, ShouldBeNil)
 		})
+		Convey("Setup Services", func() {
+			var bts = `
+plugins:
+  database:
+    mysql:
+      max_idle: 20
+      max_open: 100
+      max_lifetime: 180000
+      stats_interval: 60000
+      slow_threshold: 500
+      sql_digest: true
+      stmt_cache_size: 100
+      service:
+        - name: trpc.mysql.test.olap
+          max_open: 10
+          max_idle_time: 60000
+          driver_name: sqlite3
+          stmt_cache_size: -1
+          tls:
+            name: skip-verify
+        - name: trpc.mysql.test.oltp
+          max_idle: 50
+          tls:
+            insecure_skip_verify: true
+`
+
+			var cfg = trpc.Config{}
+			err := yaml.Unmarshal([]byte(bts), &cfg)
+			assert.Nil(t, err)
+			node := cfg.Plugins[pluginType][pluginName]
+			err = mp.Setup(pluginName, &plugin.YamlNodeDecoder{Node: &node})
+			So(err, ShouldBeNil)
+			ct, ok := transport.GetClientTransport(pluginName).(*ClientTransport)
+			So(ok, ShouldBeTrue)
+			So(defaultStatsReporter, ShouldNotBeNil)
+			defaultStatsReporter.stop()
+			So(ct.SlowThreshold, ShouldEqual, 500*time.Millisecond)
+			So(atomic.LoadInt32(&sqlDigest), ShouldEqual, 1)
+			setSQLDigest(false)
+			So(ct.StmtCacheSize, ShouldEqual, 100)
+			So(ct.PoolConfigs, ShouldResemble, map[string]PoolConfig{
+				"trpc.mysql.test.olap": {
+					MaxIdle:     20,
+					MaxOpen:     10,
+					MaxLifetime: 180 * time.Second,
+					MaxIdleTime: time.Minute,
+					DriverName:  "sqlite3",
+					TLSConfig:   "skip-verify",
+
+					StmtCacheSize: -1,
+				},
+				"trpc.mysql.test.oltp": {
+					MaxIdle:     50,
+					MaxOpen:     100,
+					MaxLifetime: 180 * time.Second,
+					DriverName:  defaultDriverName,
+					TLSConfig:   "trpc-trpc.mysql.test.oltp",
+
+					StmtCacheSize: 100,
+				},
+			})
+		})
+		Convey("Setup Services Fail", func() {
+			var bts = `
+plugins:
+  database:
+    mysql:
+      service:
+        - name: trpc.mysql.test.db
+          tls:
+            ca_file: not_exist.pem
+`
+
+			var cfg = trpc.Config{}
+			err := yaml.Unmarshal([]byte(bts), &cfg)
+			assert.Nil(t, err)
+			node := cfg.Plugins[pluginType][pluginName]
+			err = mp.Setup(pluginName, &plugin.YamlNodeDecoder{Node: &node})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Setup Replicas", func() {
+			var bts = `
+plugins:
+  database:
+    mysql:
+      replicas:
+        trpc.mysql.test.primary:
+          - trpc.mysql.test.replica1
+          - trpc.mysql.test.replica2
+`
+
+			var cfg = trpc.Config{}
+			err := yaml.Unmarshal([]byte(bts), &cfg)
+			assert.Nil(t, err)
+			node := cfg.Plugins[pluginType][pluginName]
+			err = mp.Setup(pluginName, &plugin.YamlNodeDecoder{Node: &node})
+			So(err, ShouldBeNil)
+			defer RegisterReplicas("trpc.mysql.test.primary")
+			So(getReplicas("trpc.mysql.test.primary"), ShouldResemble,
+				[]string{"trpc.mysql.test.replica1", "trpc.mysql.test.replica2"})
+		})
 	})
 }
@@ -0,0 +1,87 @@
+package mysql
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+// replicas holds the replica callee service names keyed by the primary callee service name.
+var (
+	replicas     = make(map[string][]string)
+	replicasLock sync.RWMutex
+)
+
+// RegisterReplicas registers the replica services of a primary service.
+// Read operations (Query, QueryRow, Get, Select, QueryToStruct and QueryToStructs) issued by the client
+// of the primary service are routed to the replicas in turn, while writes and transactions stay on the primary.
+// Each replica is an ordinary tRPC client service, whose target, timeout and filters are read from the
+// client config by its own name; the target and service name options of the primary client are not
+// inherited. Calling it with no replicas removes the routing of the primary.
+func RegisterReplicas(primary string, replicaNames ...string) {
+	replicasLock.Lock()
+	defer replicasLock.Unlock()
+	if len(replicaNames) == 0 {
+		delete(replicas, primary)
+		return
+	}
+	replicas[primary] = append([]string(nil), replicaNames...)
+}
+
+// getReplicas returns the replica services registered for the primary service.
+func getReplicas(primary string) []string {
+	replicasLock.RLock()
+	defer replicasLock.RUnlock()
+	return replicas[primary]
+}
+
+type primaryKey struct{}
+
+// WithPrimary returns a context that forces read operations to be sent to the primary service,
+// which is useful when reading data that was just written (read-your-writes).
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// isPrimaryForced reports whether the primary service is forced by WithPrimary.
+func isPrimaryForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryKey{}).(bool)
+	return forced
+}
+
+// replicaPicker picks replica services in round-robin order.
+type replicaPicker struct {
+	next uint32
+}
+
+// pick returns the callee service name used by a read operation.
+// It falls back to the primary if no replica is registered or the primary is forced.
+func (p *replicaPicker) pick(ctx context.Context, primary string) string {
+	if isPrimaryForced(ctx) {
+		return primary
+	}
+	names := getReplicas(primary)
+	if len(names) == 0 {
+		return primary
+	}
+	n := atomic.AddUint32(&p.next, 1)
+	return names[(n-1)%uint32(len(names))]
+}
+
+// replicaOptions returns the options of the calls to the replica services, which are the options of the
+// primary except those addressing the primary by a target or a service name, so that each replica is
+// addressed by its own client config.
+func replicaOptions(opts []client.Option) []client.Option {
+	replicaOpts := make([]client.Option, 0, len(opts))
+	for _, opt := range opts {
+		o := &client.Options{}
+		opt(o)
+		if o.Target != "" || o.ServiceName != "" {
+			continue
+		}
+		replicaOpts = append(replicaOpts, opt)
+	}
+	return replicaOpts
+}
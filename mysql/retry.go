@@ -0,0 +1,75 @@
+package mysql
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// maxBackoffShift limits the exponential growth of the retry delay.
+const maxBackoffShift = 10
+
+// txRetryKey is the context key of the retry policy of transactions.
+type txRetryKey struct{}
+
+// WithTxRetry returns a context whose Transaction and Transactionx retry the whole transaction up to max times
+// when it fails with a deadlock (1213) or a lock wait timeout (1205), fn must be safe to run again.
+// The delay before the n-th retry is a random duration in [backoff*2^(n-1)/2, backoff*2^(n-1)].
+// It is ignored if ctx carries a transaction by ContextWithTx or ContextWithTxx,
+// since MySQL rolls back the whole outer transaction on a deadlock.
+func WithTxRetry(ctx context.Context, max int, backoff time.Duration) context.Context {
+	return context.WithValue(ctx, txRetryKey{}, &txRetry{max: max, backoff: backoff})
+}
+
+// txRetryFromContext returns the retry policy set by WithTxRetry, or nil if not set.
+func txRetryFromContext(ctx context.Context) *txRetry {
+	r, _ := ctx.Value(txRetryKey{}).(*txRetry)
+	return r
+}
+
+// txRetry is the retry policy of a transaction.
+type txRetry struct {
+	max     int
+	backoff time.Duration
+}
+
+// run calls fn, and calls it again on deadlocks and lock wait timeouts according to the policy.
+// A nil policy calls fn only once.
+func (r *txRetry) run(ctx context.Context, fn func() error) error {
+	err := fn()
+	if r == nil {
+		return err
+	}
+	if hasOuterTx(ctx) {
+		return err
+	}
+	for i := 0; i < r.max && isRetryableTxError(err); i++ {
+		if d := r.delay(i); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		err = fn()
+	}
+	return err
+}
+
+// delay returns the jittered delay before the (i+1)-th retry.
+func (r *txRetry) delay(i int) time.Duration {
+	if r.backoff <= 0 {
+		return 0
+	}
+	if i > maxBackoffShift {
+		i = maxBackoffShift
+	}
+	d := r.backoff << i
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func isRetryableTxError(err error) bool {
+	return IsDeadlockError(err) || IsLockWaitTimeoutError(err)
+}
@@ -0,0 +1,103 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"trpc.group/trpc-go/trpc-go/client"
+)
+
+func TestWithTxRetry(t *testing.T) {
+	ctx := WithTxRetry(context.Background(), 3, time.Millisecond)
+	require.Equal(t, &txRetry{max: 3, backoff: time.Millisecond}, txRetryFromContext(ctx))
+	require.Nil(t, txRetryFromContext(context.Background()))
+}
+
+func Test_txRetry_run(t *testing.T) {
+	newFn := func(errs ...error) (func() error, *int) {
+		var calls int
+		return func() error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}, &calls
+	}
+
+	t.Run("nil policy", func(t *testing.T) {
+		fn, calls := newFn(deadlockError)
+		var r *txRetry
+		require.Equal(t, deadlockError, r.run(context.Background(), fn))
+		require.Equal(t, 1, *calls)
+	})
+	t.Run("retry until success", func(t *testing.T) {
+		fn, calls := newFn(deadlockError, lockWaitError)
+		r := &txRetry{max: 3, backoff: time.Millisecond}
+		require.NoError(t, r.run(context.Background(), fn))
+		require.Equal(t, 3, *calls)
+	})
+	t.Run("exceed max retries", func(t *testing.T) {
+		fn, calls := newFn(deadlockError, deadlockError, deadlockError)
+		r := &txRetry{max: 2}
+		require.Equal(t, deadlockError, r.run(context.Background(), fn))
+		require.Equal(t, 3, *calls)
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		fn, calls := newFn(dupEntryError)
+		r := &txRetry{max: 2}
+		require.Equal(t, dupEntryError, r.run(context.Background(), fn))
+		require.Equal(t, 1, *calls)
+	})
+	t.Run("nested transaction", func(t *testing.T) {
+		fn, calls := newFn(deadlockError)
+		r := &txRetry{max: 2}
+		ctx := ContextWithTx(context.Background(), new(sql.Tx))
+		require.Equal(t, deadlockError, r.run(ctx, fn))
+		require.Equal(t, 1, *calls)
+	})
+	t.Run("context done", func(t *testing.T) {
+		fn, calls := newFn(deadlockError)
+		r := &txRetry{max: 2, backoff: time.Hour}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.Equal(t, deadlockError, r.run(ctx, fn))
+		require.Equal(t, 1, *calls)
+	})
+}
+
+func Test_txRetry_delay(t *testing.T) {
+	r := &txRetry{backoff: 10 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		d := r.delay(i)
+		require.GreaterOrEqual(t, d, (10*time.Millisecond<<i)/2)
+		require.LessOrEqual(t, d, 10*time.Millisecond<<i)
+	}
+	require.LessOrEqual(t, r.delay(100), 10*time.Millisecond<<maxBackoffShift)
+}
+
+func TestMysqlCli_TransactionRetry(t *testing.T) {
+	var calls int
+	client.DefaultClient = &mockClient{func(ctx context.Context, req interface{}, rsp interface{},
+		opts ...client.Option) error {
+		calls++
+		if calls == 1 {
+			return deadlockError
+		}
+		return nil
+	}}
+	defer func() {
+		client.DefaultClient = client.New()
+	}()
+
+	c := NewClientProxy(MySQLName)
+	require.NoError(t, c.Transaction(WithTxRetry(context.Background(), 1, 0), mockTx))
+	require.Equal(t, 2, calls)
+	calls = 0
+	require.NoError(t, c.Transactionx(WithTxRetry(context.Background(), 1, 0), mockTxx, WithTxReadOnly(false)))
+	require.Equal(t, 2, calls)
+}
@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+type txKey struct{}
+
+// txDBs holds the connection pools of the transactions in progress begun by Transaction and Transactionx,
+// keyed by the transactions. Each entry is deleted once its transaction ends.
+var txDBs sync.Map
+
+// trackTx records that tx is begun on db until the returned function is called.
+func trackTx(tx *sql.Tx, db *sql.DB) (end func()) {
+	txDBs.Store(tx, db)
+	return func() { txDBs.Delete(tx) }
+}
+
+// outerTx is the transaction carried in ctx, which is either a sql.Tx or a sqlx.Tx.
+type outerTx struct {
+	tx  *sql.Tx
+	txx *sqlx.Tx
+	// db is the connection pool of the service and DSN which tx is begun on,
+	// nil if tx is not begun by Transaction or Transactionx.
+	db *sql.DB
+}
+
+func newOuterTx(tx *sql.Tx, txx *sqlx.Tx) *outerTx {
+	db, _ := txDBs.Load(tx)
+	outer := &outerTx{tx: tx, txx: txx}
+	outer.db, _ = db.(*sql.DB)
+	return outer
+}
+
+// ContextWithTx returns a context carrying the transaction tx.
+// Transaction and Transactionx called with the returned context (or its children) do not begin a new transaction,
+// but run in tx through SAVEPOINT instead: they roll back to the savepoint if fn returns an error,
+// and leave the commit or rollback of tx to its owner. Transaction options of the nested calls are ignored.
+// Only the calls to the same service and DSN as tx are nested, and tx must be begun by Transaction of a
+// Client. The calls to other services or DSNs, which are other databases, begin transactions of their own.
+//
+//	err := proxy.Transaction(ctx, func(tx *sql.Tx) error {
+//		// repo.CreateOrder calls proxy.Transaction itself, which is nested in tx.
+//		return repo.CreateOrder(mysql.ContextWithTx(ctx, tx), order)
+//	})
+func ContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, newOuterTx(tx, nil))
+}
+
+// ContextWithTxx is similar to ContextWithTx, except that it carries a sqlx transaction begun by Transactionx.
+func ContextWithTxx(ctx context.Context, tx *sqlx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, newOuterTx(tx.Tx, tx))
+}
+
+// hasOuterTx reports whether ctx carries a transaction, whichever service and DSN it is begun on.
+func hasOuterTx(ctx context.Context) bool {
+	tx, ok := ctx.Value(txKey{}).(*outerTx)
+	return ok && tx.tx != nil
+}
+
+// outerTxFromContext returns the transaction carried in ctx, if it is begun on db.
+func outerTxFromContext(ctx context.Context, db *sql.DB) (*outerTx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*outerTx)
+	return tx, ok && tx.tx != nil && tx.db != nil && tx.db == db
+}
+
+// sqlxTx returns the transaction as a sqlx.Tx.
+func (t *outerTx) sqlxTx(unsafe bool) *sqlx.Tx {
+	tx := t.txx
+	if tx == nil {
+		tx = &sqlx.Tx{Tx: t.tx, Mapper: reflectx.NewMapperFunc("db", sqlx.NameMapper)}
+	}
+	if unsafe {
+		return tx.Unsafe()
+	}
+	return tx
+}
+
+// savepointSeq makes savepoint names unique within the process.
+var savepointSeq uint64
+
+// runInSavepoint runs fn in a savepoint of tx, and rolls back to the savepoint if fn returns an error.
+func runInSavepoint(ctx context.Context, tx *sql.Tx, fn func() error) error {
+	name := fmt.Sprintf("trpc_sp_%d", atomic.AddUint64(&savepointSeq, 1))
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("create savepoint error: %w", err)
+	}
+	if err := fn(); err != nil {
+		if _, e := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); e != nil {
+			return fmt.Errorf("transaction error: %s, and rollback to savepoint error: %w", err.Error(), e)
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("release savepoint error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"trpc.group/trpc-go/trpc-go"
+)
+
+func TestNestedTransaction(t *testing.T) {
+	c := NewClientProxy(MySQLName)
+	patches := prepareTestData(t, c)
+	defer patches.Reset()
+	ctx := trpc.BackgroundContext()
+	errInner := errors.New("inner error")
+
+	insert := func(ctx context.Context, id int) error {
+		return c.Transaction(ctx, func(tx *sql.Tx) error {
+			_, err := tx.Exec("INSERT INTO user (id, name, age) VALUES (?, 'Nested', 1)", id)
+			return err
+		})
+	}
+	err := c.Transaction(ctx, func(tx *sql.Tx) error {
+		txCtx := ContextWithTx(ctx, tx)
+		if err := insert(txCtx, 200); err != nil {
+			return err
+		}
+		err := c.Transaction(txCtx, func(tx *sql.Tx) error {
+			if err := insert(txCtx, 201); err != nil {
+				return err
+			}
+			return errInner
+		})
+		require.ErrorContains(t, err, errInner.Error())
+		return c.Transactionx(txCtx, func(tx *sqlx.Tx) error {
+			_, err := tx.NamedExec("INSERT INTO user (id, name, age) VALUES (:id, :name, :age)",
+				&User{ID: 202, Name: "Nestedx", Age: 2})
+			return err
+		})
+	})
+	require.NoError(t, err)
+
+	var ids []int
+	require.NoError(t, c.Select(ctx, &ids, "SELECT id FROM user WHERE id >= 200 ORDER BY id"))
+	require.Equal(t, []int{200, 202}, ids)
+
+	err = c.Transactionx(ctx, func(tx *sqlx.Tx) error {
+		if err := insert(ContextWithTxx(ctx, tx), 300); err != nil {
+			return err
+		}
+		return errInner
+	})
+	require.ErrorContains(t, err, errInner.Error())
+	var n int
+	require.NoError(t, c.Get(ctx, &n, "SELECT COUNT(*) FROM user WHERE id = 300"))
+	require.Equal(t, 0, n)
+}
+
+func Test_runInSavepoint(t *testing.T) {
+	tx := new(sql.Tx)
+	patches := gomonkey.ApplyMethod(reflect.TypeOf(tx), "ExecContext",
+		func(_ *sql.Tx, _ context.Context, query string, _ ...interface{}) (sql.Result, error) {
+			return nil, errors.New("fake error")
+		})
+	defer patches.Reset()
+
+	err := runInSavepoint(context.Background(), tx, func() error { return nil })
+	require.EqualError(t, err, "create savepoint error: fake error")
+}
+
+func TestNestedTransaction_OtherDB(t *testing.T) {
+	db, other := mustNewTestingDB(), mustNewTestingDB()
+	defer db.Close()
+	defer other.Close()
+	ctx := context.Background()
+	newReq := func(fn TxFunc) *Request {
+		return &Request{tx: fn, op: opTransaction}
+	}
+
+	err := handleTransaction(ctx, db, newReq(func(outer *sql.Tx) error {
+		txCtx := ContextWithTx(ctx, outer)
+		// The calls to the same service and DSN are nested.
+		if err := handleTransaction(txCtx, db, newReq(func(tx *sql.Tx) error {
+			require.Same(t, outer, tx)
+			return nil
+		})); err != nil {
+			return err
+		}
+		// The calls to other databases begin transactions of their own.
+		return handleTransaction(txCtx, other, newReq(func(tx *sql.Tx) error {
+			require.NotSame(t, outer, tx)
+			return nil
+		}))
+	}))
+	require.NoError(t, err)
+	txDBs.Range(func(tx, _ interface{}) bool {
+		t.Errorf("transaction %v is not untracked", tx)
+		return true
+	})
+
+	// The transactions not begun by Transaction are never nested.
+	outer, err := db.Begin()
+	require.NoError(t, err)
+	defer outer.Rollback()
+	err = handleTransaction(ContextWithTx(ctx, outer), db, newReq(func(tx *sql.Tx) error {
+		require.NotSame(t, outer, tx)
+		return nil
+	}))
+	require.NoError(t, err)
+}
@@ -0,0 +1,114 @@
+package mysql
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-go/log"
+	"trpc.group/trpc-go/trpc-go/metrics"
+)
+
+// poolStatsMetricsName is the name of the metrics record of connection pool statistics.
+const poolStatsMetricsName = "trpc.MySQLPoolStats"
+
+// PoolStats is the statistics of a connection pool.
+type PoolStats struct {
+	ServiceName string // Callee service name, empty if the pool is not used through tRPC calls yet
+	DSN         string // Masked DSN
+	sql.DBStats
+	// StmtCache is the statistics of the prepared statement cache, zero if the cache is disabled.
+	StmtCache StmtCacheStats
+}
+
+// Stats returns the statistics of all connection pools, sorted by service name and DSN.
+// It can be used by health endpoints to observe pool exhaustion.
+func (ct *ClientTransport) Stats() []PoolStats {
+	ct.dblock.RLock()
+	stats := make([]PoolStats, 0, len(ct.dbs))
+	for key, db := range ct.dbs {
+		serviceName := ct.services[key]
+		s := PoolStats{
+			ServiceName: serviceName,
+			DSN:         mask(strings.TrimPrefix(key, serviceName+"|")),
+			DBStats:     db.Stats(),
+		}
+		if cache, ok := ct.stmtCaches[db]; ok {
+			s.StmtCache = cache.stats()
+		}
+		stats = append(stats, s)
+	}
+	ct.dblock.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].ServiceName != stats[j].ServiceName {
+			return stats[i].ServiceName < stats[j].ServiceName
+		}
+		return stats[i].DSN < stats[j].DSN
+	})
+	return stats
+}
+
+// ReportStats reports the statistics of all connection pools through trpc-go metrics,
+// labelled by the callee service and the masked DSN.
+func (ct *ClientTransport) ReportStats() {
+	for _, s := range ct.Stats() {
+		dimensions := []*metrics.Dimension{
+			{Name: "callee", Value: s.ServiceName},
+			{Name: "dsn", Value: s.DSN},
+		}
+		ms := []*metrics.Metrics{
+			metrics.NewMetrics("max_open_connections", float64(s.MaxOpenConnections), metrics.PolicySET),
+			metrics.NewMetrics("open_connections", float64(s.OpenConnections), metrics.PolicySET),
+			metrics.NewMetrics("in_use", float64(s.InUse), metrics.PolicySET),
+			metrics.NewMetrics("idle", float64(s.Idle), metrics.PolicySET),
+			metrics.NewMetrics("wait_count", float64(s.WaitCount), metrics.PolicySET),
+			metrics.NewMetrics("wait_duration_ms", float64(s.WaitDuration.Milliseconds()), metrics.PolicySET),
+			metrics.NewMetrics("max_idle_closed", float64(s.MaxIdleClosed), metrics.PolicySET),
+			metrics.NewMetrics("max_idle_time_closed", float64(s.MaxIdleTimeClosed), metrics.PolicySET),
+			metrics.NewMetrics("max_lifetime_closed", float64(s.MaxLifetimeClosed), metrics.PolicySET),
+			metrics.NewMetrics("stmt_cache_size", float64(s.StmtCache.Size), metrics.PolicySET),
+			metrics.NewMetrics("stmt_cache_hits", float64(s.StmtCache.Hits), metrics.PolicySET),
+			metrics.NewMetrics("stmt_cache_misses", float64(s.StmtCache.Misses), metrics.PolicySET),
+		}
+		if err := metrics.ReportMultiDimensionMetricsX(poolStatsMetricsName, dimensions, ms); err != nil {
+			log.Warnf("report mysql pool stats of %s err: %v", s.ServiceName, err)
+		}
+	}
+}
+
+// statsReporter reports the pool statistics of a ClientTransport periodically.
+type statsReporter struct {
+	done chan struct{}
+	once sync.Once
+}
+
+// startStatsReporter reports the pool statistics of ct every interval until the reporter is stopped.
+func startStatsReporter(ct *ClientTransport, interval time.Duration) *statsReporter {
+	r := &statsReporter{done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ct.ReportStats()
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// stop stops the reporter. It is safe to call stop on a nil reporter.
+func (r *statsReporter) stop() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		close(r.done)
+	})
+}
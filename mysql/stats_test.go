@@ -0,0 +1,84 @@
+package mysql
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"trpc.group/trpc-go/trpc-go/metrics"
+)
+
+type recordSink struct {
+	mu      sync.Mutex
+	records []metrics.Record
+}
+
+func (s *recordSink) Name() string {
+	return "mysql_test_record_sink"
+}
+
+func (s *recordSink) Report(rec metrics.Record, opts ...metrics.Option) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func (s *recordSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func newStatsTestTransport(t *testing.T) *ClientTransport {
+	ct := NewClientTransport().(*ClientTransport)
+	ct.DriverName = "sqlite3"
+	ct.PoolConfigs = map[string]PoolConfig{
+		"trpc.mysql.test.olap": {MaxOpen: 5, DriverName: "sqlite3"},
+	}
+	_, err := ct.GetServiceDB("trpc.mysql.test.oltp", "file:oltp?mode=memory")
+	require.NoError(t, err)
+	_, err = ct.GetServiceDB("trpc.mysql.test.olap", "file:olap?mode=memory")
+	require.NoError(t, err)
+	return ct
+}
+
+func TestClientTransport_Stats(t *testing.T) {
+	ct := newStatsTestTransport(t)
+	stats := ct.Stats()
+	require.Len(t, stats, 2)
+	require.Equal(t, "trpc.mysql.test.olap", stats[0].ServiceName)
+	require.Equal(t, mask("file:olap?mode=memory"), stats[0].DSN)
+	require.Equal(t, 5, stats[0].MaxOpenConnections)
+	require.Equal(t, "trpc.mysql.test.oltp", stats[1].ServiceName)
+	require.Equal(t, mask("file:oltp?mode=memory"), stats[1].DSN)
+	require.Equal(t, 10000, stats[1].MaxOpenConnections)
+}
+
+func TestClientTransport_ReportStats(t *testing.T) {
+	sink := &recordSink{}
+	metrics.RegisterMetricsSink(sink)
+
+	ct := newStatsTestTransport(t)
+	ct.ReportStats()
+	require.Len(t, sink.records, 2)
+	rec := sink.records[0]
+	require.Equal(t, poolStatsMetricsName, rec.GetName())
+	require.Equal(t, []*metrics.Dimension{
+		{Name: "callee", Value: "trpc.mysql.test.olap"},
+		{Name: "dsn", Value: mask("file:olap?mode=memory")},
+	}, rec.GetDimensions())
+	require.Equal(t, "max_open_connections", rec.GetMetrics()[0].Name())
+	require.Equal(t, float64(5), rec.GetMetrics()[0].Value())
+
+	r := startStatsReporter(ct, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return sink.count() > 2
+	}, time.Second, time.Millisecond)
+	r.stop()
+	r.stop()
+	var nilReporter *statsReporter
+	nilReporter.stop()
+}
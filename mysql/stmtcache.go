@@ -0,0 +1,157 @@
+package mysql
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// StmtCacheStats is the statistics of the prepared statement cache of a connection pool.
+type StmtCacheStats struct {
+	Size   int    // Number of cached statements
+	Hits   uint64 // Number of statements reused from the cache
+	Misses uint64 // Number of statements prepared because they are not in the cache
+}
+
+// HitRatio returns the ratio of hits to all lookups, 0 if there is no lookup yet.
+func (s StmtCacheStats) HitRatio() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// stmtCache is a LRU cache of the prepared statements of a connection pool.
+// Evicted statements are closed once they are no longer in use.
+type stmtCache struct {
+	mu     sync.Mutex
+	size   int
+	ll     *list.List // Elements are *cachedStmt, the most recently used at the front.
+	items  map[string]*list.Element
+	hits   uint64
+	misses uint64
+}
+
+// cachedStmt is a prepared statement in the cache.
+type cachedStmt struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// acquire returns the cached statement of query, or prepares and caches it on miss.
+// The statement must be released after use.
+func (c *stmtCache) acquire(ctx context.Context, db *sql.DB, query string) (*cachedStmt, error) {
+	c.mu.Lock()
+	if el, ok := c.items[query]; ok {
+		c.ll.MoveToFront(el)
+		s := el.Value.(*cachedStmt)
+		s.refs++
+		c.hits++
+		c.mu.Unlock()
+		return s, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[query]; ok {
+		// The statement has been prepared by a concurrent request.
+		stmt.Close()
+		s := el.Value.(*cachedStmt)
+		s.refs++
+		return s, nil
+	}
+	s := &cachedStmt{query: query, stmt: stmt, refs: 1}
+	c.items[query] = c.ll.PushFront(s)
+	for c.ll.Len() > c.size {
+		c.evict(c.ll.Back())
+	}
+	return s, nil
+}
+
+// release releases a statement returned by acquire.
+func (c *stmtCache) release(s *cachedStmt) {
+	c.mu.Lock()
+	s.refs--
+	closeNow := s.evicted && s.refs == 0
+	c.mu.Unlock()
+	if closeNow {
+		s.stmt.Close()
+	}
+}
+
+// purge evicts all statements, which is called when the connections are broken,
+// since the statements prepared on them are gone with the server sessions.
+func (c *stmtCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.ll.Len() > 0 {
+		c.evict(c.ll.Back())
+	}
+}
+
+// evict removes the element from the cache, and closes the statement if it is not in use.
+// It should be called with c.mu held.
+func (c *stmtCache) evict(el *list.Element) {
+	s := c.ll.Remove(el).(*cachedStmt)
+	delete(c.items, s.query)
+	s.evicted = true
+	if s.refs == 0 {
+		// Closing a statement does not block, since it only marks the statement closed
+		// and closes the server side statements on idle connections.
+		s.stmt.Close()
+	}
+}
+
+func (c *stmtCache) stats() StmtCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return StmtCacheStats{Size: c.ll.Len(), Hits: c.hits, Misses: c.misses}
+}
+
+// isBadConnError reports whether err means that the connection is broken.
+func isBadConnError(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// do runs fn with the cached prepared statement of query.
+// The statements are purged if the connection turns out to be broken.
+func (c *stmtCache) do(ctx context.Context, db *sql.DB, query string, fn func(stmt *sql.Stmt) error) error {
+	s, err := c.acquire(ctx, db, query)
+	if err == nil {
+		err = fn(s.stmt)
+		c.release(s)
+	}
+	if isBadConnError(err) {
+		c.purge()
+	}
+	return err
+}
+
+// getStmtCache returns the statement cache of db, nil if the cache is disabled.
+func (ct *ClientTransport) getStmtCache(db *sql.DB) *stmtCache {
+	ct.dblock.RLock()
+	defer ct.dblock.RUnlock()
+	return ct.stmtCaches[db]
+}
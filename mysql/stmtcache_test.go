@@ -0,0 +1,114 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newStmtCacheTestDB(t *testing.T, ct *ClientTransport) *sql.DB {
+	ct.DriverName = "sqlite3"
+	db, err := ct.GetDB("file:" + filepath.Join(t.TempDir(), "stmt.db"))
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE user (id INTEGER PRIMARY KEY, name TEXT)")
+	require.NoError(t, err)
+	return db
+}
+
+func TestStmtCache_LRU(t *testing.T) {
+	ct := NewClientTransport().(*ClientTransport)
+	db := newStmtCacheTestDB(t, ct)
+	ctx := context.Background()
+	c := newStmtCache(2)
+
+	queries := []string{"SELECT 1", "SELECT 2", "SELECT 1", "SELECT 3"}
+	stmts := make([]*cachedStmt, len(queries))
+	for i, q := range queries {
+		s, err := c.acquire(ctx, db, q)
+		require.NoError(t, err)
+		stmts[i] = s
+	}
+	require.Same(t, stmts[0], stmts[2])
+	// SELECT 2 is the least recently used one, which is evicted but not closed since it is in use.
+	require.True(t, stmts[1].evicted)
+	require.Equal(t, StmtCacheStats{Size: 2, Hits: 1, Misses: 3}, c.stats())
+	require.Equal(t, 0.25, c.stats().HitRatio())
+	var n int
+	require.NoError(t, stmts[1].stmt.QueryRow().Scan(&n))
+	require.Equal(t, 2, n)
+
+	for _, s := range stmts {
+		c.release(s)
+	}
+	require.Error(t, stmts[1].stmt.QueryRow().Scan(&n), "evicted statement should be closed after release")
+
+	c.purge()
+	require.Equal(t, 0, c.stats().Size)
+	require.Error(t, stmts[0].stmt.QueryRow().Scan(&n))
+	require.Equal(t, 0.0, StmtCacheStats{}.HitRatio())
+}
+
+func TestStmtCache_do(t *testing.T) {
+	ct := NewClientTransport().(*ClientTransport)
+	db := newStmtCacheTestDB(t, ct)
+	c := newStmtCache(10)
+	ctx := context.Background()
+
+	_, err := c.acquire(ctx, db, "SELECT 1")
+	require.NoError(t, err)
+	err = c.do(ctx, db, "SELECT 2", func(*sql.Stmt) error {
+		return driver.ErrBadConn
+	})
+	require.ErrorIs(t, err, driver.ErrBadConn)
+	require.Equal(t, 0, c.stats().Size, "statements should be purged on broken connections")
+
+	err = c.do(ctx, db, "SELECT * FROM nonexistent", func(*sql.Stmt) error {
+		return nil
+	})
+	require.Error(t, err)
+}
+
+func TestClientTransport_StmtCache(t *testing.T) {
+	ct := NewClientTransport().(*ClientTransport)
+	ct.StmtCacheSize = 10
+	db := newStmtCacheTestDB(t, ct)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		_, err := ct.handleExec(ctx, db, &Request{Exec: "INSERT INTO user (id, name) VALUES (?, ?)",
+			Args: []interface{}{i, "name"}})
+		require.NoError(t, err)
+	}
+	var name string
+	err := ct.handleQueryRow(ctx, db, &Request{Query: "SELECT name FROM user WHERE id = ?",
+		Args: []interface{}{1}, QueryRowDest: []interface{}{&name}})
+	require.NoError(t, err)
+	require.Equal(t, "name", name)
+	var ids []int
+	err = ct.handleQuery(ctx, db, &Request{Query: "SELECT id FROM user WHERE id > ?", Args: []interface{}{1},
+		next: func(rows *sql.Rows) error {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			return nil
+		}})
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, ids)
+
+	stats := ct.Stats()
+	require.Len(t, stats, 1)
+	require.Equal(t, StmtCacheStats{Size: 3, Hits: 2, Misses: 3}, stats[0].StmtCache)
+
+	ct.StmtCacheSize = 0
+	other, err := ct.GetDB("file:" + filepath.Join(t.TempDir(), "other.db"))
+	require.NoError(t, err)
+	require.Nil(t, ct.getStmtCache(other))
+	_, err = ct.handleExec(ctx, other, &Request{Exec: "SELECT 1"})
+	require.NoError(t, err)
+}
@@ -33,11 +33,41 @@ type ClientTransport struct {
 	opts   *transport.ClientTransportOptions
 	dbs    map[string]*sql.DB
 	dblock sync.RWMutex
+	// services are the callee service names keyed by the same keys as dbs.
+	services map[string]string
+	// stmtCaches are the prepared statement caches of the connection pools with cache enabled.
+	stmtCaches map[*sql.DB]*stmtCache
 
 	MaxIdle     int
 	MaxOpen     int
 	MaxLifetime time.Duration
+	MaxIdleTime time.Duration
 	DriverName  string
+
+	// StmtCacheSize is the maximum number of prepared statements cached per connection pool,
+	// which are reused by Exec, Query and QueryRow. 0 means no cache.
+	StmtCacheSize int
+
+	// PoolConfigs are the connection pool configurations keyed by callee service name,
+	// services without configuration use the pool settings above.
+	PoolConfigs map[string]PoolConfig
+
+	// SlowThreshold is the elapsed time over which statements are logged as slow queries, 0 means no logging.
+	SlowThreshold time.Duration
+}
+
+// PoolConfig is the connection pool configuration of a service.
+type PoolConfig struct {
+	MaxIdle     int
+	MaxOpen     int
+	MaxLifetime time.Duration
+	MaxIdleTime time.Duration
+	DriverName  string
+	// StmtCacheSize is the maximum number of cached prepared statements, 0 means no cache.
+	StmtCacheSize int
+	// TLSConfig is the name of the TLS config registered by mysql.RegisterTLSConfig,
+	// or one of "true", "skip-verify" and "preferred". It overrides the tls parameter of DSN if not empty.
+	TLSConfig string
 }
 
 // DefaultClientTransport default client mysql transport.
@@ -83,7 +113,7 @@ func (ct *ClientTransport) RoundTrip(ctx context.Context, reqBuf []byte,
 		o(opts)
 	}
 	dsn := opts.Address
-	db, err := ct.GetDB(dsn)
+	db, err := ct.GetServiceDB(msg.CalleeServiceName(), dsn)
 	if err != nil {
 		err = fmt.Errorf(
 			`err: %w,
@@ -93,7 +123,14 @@ if it is not what you want, it is possible that your client config is not loaded
 		return
 	}
 
+	begin := time.Now()
 	err = ct.runCommand(ctx, db, req, rsp)
+	if elapsed := time.Since(begin); ct.SlowThreshold > 0 && elapsed >= ct.SlowThreshold {
+		if query, args := statementOf(req); query != "" {
+			log.WarnContextf(ctx, "mysql slow query >= %v, callee: %s, cost: %v, sql: %s, args: %s, err: %v",
+				ct.SlowThreshold, msg.CalleeServiceName(), elapsed, digest(query), maskArgs(args), err)
+		}
+	}
 	postProcessing(msg, dsn)
 	return
 }
@@ -240,19 +277,24 @@ func (ct *ClientTransport) runCommand(ctx context.Context, db *sql.DB, req *Requ
 	case opTransactionx:
 		return ct.handleTransactionx(ctx, db, req)
 	case opQuery:
-		return handleQuery(ctx, db, req)
+		return ct.handleQuery(ctx, db, req)
 	case opQueryRow:
-		return handleQueryRow(ctx, db, req)
+		return ct.handleQueryRow(ctx, db, req)
+	case opQueryx:
+		return ct.handleQueryx(ctx, db, req)
 	case opQueryToStruct:
 		return ct.handleQueryToStruct(ctx, db, req)
 	case opQueryToStructs:
 		return handleQueryToStructs(ctx, db, req)
 	case opExec:
-		rsp.Result, err = handleExec(ctx, db, req)
+		rsp.Result, err = ct.handleExec(ctx, db, req)
 		return err
 	case opNamedExec:
 		rsp.Result, err = ct.handleNamedExec(ctx, db, req)
 		return err
+	case opBatchExec:
+		rsp.Result, err = handleBatchExec(ctx, db, req)
+		return err
 	case opSelect:
 		return ct.handleSelect(ctx, db, req)
 	case opGet:
@@ -266,10 +308,16 @@ func (ct *ClientTransport) runCommand(ctx context.Context, db *sql.DB, req *Requ
 }
 
 func handleTransaction(ctx context.Context, db *sql.DB, req *Request) (err error) {
+	if outer, ok := outerTxFromContext(ctx, db); ok {
+		return runInSavepoint(ctx, outer.tx, func() error {
+			return req.tx(outer.tx)
+		})
+	}
 	var tx *sql.Tx
 	if tx, err = db.BeginTx(ctx, req.txOpts); err != nil {
 		return
 	}
+	defer trackTx(tx, db)()
 	if err := req.tx(tx); err != nil {
 		if e := tx.Rollback(); e != nil {
 			return e
@@ -294,10 +342,17 @@ func (ct *ClientTransport) newSqlxDB(db *sql.DB, unsafe bool) *sqlx.DB {
 }
 
 func (ct *ClientTransport) handleTransactionx(ctx context.Context, db *sql.DB, req *Request) error {
+	if outer, ok := outerTxFromContext(ctx, db); ok {
+		tx := outer.sqlxTx(req.unsafe)
+		return runInSavepoint(ctx, tx.Tx, func() error {
+			return req.txx(tx)
+		})
+	}
 	tx, err := ct.newSqlxDB(db, req.unsafe).BeginTxx(ctx, req.txOpts)
 	if err != nil {
 		return fmt.Errorf("begin transaction error: %w", err)
 	}
+	defer trackTx(tx.Tx, db)()
 	if err := req.txx(tx); err != nil {
 		if e := tx.Rollback(); e != nil {
 			return fmt.Errorf("transaction error: %s, and rollback error: %w", err.Error(), e)
@@ -313,8 +368,17 @@ func (ct *ClientTransport) handleTransactionx(ctx context.Context, db *sql.DB, r
 	return nil
 }
 
-func handleExec(ctx context.Context, db *sql.DB, req *Request) (sql.Result, error) {
-	return db.ExecContext(ctx, req.Exec, req.Args...)
+func (ct *ClientTransport) handleExec(ctx context.Context, db *sql.DB, req *Request) (sql.Result, error) {
+	cache := ct.getStmtCache(db)
+	if cache == nil {
+		return db.ExecContext(ctx, req.Exec, req.Args...)
+	}
+	var result sql.Result
+	err := cache.do(ctx, db, req.Exec, func(stmt *sql.Stmt) (err error) {
+		result, err = stmt.ExecContext(ctx, req.Args...)
+		return
+	})
+	return result, err
 }
 
 func (ct *ClientTransport) handleNamedExec(ctx context.Context, db *sql.DB, req *Request) (sql.Result, error) {
@@ -325,17 +389,37 @@ func (ct *ClientTransport) handleNamedExec(ctx context.Context, db *sql.DB, req
 	return sqlxdb.NamedExecContext(ctx, req.Exec, req.Args[0])
 }
 
-func handleQueryRow(ctx context.Context, db *sql.DB, req *Request) (err error) {
-	row := db.QueryRowContext(ctx, req.Query, req.Args...)
-	return row.Scan(req.QueryRowDest...)
+func (ct *ClientTransport) handleQueryRow(ctx context.Context, db *sql.DB, req *Request) (err error) {
+	cache := ct.getStmtCache(db)
+	if cache == nil {
+		row := db.QueryRowContext(ctx, req.Query, req.Args...)
+		return row.Scan(req.QueryRowDest...)
+	}
+	return cache.do(ctx, db, req.Query, func(stmt *sql.Stmt) error {
+		return stmt.QueryRowContext(ctx, req.Args...).Scan(req.QueryRowDest...)
+	})
 }
 
-func handleQuery(ctx context.Context, db *sql.DB, req *Request) (err error) {
-	var rows *sql.Rows
-	rows, err = db.QueryContext(ctx, req.Query, req.Args...)
-	if err != nil {
-		return
+func (ct *ClientTransport) handleQuery(ctx context.Context, db *sql.DB, req *Request) (err error) {
+	cache := ct.getStmtCache(db)
+	if cache == nil {
+		var rows *sql.Rows
+		if rows, err = db.QueryContext(ctx, req.Query, req.Args...); err != nil {
+			return
+		}
+		return iterateRows(rows, req)
 	}
+	return cache.do(ctx, db, req.Query, func(stmt *sql.Stmt) error {
+		rows, err := stmt.QueryContext(ctx, req.Args...)
+		if err != nil {
+			return err
+		}
+		return iterateRows(rows, req)
+	})
+}
+
+// iterateRows calls req.next for each row until ErrBreak, and closes rows.
+func iterateRows(rows *sql.Rows, req *Request) (err error) {
 	defer rows.Close()
 	for rows.Next() {
 		err = req.next(rows)
@@ -350,6 +434,24 @@ func handleQuery(ctx context.Context, db *sql.DB, req *Request) (err error) {
 	return
 }
 
+func (ct *ClientTransport) handleQueryx(ctx context.Context, db *sql.DB, req *Request) error {
+	rows, err := ct.newSqlxDB(db, req.unsafe).QueryxContext(ctx, req.Query, req.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = req.nextx(rows)
+		if err == ErrBreak {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func (ct *ClientTransport) handleQueryToStruct(ctx context.Context, db *sql.DB, req *Request) error {
 	return ct.newSqlxDB(db, req.unsafe).QueryRowxContext(ctx, req.Query, req.Args...).StructScan(req.QueryToStructDest)
 }
@@ -382,8 +484,63 @@ func (ct *ClientTransport) handleNamedQuery(ctx context.Context, db *sql.DB, req
 
 // GetDB Get mysql link.
 func (ct *ClientTransport) GetDB(dsn string) (*sql.DB, error) {
+	return ct.getDB(dsn, dsn, PoolConfig{
+		MaxIdle:     ct.MaxIdle,
+		MaxOpen:     ct.MaxOpen,
+		MaxLifetime: ct.MaxLifetime,
+		MaxIdleTime: ct.MaxIdleTime,
+		DriverName:  ct.DriverName,
+
+		StmtCacheSize: ct.StmtCacheSize,
+	})
+}
+
+// GetServiceDB gets mysql link with the pool configuration of the callee service.
+// It is the same as GetDB if the service has no pool configuration.
+func (ct *ClientTransport) GetServiceDB(serviceName, dsn string) (*sql.DB, error) {
+	var (
+		key = dsn
+		db  *sql.DB
+		err error
+	)
+	if poolConfig, ok := ct.PoolConfigs[serviceName]; ok {
+		// The links of a service are separated from others, since the pool configurations are different.
+		key = serviceName + "|" + dsn
+		db, err = ct.getDB(key, dsn, poolConfig)
+	} else {
+		db, err = ct.GetDB(dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ct.bindService(key, serviceName)
+	return db, nil
+}
+
+// bindService records the callee service of the link, which is used to label the pool statistics.
+// Only the first service is recorded if several services share the same link.
+func (ct *ClientTransport) bindService(key, serviceName string) {
 	ct.dblock.RLock()
-	db, ok := ct.dbs[dsn]
+	_, ok := ct.services[key]
+	ct.dblock.RUnlock()
+	if ok {
+		return
+	}
+
+	ct.dblock.Lock()
+	defer ct.dblock.Unlock()
+	if _, ok := ct.services[key]; ok {
+		return
+	}
+	if ct.services == nil {
+		ct.services = make(map[string]string)
+	}
+	ct.services[key] = serviceName
+}
+
+func (ct *ClientTransport) getDB(key, dsn string, poolConfig PoolConfig) (*sql.DB, error) {
+	ct.dblock.RLock()
+	db, ok := ct.dbs[key]
 	ct.dblock.RUnlock()
 
 	if ok {
@@ -393,26 +550,43 @@ func (ct *ClientTransport) GetDB(dsn string) (*sql.DB, error) {
 	ct.dblock.Lock()
 	defer ct.dblock.Unlock()
 
-	db, ok = ct.dbs[dsn]
+	db, ok = ct.dbs[key]
 	if ok {
 		return db, nil
 	}
 
-	db, err := sql.Open(ct.DriverName, dsn)
+	if poolConfig.TLSConfig != "" {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLSConfig = poolConfig.TLSConfig
+		dsn = cfg.FormatDSN()
+	}
+	db, err := sql.Open(poolConfig.DriverName, dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if ct.MaxIdle > 0 {
-		db.SetMaxIdleConns(ct.MaxIdle)
+	if poolConfig.MaxIdle > 0 {
+		db.SetMaxIdleConns(poolConfig.MaxIdle)
+	}
+	if poolConfig.MaxOpen > 0 {
+		db.SetMaxOpenConns(poolConfig.MaxOpen)
 	}
-	if ct.MaxOpen > 0 {
-		db.SetMaxOpenConns(ct.MaxOpen)
+	if poolConfig.MaxLifetime > 0 {
+		db.SetConnMaxLifetime(poolConfig.MaxLifetime)
 	}
-	if ct.MaxLifetime > 0 {
-		db.SetConnMaxLifetime(ct.MaxLifetime)
+	if poolConfig.MaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(poolConfig.MaxIdleTime)
+	}
+	if poolConfig.StmtCacheSize > 0 {
+		if ct.stmtCaches == nil {
+			ct.stmtCaches = make(map[*sql.DB]*stmtCache)
+		}
+		ct.stmtCaches[db] = newStmtCache(poolConfig.StmtCacheSize)
 	}
 
-	ct.dbs[dsn] = db
+	ct.dbs[key] = db
 	return db, nil
 }
@@ -80,6 +80,41 @@ func TestUnit_ClientTransport_GetDB_P0(t *testing.T) {
 	})
 }
 
+// TestUnit_ClientTransport_GetServiceDB_P0 ClientTransport.GetServiceDB test case.
+func TestUnit_ClientTransport_GetServiceDB_P0(t *testing.T) {
+	Convey("TestUnit_ClientTransport_GetServiceDB_P0", t, func() {
+		ct := NewClientTransport().(*ClientTransport)
+		ct.PoolConfigs = map[string]PoolConfig{
+			"trpc.mysql.test.olap": {MaxOpen: 5, MaxIdleTime: time.Minute, DriverName: defaultDriverName,
+				TLSConfig: "skip-verify"},
+		}
+		var opened []string
+		openMock := gomonkey.ApplyFunc(sql.Open, func(driverName string, dataSourceName string) (*sql.DB, error) {
+			opened = append(opened, dataSourceName)
+			return new(sql.DB), nil
+		})
+		defer openMock.Reset()
+		Convey("Service Without Config", func() {
+			db, err := ct.GetServiceDB("trpc.mysql.test.oltp", dbDsn)
+			So(err, ShouldBeNil)
+			So(ct.dbs[dbDsn], ShouldEqual, db)
+			So(opened, ShouldResemble, []string{dbDsn})
+		})
+		Convey("Service With Config", func() {
+			db, err := ct.GetServiceDB("trpc.mysql.test.olap", dbDsn)
+			So(err, ShouldBeNil)
+			So(ct.dbs["trpc.mysql.test.olap|"+dbDsn], ShouldEqual, db)
+			So(opened, ShouldResemble, []string{dbDsn + "?tls=skip-verify"})
+			_, ok := ct.dbs[dbDsn]
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Invalid DSN With TLS", func() {
+			_, err := ct.GetServiceDB("trpc.mysql.test.olap", "invalid dsn")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 // TestUnit_ClientTransport_RT_P0 ClientTransport.RoundTrip test case.
 func TestUnit_ClientTransport_RT_P0(t *testing.T) {
 	Convey("TestUnit_ClientTransport_RT_P0", t, func() {